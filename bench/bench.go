@@ -0,0 +1,299 @@
+// Package bench is a load generator for NTP servers. It speaks plain
+// NTPv4 client mode on the wire, so it works against any server
+// implementation, not only ones built on this package.
+package bench
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/chaitanyav/ntp/internal/stats"
+)
+
+const packetSize = 48
+
+// Config describes a benchmark run.
+type Config struct {
+	// Server is a host or host:port, IPv6 addresses bracketed or not;
+	// port 123 is used when omitted.
+	Server string
+	// Duration is the total length of the run.
+	Duration time.Duration
+	// Concurrency is the number of outstanding queries (one socket
+	// each).
+	Concurrency int
+	// Timeout is how long a query may wait for its reply before it is
+	// counted as lost.
+	Timeout time.Duration
+	// Ramp chooses the offered rate over time.
+	Ramp Ramp
+	// Interval is the width of the per-interval breakdown in the
+	// report; one second when zero.
+	Interval time.Duration
+	// Version is the NTP version sent in requests; 4 when zero.
+	Version byte
+}
+
+// Latency summarizes the round-trip times of answered queries.
+type Latency struct {
+	Min  time.Duration `json:"min"`
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P99  time.Duration `json:"p99"`
+	Max  time.Duration `json:"max"`
+}
+
+// Interval is the breakdown of one slice of the run.
+type Interval struct {
+	Start    time.Duration `json:"start"`
+	Rate     float64       `json:"target_rate"`
+	Sent     uint64        `json:"sent"`
+	Received uint64        `json:"received"`
+	Lost     uint64        `json:"lost"`
+	Latency  Latency       `json:"latency"`
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	Server    string        `json:"server"`
+	Duration  time.Duration `json:"duration"`
+	Sent      uint64        `json:"sent"`
+	Received  uint64        `json:"received"`
+	Lost      uint64        `json:"lost"`
+	Errors    uint64        `json:"errors"`
+	Skipped   uint64        `json:"skipped"`
+	Loss      float64       `json:"loss"`
+	Latency   Latency       `json:"latency"`
+	Intervals []Interval    `json:"intervals"`
+}
+
+// bucket accumulates one interval of the run. Workers update it
+// concurrently without locking.
+type bucket struct {
+	sent, received, lost stats.Counter
+	rtt                  stats.Histogram
+}
+
+type recorder struct {
+	start   time.Time
+	width   time.Duration
+	buckets []bucket
+	all     stats.Histogram
+	errors  stats.Counter
+	skipped stats.Counter
+}
+
+func (r *recorder) bucket(at time.Time) *bucket {
+	i := int(at.Sub(r.start) / r.width)
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(r.buckets) {
+		i = len(r.buckets) - 1
+	}
+	return &r.buckets[i]
+}
+
+func (r *recorder) sent(at time.Time) {
+	r.bucket(at).sent.Inc()
+}
+
+func (r *recorder) received(at time.Time, rtt time.Duration) {
+	b := r.bucket(at)
+	b.received.Inc()
+	b.rtt.Observe(rtt)
+	r.all.Observe(rtt)
+}
+
+func (r *recorder) lost(at time.Time) {
+	r.bucket(at).lost.Inc()
+}
+
+func (r *recorder) failed() {
+	r.errors.Inc()
+}
+
+func (r *recorder) skip() {
+	r.skipped.Inc()
+}
+
+// Run offers load to cfg.Server until cfg.Duration has elapsed or ctx
+// is cancelled, and reports what came back.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.Server == "" {
+		return nil, errors.New("bench: no server")
+	}
+	if cfg.Duration <= 0 {
+		return nil, errors.New("bench: duration must be positive")
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Second
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Ramp == nil {
+		cfg.Ramp = Constant(10)
+	}
+	if cfg.Version == 0 {
+		cfg.Version = 4
+	}
+	addr := cfg.Server
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if len(addr) > 1 && addr[0] == '[' && addr[len(addr)-1] == ']' {
+			addr = addr[1 : len(addr)-1]
+		}
+		addr = net.JoinHostPort(addr, "123")
+	}
+
+	conns := make([]net.Conn, 0, cfg.Concurrency)
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	for i := 0; i < cfg.Concurrency; i++ {
+		c, err := net.Dial("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, c)
+	}
+
+	n := int((cfg.Duration + cfg.Interval - 1) / cfg.Interval)
+	rec := &recorder{start: time.Now(), width: cfg.Interval, buckets: make([]bucket, n)}
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	work := make(chan struct{}, cfg.Concurrency)
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c net.Conn) {
+			defer wg.Done()
+			worker(c, cfg, rec, work)
+		}(c)
+	}
+	dispatch(ctx, cfg.Ramp, rec, work)
+	close(work)
+	wg.Wait()
+
+	return rec.report(cfg), nil
+}
+
+// dispatch hands out send tokens at the rate the ramp asks for. Tokens
+// that find every worker busy are counted as skipped rather than
+// queued, so a slow server cannot make the offered load back up.
+func dispatch(ctx context.Context, ramp Ramp, rec *recorder, work chan<- struct{}) {
+	const tick = 5 * time.Millisecond
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	last := rec.start
+	credit := 0.0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			credit += ramp.Rate(now.Sub(rec.start)) * now.Sub(last).Seconds()
+			last = now
+			for ; credit >= 1; credit-- {
+				select {
+				case work <- struct{}{}:
+				default:
+					rec.skip()
+				}
+			}
+		}
+	}
+}
+
+func worker(c net.Conn, cfg Config, rec *recorder, work <-chan struct{}) {
+	req := make([]byte, packetSize)
+	resp := make([]byte, packetSize+1)
+	req[0] = cfg.Version<<3 | 3
+	for range work {
+		var nonce [8]byte
+		rand.Read(nonce[:])
+		copy(req[40:], nonce[:])
+
+		sent := time.Now()
+		if _, err := c.Write(req); err != nil {
+			rec.failed()
+			continue
+		}
+		rec.sent(sent)
+		c.SetReadDeadline(sent.Add(cfg.Timeout))
+		for {
+			n, err := c.Read(resp)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					rec.lost(sent)
+				} else {
+					rec.failed()
+				}
+				break
+			}
+			// Late replies to earlier, already-lost queries carry a
+			// different origin timestamp; keep waiting for ours.
+			if n < packetSize || string(resp[24:32]) != string(nonce[:]) {
+				continue
+			}
+			rec.received(sent, time.Since(sent))
+			break
+		}
+	}
+}
+
+func (r *recorder) report(cfg Config) *Report {
+	rep := &Report{
+		Server:   cfg.Server,
+		Duration: cfg.Duration,
+		Errors:   r.errors.Load(),
+		Skipped:  r.skipped.Load(),
+		Latency:  summarize(&r.all),
+	}
+	for i := range r.buckets {
+		b := &r.buckets[i]
+		start := time.Duration(i) * r.width
+		iv := Interval{
+			Start:    start,
+			Rate:     cfg.Ramp.Rate(start),
+			Sent:     b.sent.Load(),
+			Received: b.received.Load(),
+			Lost:     b.lost.Load(),
+			Latency:  summarize(&b.rtt),
+		}
+		rep.Intervals = append(rep.Intervals, iv)
+		rep.Sent += iv.Sent
+		rep.Received += iv.Received
+		rep.Lost += iv.Lost
+	}
+	if rep.Sent > 0 {
+		rep.Loss = float64(rep.Lost) / float64(rep.Sent)
+	}
+	return rep
+}
+
+func summarize(h *stats.Histogram) Latency {
+	if h.Count() == 0 {
+		return Latency{}
+	}
+	return Latency{
+		Min:  h.Min(),
+		Mean: h.Mean(),
+		P50:  h.Quantile(0.50),
+		P90:  h.Quantile(0.90),
+		P99:  h.Quantile(0.99),
+		Max:  h.Max(),
+	}
+}
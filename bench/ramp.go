@@ -0,0 +1,128 @@
+package bench
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ramp gives the target query rate (queries per second) at a point in
+// the run.
+type Ramp interface {
+	Rate(elapsed time.Duration) float64
+}
+
+// Constant sends at a fixed rate for the whole run.
+type Constant float64
+
+func (c Constant) Rate(elapsed time.Duration) float64 {
+	return float64(c)
+}
+
+// Linear climbs from From to To over Over and then holds To.
+type Linear struct {
+	From float64
+	To   float64
+	Over time.Duration
+}
+
+func (l Linear) Rate(elapsed time.Duration) float64 {
+	if l.Over <= 0 || elapsed >= l.Over {
+		return l.To
+	}
+	return l.From + (l.To-l.From)*float64(elapsed)/float64(l.Over)
+}
+
+// Step starts at From and adds By every Every, never exceeding Max
+// when Max is set.
+type Step struct {
+	From  float64
+	By    float64
+	Every time.Duration
+	Max   float64
+}
+
+func (s Step) Rate(elapsed time.Duration) float64 {
+	rate := s.From
+	if s.Every > 0 {
+		rate += s.By * float64(elapsed/s.Every)
+	}
+	if s.Max > 0 && rate > s.Max {
+		rate = s.Max
+	}
+	return rate
+}
+
+// ParseRamp parses the profile syntax used by the bench command:
+//
+//	constant:RATE
+//	linear:FROM-TO/OVER
+//	step:FROM+BY/EVERY[,MAX]
+//
+// A bare number is taken as a constant rate.
+func ParseRamp(s string) (Ramp, error) {
+	kind, spec, found := strings.Cut(s, ":")
+	if !found {
+		kind, spec = "constant", s
+	}
+	switch kind {
+	case "constant":
+		rate, err := parseRate(spec)
+		if err != nil {
+			return nil, err
+		}
+		return Constant(rate), nil
+	case "linear":
+		rates, over, ok := strings.Cut(spec, "/")
+		from, to, ok2 := strings.Cut(rates, "-")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("bench: linear ramp %q is not FROM-TO/OVER", spec)
+		}
+		var l Linear
+		var err error
+		if l.From, err = parseRate(from); err != nil {
+			return nil, err
+		}
+		if l.To, err = parseRate(to); err != nil {
+			return nil, err
+		}
+		if l.Over, err = time.ParseDuration(over); err != nil {
+			return nil, fmt.Errorf("bench: linear ramp: %v", err)
+		}
+		return l, nil
+	case "step":
+		rates, rest, ok := strings.Cut(spec, "/")
+		from, by, ok2 := strings.Cut(rates, "+")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("bench: step ramp %q is not FROM+BY/EVERY[,MAX]", spec)
+		}
+		every, max, hasMax := strings.Cut(rest, ",")
+		var st Step
+		var err error
+		if st.From, err = parseRate(from); err != nil {
+			return nil, err
+		}
+		if st.By, err = parseRate(by); err != nil {
+			return nil, err
+		}
+		if st.Every, err = time.ParseDuration(every); err != nil {
+			return nil, fmt.Errorf("bench: step ramp: %v", err)
+		}
+		if hasMax {
+			if st.Max, err = parseRate(max); err != nil {
+				return nil, err
+			}
+		}
+		return st, nil
+	}
+	return nil, fmt.Errorf("bench: unknown ramp profile %q", kind)
+}
+
+func parseRate(s string) (float64, error) {
+	rate, err := strconv.ParseFloat(s, 64)
+	if err != nil || rate < 0 {
+		return 0, fmt.Errorf("bench: invalid rate %q", s)
+	}
+	return rate, nil
+}
@@ -0,0 +1,103 @@
+package ntp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+	"github.com/chaitanyav/ntp/ntptest"
+)
+
+// packet is a reply with every field set, so that coding it touches the
+// whole header.
+var packet = ntp.DataPacket{
+	Byte1:               4<<3 | 4,
+	Stratum:             2,
+	Poll:                6,
+	Precision:           -20,
+	RootDelay:           ntp.NewNTPShort(12 * time.Millisecond),
+	RootDispersion:      ntp.NewNTPShort(3 * time.Millisecond),
+	ReferenceIdentifier: 0xc0000201,
+	ReferenceTimeStamp:  ntp.NewNTPTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)),
+	OriginateTimeStamp:  0x0123456789abcdef,
+	ReceiveTimeStamp:    ntp.NewNTPTime(time.Date(2026, 1, 1, 0, 0, 1, 0, time.UTC)),
+	TransmitTimeStamp:   ntp.NewNTPTime(time.Date(2026, 1, 1, 0, 0, 1, 500, time.UTC)),
+}
+
+func BenchmarkEncodePacket(b *testing.B) {
+	var buf [ntp.PACKET_SIZE]byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ntp.EncodePacket(buf[:], &packet)
+	}
+}
+
+func BenchmarkDecodePacket(b *testing.B) {
+	var buf [ntp.PACKET_SIZE]byte
+	ntp.EncodePacket(buf[:], &packet)
+	var p ntp.DataPacket
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := ntp.DecodePacket(buf[:], &p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetInto(b *testing.B) {
+	srv := ntptest.NewServer(ntptest.Config{})
+	defer srv.Close()
+	c := &ntp.Client{ReuseConn: true}
+	defer c.Close()
+	var r ntp.Response
+	var buf [ntp.PACKET_SIZE]byte
+	if err := c.GetInto(&r, srv.Addr, buf[:]); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.GetInto(&r, srv.Addr, buf[:]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPacketCodingAllocs(t *testing.T) {
+	var buf [ntp.PACKET_SIZE]byte
+	var p ntp.DataPacket
+	allocs := testing.AllocsPerRun(100, func() {
+		ntp.EncodePacket(buf[:], &packet)
+		ntp.DecodePacket(buf[:], &p)
+	})
+	if allocs != 0 {
+		t.Errorf("encoding and decoding a packet makes %v allocations, want 0", allocs)
+	}
+	if p != packet {
+		t.Errorf("decoded %+v, want %+v", p, packet)
+	}
+}
+
+func TestGetIntoAllocs(t *testing.T) {
+	srv := ntptest.NewServer(ntptest.Config{})
+	defer srv.Close()
+	c := &ntp.Client{ReuseConn: true, IntoTimeout: time.Second}
+	defer c.Close()
+	var r ntp.Response
+	var buf [ntp.PACKET_SIZE]byte
+	if err := c.GetInto(&r, srv.Addr, buf[:]); err != nil {
+		t.Fatal(err)
+	}
+	// AllocsPerRun counts every goroutine's allocations, the server's
+	// included, so this also holds ntptest to answering without garbage.
+	var err error
+	allocs := testing.AllocsPerRun(100, func() {
+		err = c.GetInto(&r, srv.Addr, buf[:])
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocs != 0 {
+		t.Errorf("GetInto on a kept socket makes %v allocations, want 0", allocs)
+	}
+}
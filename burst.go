@@ -0,0 +1,65 @@
+package ntp
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// BURST and BURST_INTERVAL are the defaults for Burst: ntpd's iburst,
+// eight requests two seconds apart.
+const (
+	BURST          = 8
+	BURST_INTERVAL = 2 * time.Second
+)
+
+// Burst queries server n times (BURST if n <= 0), starting a request
+// every interval (BURST_INTERVAL if interval <= 0), and returns the
+// replies in the order they came. A burst gives a clock filter enough
+// samples to pick a quiet one right away, rather than after n poll
+// intervals; it is what ntpd does when it first hears from an iburst
+// server. The options apply to each request, so WithTimeout bounds
+// each one rather than the burst.
+//
+// Lost requests are left out: Burst fails only if no request gets a
+// reply, with the last error. A kiss-of-death ends the burst early. If
+// ctx is done first, Burst returns the replies so far with ctx's
+// error.
+func (c *Client) Burst(ctx context.Context, server string, n int, interval time.Duration, opts ...Option) ([]*Response, error) {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		n = BURST
+	}
+	if interval <= 0 {
+		interval = BURST_INTERVAL
+	}
+	rs := make([]*Response, 0, n)
+	next := c.now()
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			next = next.Add(interval)
+			if err := c.sleep(ctx, next.Sub(c.now())); err != nil {
+				return rs, withCode(err)
+			}
+		}
+		var r *Response
+		r, err = c.query(ctx, server, o)
+		if err == nil {
+			rs = append(rs, r)
+			continue
+		}
+		if ctx.Err() != nil {
+			return rs, err
+		}
+		if errors.Is(err, ErrKissOfDeath) {
+			break
+		}
+	}
+	if len(rs) == 0 {
+		return nil, err
+	}
+	return rs, nil
+}
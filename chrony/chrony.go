@@ -0,0 +1,308 @@
+// Package chrony talks to chronyd over its command protocol, the one
+// chronyc uses, so that monitoring agents can read tracking and source
+// state without running chronyc and parsing its output.
+//
+// Only the read-only monitoring requests are implemented. chronyd
+// answers them on UDP port 323 from the hosts its cmdallow directives
+// permit (localhost by default), and on its Unix domain socket to
+// local users allowed to open it. The wire format follows candm.h of
+// chrony 4, protocol version 6.
+package chrony
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+)
+
+const (
+	protoVersion = 6
+
+	pktTypeRequest = 1
+	pktTypeReply   = 2
+
+	reqNSources    = 14
+	reqSourceData  = 15
+	reqTracking    = 33
+	reqSourceStats = 34
+
+	rpyNSources    = 2
+	rpySourceData  = 3
+	rpyTracking    = 5
+	rpySourceStats = 6
+
+	requestHeaderLen = 20
+	replyHeaderLen   = 28
+
+	// DefaultPort is chronyd's command port.
+	DefaultPort = 323
+	// DefaultSocket is where chronyd usually listens for local
+	// commands.
+	DefaultSocket = "/var/run/chrony/chronyd.sock"
+)
+
+// StatusError is a request chronyd refused, carrying the status code of
+// its reply.
+type StatusError uint16
+
+var statusText = map[StatusError]string{
+	1:  "failed",
+	2:  "not authorised",
+	3:  "invalid command",
+	4:  "no such source",
+	5:  "invalid timestamp",
+	6:  "facility not enabled",
+	7:  "bad subnet",
+	8:  "access allowed",
+	9:  "access denied",
+	10: "no command access from this host",
+	15: "source is inactive",
+	18: "protocol version mismatch",
+	19: "bad packet length",
+}
+
+func (e StatusError) Error() string {
+	if s, ok := statusText[e]; ok {
+		return "chrony: " + s
+	}
+	return "chrony: status " + strconv.Itoa(int(e))
+}
+
+func (e StatusError) code() ntp.Code {
+	switch e {
+	case 2, 9, 10:
+		return ntp.NTP_ERR_AUTH
+	case 6:
+		return ntp.NTP_ERR_UNSUPPORTED
+	case 3, 4, 15, 18, 19:
+		return ntp.NTP_ERR_CONFIG
+	}
+	return ntp.NTP_ERR_UNKNOWN
+}
+
+var errBadReply = &ntp.Error{Code: ntp.NTP_ERR_MALFORMED, Err: errors.New("chrony: malformed reply")}
+
+// Client sends monitoring requests to one chronyd. It is safe for
+// concurrent use; requests are serialized.
+type Client struct {
+	// Timeout bounds the wait for each attempt (default 1s).
+	Timeout time.Duration
+	// Attempts is how many times a request is sent before giving up
+	// (default 3), as chronyc retries over lossy links.
+	Attempts int
+
+	mu    sync.Mutex
+	conn  net.Conn
+	local string
+	seq   uint32
+	buf   [512]byte
+}
+
+// Dial connects to chronyd at address: a Unix socket path starting
+// with "/", or a host with an optional port (DefaultPort otherwise).
+func Dial(address string) (*Client, error) {
+	c := &Client{}
+	if strings.HasPrefix(address, "/") {
+		// chronyd replies to the client's socket path, so bind one the
+		// way chronyc does, next to the server's socket.
+		c.local = filepath.Join(filepath.Dir(address), fmt.Sprintf("chronyc.%d.%d.sock", os.Getpid(), time.Now().UnixNano()))
+		conn, err := net.DialUnix("unixgram", &net.UnixAddr{Name: c.local, Net: "unixgram"}, &net.UnixAddr{Name: address, Net: "unixgram"})
+		if err != nil {
+			return nil, coded(err)
+		}
+		os.Chmod(c.local, 0o666)
+		c.conn = conn
+	} else {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, strconv.Itoa(DefaultPort))
+		}
+		conn, err := net.Dial("udp", address)
+		if err != nil {
+			return nil, coded(err)
+		}
+		c.conn = conn
+	}
+	var seq [4]byte
+	rand.Read(seq[:])
+	c.seq = binary.BigEndian.Uint32(seq[:])
+	return c, nil
+}
+
+// Close closes the connection and removes the client's socket file.
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	if c.local != "" {
+		os.Remove(c.local)
+	}
+	return err
+}
+
+// request sends command with data and returns the data of the reply,
+// which must have type reply and at least want bytes. The result
+// aliases the client's buffer and is valid until the next request.
+func (c *Client) request(command uint16, data []byte, reply uint16, want int) ([]byte, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	attempts := c.Attempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+
+	// chronyd drops requests shorter than their reply, so that it
+	// cannot be used to amplify traffic; pad to the reply's length.
+	n := requestHeaderLen + len(data)
+	if n < replyHeaderLen+want {
+		n = replyHeaderLen + want
+	}
+	req := make([]byte, n)
+	req[0] = protoVersion
+	req[1] = pktTypeRequest
+	binary.BigEndian.PutUint16(req[4:], command)
+	copy(req[requestHeaderLen:], data)
+
+	c.seq++
+	seq := c.seq
+	binary.BigEndian.PutUint32(req[8:], seq)
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		binary.BigEndian.PutUint16(req[6:], uint16(attempt))
+		if _, err = c.conn.Write(req); err != nil {
+			return nil, coded(err)
+		}
+		deadline := time.Now().Add(timeout)
+		c.conn.SetReadDeadline(deadline)
+		for {
+			var m int
+			m, err = c.conn.Read(c.buf[:])
+			if err != nil {
+				break
+			}
+			rpy := c.buf[:m]
+			if m < replyHeaderLen || rpy[1] != pktTypeReply || binary.BigEndian.Uint32(rpy[16:]) != seq {
+				// Stale or foreign; keep waiting for ours.
+				continue
+			}
+			if rpy[0] != protoVersion {
+				return nil, &ntp.Error{Code: ntp.NTP_ERR_CONFIG, Err: fmt.Errorf("chrony: server speaks protocol version %d", rpy[0])}
+			}
+			if st := StatusError(binary.BigEndian.Uint16(rpy[8:])); st != 0 {
+				return nil, &ntp.Error{Code: st.code(), Err: st}
+			}
+			if binary.BigEndian.Uint16(rpy[6:]) != reply || m < replyHeaderLen+want {
+				return nil, errBadReply
+			}
+			return rpy[replyHeaderLen:], nil
+		}
+		var ne net.Error
+		if !errors.As(err, &ne) || !ne.Timeout() {
+			return nil, coded(err)
+		}
+	}
+	return nil, coded(err)
+}
+
+// coded attaches an ntp.Code to a socket error.
+func coded(err error) error {
+	return &ntp.Error{Code: ntp.CodeOf(err), Err: err}
+}
+
+// Tracking returns the state of the system clock, as "chronyc tracking"
+// shows it.
+func (c *Client) Tracking() (*Tracking, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, err := c.request(reqTracking, nil, rpyTracking, trackingLen)
+	if err != nil {
+		return nil, err
+	}
+	t := decodeTracking(d)
+	return &t, nil
+}
+
+// NumSources returns the number of time sources chronyd has.
+func (c *Client) NumSources() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, err := c.request(reqNSources, nil, rpyNSources, 4)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint32(d)), nil
+}
+
+// Source returns the source at index, from 0 to NumSources()-1, as a
+// line of "chronyc sources" shows it.
+func (c *Client) Source(index int) (*Source, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var req [4]byte
+	binary.BigEndian.PutUint32(req[:], uint32(index))
+	d, err := c.request(reqSourceData, req[:], rpySourceData, sourceLen)
+	if err != nil {
+		return nil, err
+	}
+	s := decodeSource(d)
+	return &s, nil
+}
+
+// SourceStats returns the measurement statistics of the source at
+// index, as a line of "chronyc sourcestats" shows them.
+func (c *Client) SourceStats(index int) (*SourceStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var req [4]byte
+	binary.BigEndian.PutUint32(req[:], uint32(index))
+	d, err := c.request(reqSourceStats, req[:], rpySourceStats, sourceStatsLen)
+	if err != nil {
+		return nil, err
+	}
+	s := decodeSourceStats(d)
+	return &s, nil
+}
+
+// Sources returns every source, like "chronyc sources".
+func (c *Client) Sources() ([]Source, error) {
+	n, err := c.NumSources()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Source, 0, n)
+	for i := 0; i < n; i++ {
+		s, err := c.Source(i)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *s)
+	}
+	return out, nil
+}
+
+// AllSourceStats returns the statistics of every source, like "chronyc
+// sourcestats".
+func (c *Client) AllSourceStats() ([]SourceStats, error) {
+	n, err := c.NumSources()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]SourceStats, 0, n)
+	for i := 0; i < n; i++ {
+		s, err := c.SourceStats(i)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *s)
+	}
+	return out, nil
+}
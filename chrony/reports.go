@@ -0,0 +1,265 @@
+package chrony
+
+import (
+	"encoding/binary"
+	"math"
+	"net/netip"
+	"time"
+)
+
+// Lengths of the reply data, up to but excluding the EOR marker.
+const (
+	ipAddrLen      = 20
+	trackingLen    = 4 + ipAddrLen + 4 + 12 + 9*4
+	sourceLen      = ipAddrLen + 12 + 4 + 3*4
+	sourceStatsLen = 4 + ipAddrLen + 12 + 5*4
+)
+
+// Leap is chronyd's leap second status.
+type Leap uint16
+
+const (
+	LeapNormal Leap = iota
+	LeapInsertSecond
+	LeapDeleteSecond
+	LeapUnsynchronised
+)
+
+var leapText = [...]string{"Normal", "Insert second", "Delete second", "Not synchronised"}
+
+func (l Leap) String() string {
+	if int(l) < len(leapText) {
+		return leapText[l]
+	}
+	return "Invalid"
+}
+
+// Tracking is the reply to a tracking request.
+type Tracking struct {
+	// RefID identifies the selected source; Addr is its address when it
+	// is an NTP server.
+	RefID   uint32
+	Addr    netip.Addr
+	Stratum int
+	Leap    Leap
+	// RefTime is when the last measurement from the source was used.
+	RefTime time.Time
+
+	// CurrentCorrection is the offset chronyd is still slewing out;
+	// positive means the system clock is slow.
+	CurrentCorrection time.Duration
+	LastOffset        time.Duration
+	RMSOffset         time.Duration
+	// Frequency is the rate error of the system clock in ppm, positive
+	// when it runs fast; ResidualFreq and Skew are the estimated
+	// residual error and error bound of that estimate.
+	Frequency    float64
+	ResidualFreq float64
+	Skew         float64
+
+	RootDelay      time.Duration
+	RootDispersion time.Duration
+	UpdateInterval time.Duration
+}
+
+// SourceState is what chronyd's source selection made of a source.
+type SourceState uint16
+
+const (
+	StateSelected SourceState = iota
+	StateNonselectable
+	StateFalseticker
+	StateJittery
+	StateUnselected
+	StateSelectable
+)
+
+// stateSymbols are the characters chronyc shows for each state.
+var stateSymbols = [...]string{"*", "?", "x", "~", "+", "-"}
+
+func (s SourceState) String() string {
+	if int(s) < len(stateSymbols) {
+		return stateSymbols[s]
+	}
+	return "?"
+}
+
+// SourceMode is how a source is polled.
+type SourceMode uint16
+
+const (
+	ModeClient SourceMode = iota
+	ModePeer
+	ModeRefclock
+)
+
+func (m SourceMode) String() string {
+	switch m {
+	case ModeClient:
+		return "^"
+	case ModePeer:
+		return "="
+	case ModeRefclock:
+		return "#"
+	}
+	return "?"
+}
+
+// Source flags.
+const (
+	FlagNoSelect = 1 << iota
+	FlagPrefer
+	FlagTrust
+	FlagRequire
+)
+
+// Source is the reply to a source data request.
+type Source struct {
+	// Addr is the address of an NTP source. For a reference clock,
+	// which chronyd reports as an IPv4 address made of its reference
+	// ID, Addr is left invalid and RefID holds that ID instead.
+	Addr  netip.Addr
+	RefID uint32
+
+	Poll    int // log2 seconds
+	Stratum int
+	State   SourceState
+	Mode    SourceMode
+	Flags   uint16
+	// Reach is the reachability register, most recent poll lowest.
+	Reach uint16
+	// SinceSample is the time since the last good sample.
+	SinceSample time.Duration
+
+	// OrigOffset is the offset of the last sample as measured and
+	// Offset the same adjusted for slewing since; as in chronyc,
+	// positive means the local clock is ahead of the source. Error is
+	// the measurement's error bound.
+	OrigOffset time.Duration
+	Offset     time.Duration
+	Error      time.Duration
+}
+
+// SourceStats is the reply to a sourcestats request.
+type SourceStats struct {
+	RefID uint32
+	Addr  netip.Addr
+
+	Samples int
+	Runs    int
+	Span    time.Duration
+
+	StdDev       time.Duration
+	ResidualFreq float64 // ppm
+	Skew         float64 // ppm
+	Offset       time.Duration
+	OffsetError  time.Duration
+}
+
+func decodeTracking(d []byte) Tracking {
+	return Tracking{
+		RefID:             binary.BigEndian.Uint32(d),
+		Addr:              decodeAddr(d[4:]),
+		Stratum:           int(binary.BigEndian.Uint16(d[24:])),
+		Leap:              Leap(binary.BigEndian.Uint16(d[26:])),
+		RefTime:           decodeTimespec(d[28:]),
+		CurrentCorrection: seconds(d[40:]),
+		LastOffset:        seconds(d[44:]),
+		RMSOffset:         seconds(d[48:]),
+		Frequency:         decodeFloat(d[52:]),
+		ResidualFreq:      decodeFloat(d[56:]),
+		Skew:              decodeFloat(d[60:]),
+		RootDelay:         seconds(d[64:]),
+		RootDispersion:    seconds(d[68:]),
+		UpdateInterval:    seconds(d[72:]),
+	}
+}
+
+func decodeSource(d []byte) Source {
+	s := Source{
+		Addr:        decodeAddr(d),
+		Poll:        int(int16(binary.BigEndian.Uint16(d[20:]))),
+		Stratum:     int(binary.BigEndian.Uint16(d[22:])),
+		State:       SourceState(binary.BigEndian.Uint16(d[24:])),
+		Mode:        SourceMode(binary.BigEndian.Uint16(d[26:])),
+		Flags:       binary.BigEndian.Uint16(d[28:]),
+		Reach:       binary.BigEndian.Uint16(d[30:]),
+		SinceSample: time.Duration(binary.BigEndian.Uint32(d[32:])) * time.Second,
+		OrigOffset:  seconds(d[36:]),
+		Offset:      seconds(d[40:]),
+		Error:       seconds(d[44:]),
+	}
+	if s.Mode == ModeRefclock || !s.Addr.IsValid() {
+		s.RefID = binary.BigEndian.Uint32(d)
+		s.Addr = netip.Addr{}
+	}
+	return s
+}
+
+func decodeSourceStats(d []byte) SourceStats {
+	return SourceStats{
+		RefID:        binary.BigEndian.Uint32(d),
+		Addr:         decodeAddr(d[4:]),
+		Samples:      int(binary.BigEndian.Uint32(d[24:])),
+		Runs:         int(binary.BigEndian.Uint32(d[28:])),
+		Span:         time.Duration(binary.BigEndian.Uint32(d[32:])) * time.Second,
+		StdDev:       seconds(d[36:]),
+		ResidualFreq: decodeFloat(d[40:]),
+		Skew:         decodeFloat(d[44:]),
+		Offset:       seconds(d[48:]),
+		OffsetError:  seconds(d[52:]),
+	}
+}
+
+// decodeAddr reads an IPAddr: 16 bytes of address, a family and two
+// bytes of padding. Families other than IPv4 and IPv6 give the zero
+// Addr.
+func decodeAddr(d []byte) netip.Addr {
+	switch binary.BigEndian.Uint16(d[16:]) {
+	case 1:
+		return netip.AddrFrom4([4]byte(d[:4]))
+	case 2:
+		return netip.AddrFrom16([16]byte(d[:16]))
+	}
+	return netip.Addr{}
+}
+
+// noHighSec in the high word of a Timespec means a 32-bit time.
+const noHighSec = 0x7fffffff
+
+func decodeTimespec(d []byte) time.Time {
+	hi := binary.BigEndian.Uint32(d)
+	sec := int64(binary.BigEndian.Uint32(d[4:]))
+	if hi != noHighSec {
+		sec |= int64(hi) << 32
+	}
+	nsec := int64(binary.BigEndian.Uint32(d[8:]))
+	if sec == 0 && nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, nsec)
+}
+
+// Floats are sent as a 7-bit signed exponent above a 25-bit signed
+// coefficient.
+const (
+	floatExpBits  = 7
+	floatCoefBits = 32 - floatExpBits
+)
+
+func decodeFloat(d []byte) float64 {
+	x := binary.BigEndian.Uint32(d)
+	exp := int(x >> floatCoefBits)
+	if exp >= 1<<(floatExpBits-1) {
+		exp -= 1 << floatExpBits
+	}
+	coef := int(x % (1 << floatCoefBits))
+	if coef >= 1<<(floatCoefBits-1) {
+		coef -= 1 << floatCoefBits
+	}
+	return math.Ldexp(float64(coef), exp-floatCoefBits)
+}
+
+func seconds(d []byte) time.Duration {
+	return time.Duration(decodeFloat(d) * float64(time.Second))
+}
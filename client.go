@@ -0,0 +1,884 @@
+package ntp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/chaitanyav/ntp/internal/sockts"
+)
+
+// Client queries NTP servers; Get is the usual entry point. The zero
+// value is ready to use; set ReuseConn to keep sockets open between
+// queries.
+//
+// A Client honours kiss-of-death replies: after DENY or RSTR it
+// refuses to query that server for a day, and after RATE for a minute
+// or so, doubling with each further RATE; a normal reply ends the hold.
+// Refused queries fail with the kiss's Code without sending anything.
+// Keep one Client for the life of the program so that it remembers.
+type Client struct {
+	// ReuseConn keeps one connected UDP socket per server open across
+	// queries. The server name is resolved once, when the socket is
+	// first dialed, and every query to it leaves from the same source
+	// port. Queries to the same server are serialized.
+	ReuseConn bool
+
+	// KernelTimestamps takes the receive time of replies from the
+	// kernel (SO_TIMESTAMPNS) where supported, instead of reading the
+	// clock after the goroutine wakes up. It falls back to the
+	// userspace clock elsewhere.
+	KernelTimestamps bool
+
+	// HardwareInterface names a NIC whose hardware timestamping should
+	// be used for replies (SO_TIMESTAMPING with SIOCSHWTSTAMP). The
+	// timestamps are read from the NIC's PTP hardware clock and mapped
+	// to system time. This needs CAP_NET_ADMIN and a driver with
+	// timestamping support; if either is missing the client falls back
+	// to kernel software timestamps. Linux only.
+	HardwareInterface string
+
+	// TransmitTimestamps reads the time each request actually left the
+	// host from the socket error queue (SCM_TSTAMP_SND) and reports it
+	// as Response.Sent, rather than the time Write was called. With
+	// HardwareInterface set the NIC's transmit timestamp is used when
+	// available. Linux only; elsewhere the time before Write is used.
+	TransmitTimestamps bool
+
+	// BusyPoll sets SO_BUSY_POLL: the kernel spins on the device
+	// queue for up to this long when a read would block, trading CPU
+	// for lower and steadier receive latency. Values above the
+	// net.core.busy_read sysctl need CAP_NET_ADMIN. Linux only.
+	BusyPoll time.Duration
+
+	// Priority sets SO_PRIORITY, the queueing priority of outgoing
+	// requests on the host (0-6 without CAP_NET_ADMIN). Linux only.
+	Priority int
+
+	// DSCP marks requests with this Differentiated Services code point
+	// (0-63), e.g. 46 for Expedited Forwarding, so that networks which
+	// prioritize NTP traffic recognize it. It sets IP_TOS or
+	// IPV6_TCLASS. Not supported on Windows, where marking goes
+	// through the QoS API instead.
+	DSCP int
+
+	// LocalOnly sends requests with an IP TTL (IPv6 hop limit) of 1,
+	// so they are dropped by the first router and cannot reach a time
+	// source beyond the local segment. Queries fail rather than go
+	// out unrestricted if the limit cannot be set, including when Dial
+	// returns something other than a *net.UDPConn.
+	LocalOnly bool
+
+	// PinnedIO runs every send, receive and timestamp read on one
+	// goroutine locked to its OS thread (runtime.LockOSThread), so the
+	// measurement path is never migrated between threads by the Go
+	// scheduler mid-exchange. Queries are then serialized across all
+	// servers.
+	PinnedIO bool
+
+	// PinThread, if set, is called once on the pinned thread before
+	// any I/O, e.g. to bind it to an isolated CPU with
+	// sched_setaffinity. If it fails, queries fail with its error
+	// until Close.
+	PinThread func() error
+
+	// Clock, if set, replaces the system clock for the send and
+	// receive times of each exchange, the pauses between retries and
+	// Burst requests, kiss-of-death holds, and a Monitor's polls.
+	// Kernel timestamps, when enabled and delivered, and the timeouts
+	// of queries, which are context deadlines, still come from the
+	// system clock.
+	Clock Clock
+
+	// Dial, if set, opens the transport to server instead of a UDP
+	// socket to its port 123. Any net.Conn that carries one datagram
+	// per Write and Read will do: a userspace network stack, a link
+	// over a serial modem, or ntptest.Pipe in tests. The socket
+	// options above only apply when Dial returns a *net.UDPConn.
+	Dial func(server string) (net.Conn, error)
+
+	// PacketConn, if set, carries every query instead of a socket of
+	// its own: an unconnected socket created by the caller, e.g. one
+	// from a userspace network stack, or a UDP socket bound and
+	// configured in ways the options here do not cover. Queries through
+	// it take turns, each one holding it from send to reply, and replies
+	// from addresses other than the server's are dropped. ReuseConn and
+	// the socket options above do not apply, and Close leaves it open.
+	// It takes precedence over Dial.
+	PacketConn net.PacketConn
+
+	// LookupHost, if set, resolves server names instead of the
+	// system resolver, e.g. to use DNS over TLS, a split-horizon
+	// view, or a fixed list of vetted addresses for a pool name. The
+	// first address of the queried family is used. The LookupHost
+	// method of a resolve.Resolver fits. It applies to PacketConn but
+	// not to Dial or Transport, which get the name as given.
+	LookupHost func(ctx context.Context, host string) ([]netip.Addr, error)
+
+	// DualStack races each query to a server name with both IPv6 and
+	// IPv4 addresses over both families and takes the first reply, so
+	// that a broken path on one costs nothing but the packet sent
+	// over it. With ReuseConn the kept socket then sticks to the
+	// family that won. Raced queries get none of the socket options
+	// or kernel timestamps above; queries under LocalOnly, WithNetwork
+	// or WithLocalAddr are not raced.
+	DualStack bool
+
+	// Transport, if set, carries every exchange through a callback
+	// instead of a socket; see Transport. It takes precedence over
+	// Dial and is how the client is used where the host supplies the
+	// network, e.g. in a browser or a WASI plugin.
+	Transport Transport
+
+	// MaxReferenceAge, if positive, rejects replies whose reference
+	// timestamp is more than this long before their transmit
+	// timestamp, with code NTP_ERR_STALE. A server that has lost its
+	// own sources keeps answering, its clock drifting freely, and says
+	// so only through the reference timestamp; a few times the
+	// server's poll interval (e.g. an hour) is a reasonable limit.
+	MaxReferenceAge time.Duration
+
+	// EraPivot, if set, decides which 136-year NTP era the server's
+	// timestamps fall in: the one within 68 years of it. By default
+	// the local clock decides, which works as long as it is within
+	// 68 years of the truth; set a recent date here on devices whose
+	// clock starts at 1970 (or any other epoch) on every boot.
+	EraPivot time.Time
+
+	// MaxRootDistance rejects replies whose RootDistance is above it,
+	// with code NTP_ERR_DISTANCE: such a server's time may be off by
+	// more than that. Zero means MAXDIST, RFC 5905's 1s; negative
+	// accepts any distance.
+	MaxRootDistance time.Duration
+
+	// SendClock puts the local clock reading in the reference and
+	// transmit timestamps of each request, as RFC 5905 describes. By
+	// default the transmit timestamp is 64 random bits, kept only to
+	// match the reply, and the reference timestamp is zero, so that
+	// requests say nothing about the local clock (see the IETF NTP
+	// data minimization draft). Servers only echo the field, so either
+	// works with every server.
+	SendClock bool
+
+	// Retries is how many times a query that times out is sent
+	// again, each time with a fresh transmit timestamp, before it
+	// fails; UDP loses the odd datagram as a matter of course. With
+	// retries each attempt waits RetryTimeout (default 1s) for its
+	// reply, and the whole query stays bounded by its context and
+	// WithTimeout. Only Get, QueryContext, Time and Query retry.
+	Retries int
+
+	// RetryTimeout bounds each attempt when Retries is set.
+	RetryTimeout time.Duration
+
+	// IntoTimeout bounds each QueryInto and GetInto, which take no
+	// context, from send to reply; a query whose reply is lost fails
+	// with code NTP_ERR_TIMEOUT after it. Zero waits for the reply
+	// however long it takes.
+	IntoTimeout time.Duration
+
+	// RetryBackoff is the pause before the first retry, doubling for
+	// each one after; zero resends straight away. RetryJitter, from 0
+	// to 1, moves each pause by up to that fraction of it either way,
+	// so that clients which lost the same reply do not retry in step.
+	RetryBackoff time.Duration
+	RetryJitter  float64
+
+	// PeerStatsDepth, if positive, keeps the latest that many replies
+	// of each server, as PeerStats, for dashboards that want history
+	// rather than just the latest reply.
+	PeerStatsDepth int
+
+	// OnQuery, if set, is called after every exchange and every
+	// attempt of one that is retried, with the server as named, its
+	// reply, and the error if there is no usable reply, e.g. to count
+	// them with package metrics. It runs on the querying goroutine and
+	// should return quickly. QueryInto and GetInto do not call it.
+	OnQuery func(server string, r *Response, err error)
+
+	// Tracer, if set, records each exchange and its stages as spans,
+	// e.g. through OpenTelemetry; see Tracer. QueryInto and GetInto
+	// are not traced.
+	Tracer Tracer
+
+	// Logger, if set, receives a line for each query and for each
+	// failure. A *log.Logger will do, or SlogLogger for structured
+	// records; the default is silence.
+	Logger Logger
+
+	pcMu   sync.Mutex // held by the exchange using PacketConn
+	mu     sync.Mutex
+	conns  map[string]*serverConn
+	phc    *sockts.PHC
+	pin    *pinned
+	kisses map[string]kissState
+	peers  map[string]*peerRing // history kept for PeerStatsDepth
+}
+
+// Logger is where a Client writes its log lines.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+var errLocalOnly = newError(NTP_ERR_CONFIG, "ntp: LocalOnly needs a UDP socket")
+
+type serverConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// pinned is the goroutine behind PinnedIO.
+type pinned struct {
+	work chan func()
+	quit chan struct{}
+	err  error
+}
+
+var errPinnedClosed = newError(NTP_ERR_CLOSED, "ntp: client closed during query")
+
+// runPinned runs fn on the pinned goroutine, starting it if needed,
+// and waits for it to finish.
+func (c *Client) runPinned(fn func()) error {
+	c.mu.Lock()
+	p := c.pin
+	if p == nil {
+		p = &pinned{work: make(chan func()), quit: make(chan struct{})}
+		ready := make(chan struct{})
+		go func() {
+			runtime.LockOSThread()
+			if c.PinThread != nil {
+				p.err = c.PinThread()
+			}
+			close(ready)
+			for {
+				select {
+				case fn := <-p.work:
+					fn()
+				case <-p.quit:
+					return
+				}
+			}
+		}()
+		<-ready
+		c.pin = p
+	}
+	c.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	done := make(chan struct{})
+	select {
+	case p.work <- func() { fn(); close(done) }:
+	case <-p.quit:
+		return errPinnedClosed
+	}
+	<-done
+	return nil
+}
+
+// Response is the outcome of one exchange with a server. Most callers
+// only need the fields up to Poll, which are derived from Packet
+// and already in Go types.
+type Response struct {
+	// Time is the server's transmit time. For the current time, add
+	// ClockOffset to the local clock instead: Time is already old by
+	// the time the reply has been read.
+	Time time.Time
+	// ClockOffset is how far the server's clock is ahead of the local
+	// one, and RTT the round trip excluding the server's processing.
+	ClockOffset time.Duration
+	RTT         time.Duration
+
+	Stratum uint8
+	// ReferenceID is the reference ID of the reply as given by
+	// DataPacket.DecodeReferenceIdentifier.
+	ReferenceID string
+	// RootDelay and RootDispersion are the server's own round trip to
+	// and error bound against the primary reference.
+	RootDelay      time.Duration
+	RootDispersion time.Duration
+	// Leap is the leap indicator. Replies with LeapAlarm are
+	// rejected, so it is one of the other three.
+	Leap LeapIndicator
+	// Precision is the resolution of the server's clock.
+	Precision time.Duration
+	// Poll is the interval the server suggests between requests.
+	Poll time.Duration
+
+	// Packet is the server's reply as decoded from the wire, and Addr
+	// the address it came from, e.g. "192.0.2.1:123".
+	Packet DataPacket
+	Addr   string
+
+	// Sent is when the request left the host and Received when the
+	// reply arrived, both by the client's clock (or kernel timestamps,
+	// when enabled).
+	Sent     time.Time
+	Received time.Time
+
+	// ReferenceAge is how long before replying the server last set
+	// its clock from its source: the transmit timestamp less the
+	// reference timestamp. It is the largest Duration for a server
+	// that has never synchronized.
+	ReferenceAge time.Duration
+}
+
+// RootDistance is the synchronization distance of RFC 5905, an upper
+// bound on how far the server's time may be from the primary
+// reference's when the reply arrived: half the round trip to the
+// primary reference (the root delay plus RTT, at least MINDISP), plus
+// the root dispersion, plus what the exchange added to it (the server's
+// precision and PHI times RTT). It grows by PHI per second after that.
+func (r *Response) RootDistance() time.Duration {
+	delay := max(r.RootDelay+r.RTT, MINDISP)
+	disp := r.Precision + time.Duration(PHI*float64(r.RTT))
+	return delay/2 + r.RootDispersion + disp
+}
+
+// Get queries server with a client request, version 4 unless
+// WithVersion says otherwise. Options adjust the request and the
+// socket it is sent from; the port, network, TTL and local address
+// only apply to the client's own UDP sockets, not to Dial or
+// Transport. server is a host name or an IP address, optionally with
+// a port ("host:1123", "[2001:db8::1]:1123"); IPv6 addresses without a
+// port may be bracketed or not. An empty server stands for those of
+// NTP_SERVERS, tried in turn until one answers; see DefaultServers.
+// NTP_TIMEOUT and NTP_VERSION set the defaults of the options.
+func (c *Client) Get(server string, opts ...Option) (*Response, error) {
+	return c.QueryContext(context.Background(), server, opts...)
+}
+
+// QueryContext is Get bounded by ctx: the query fails with ctx's error
+// once it is done, even mid-read. A Transport callback that is already
+// running cannot be interrupted; it should watch for cancellation
+// itself.
+func (c *Client) QueryContext(ctx context.Context, server string, opts ...Option) (*Response, error) {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if server == "" {
+		return c.queryDefault(ctx, o)
+	}
+	return c.query(ctx, server, o)
+}
+
+// queryDefault queries the servers of NTP_SERVERS in turn, each bounded
+// by o's timeout, and returns the first reply.
+func (c *Client) queryDefault(ctx context.Context, o *options) (*Response, error) {
+	servers := DefaultServers()
+	if len(servers) == 0 {
+		return nil, errNoServers
+	}
+	var err error
+	for _, server := range servers {
+		var r *Response
+		if r, err = c.query(ctx, server, o); err == nil || ctx.Err() != nil {
+			return r, err
+		}
+	}
+	return nil, err
+}
+
+// query sends one client request as o describes.
+func (c *Client) query(ctx context.Context, server string, o *options) (*Response, error) {
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	return c.do(ctx, DataPacket{Byte1: byte(o.version)<<3 | 3}, server, o)
+}
+
+// Time queries server and returns the current time by its clock: the
+// local clock corrected by the measured offset.
+func (c *Client) Time(server string, opts ...Option) (time.Time, error) {
+	r, err := c.Get(server, opts...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return c.now().Add(r.ClockOffset), nil
+}
+
+// Query sends packet to server like the package-level Query.
+//
+// Deprecated: Use Get.
+func (c *Client) Query(packet DataPacket, server string) (*DataPacket, error) {
+	r, err := c.do(context.Background(), packet, server, nil)
+	if err != nil {
+		return nil, err
+	}
+	ClientReceiveTimeStamp, ClientTransmitTimeStamp = r.Received, r.Sent
+	return &r.Packet, nil
+}
+
+// do runs one exchange, retrying as Retries says; o is nil for the
+// default options.
+func (c *Client) do(ctx context.Context, packet DataPacket, server string, o *options) (*Response, error) {
+	if c.Retries <= 0 {
+		return c.doOnce(ctx, packet, server, o)
+	}
+	for n := 0; ; n++ {
+		actx, cancel := context.WithTimeout(ctx, c.retryTimeout())
+		r, err := c.doOnce(actx, packet, server, o)
+		cancel()
+		if n == c.Retries || CodeOf(err) != NTP_ERR_TIMEOUT || ctx.Err() != nil {
+			return r, err
+		}
+		wait := c.retryWait(n)
+		c.infof("no reply from %s; retrying in %v\n", server, wait)
+		if err := c.sleep(ctx, wait); err != nil {
+			return nil, withCode(err)
+		}
+	}
+}
+
+// doOnce runs a single exchange and reports it to Tracer and OnQuery.
+func (c *Client) doOnce(ctx context.Context, packet DataPacket, server string, o *options) (*Response, error) {
+	qt, end := c.startQuery(ctx, server)
+	r, err := c.attempt(ctx, packet, server, o, qt)
+	if end != nil {
+		end(r, err)
+	}
+	if c.OnQuery != nil {
+		c.OnQuery(server, r, err)
+	}
+	return r, err
+}
+
+func (c *Client) attempt(ctx context.Context, packet DataPacket, server string, o *options, qt *queryTrace) (*Response, error) {
+	if err := c.backingOff(server); err != nil {
+		return nil, err
+	}
+	var dialed time.Time
+	if qt != nil {
+		dialed = time.Now()
+	}
+	sc, conn, err := c.acquire(ctx, server, o)
+	qt.stage("ntp.dial", dialed, time.Now(), err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, withCode(err)
+	}
+	var stop func() bool
+	var cut chan struct{}
+	if ctx.Done() != nil {
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		cut = make(chan struct{})
+		stop = context.AfterFunc(ctx, func() {
+			conn.SetDeadline(time.Unix(1, 0))
+			close(cut)
+		})
+	}
+	r, err := c.exchange(conn, packet, server, qt)
+	c.noteReply(server, err)
+	if err == nil {
+		r.Addr = conn.RemoteAddr().String()
+	}
+	relErr := err
+	if stop != nil {
+		if !stop() {
+			// The deadline was cut short, so the socket may be
+			// left unusable whether or not the reply made it.
+			// Wait for that to finish so it cannot land on a
+			// PacketConn already handed back.
+			<-cut
+			if err != nil {
+				err = ctx.Err()
+			}
+			relErr = ctx.Err()
+		} else if sc != nil {
+			conn.SetDeadline(time.Time{})
+		}
+	}
+	c.release(sc, conn, relErr)
+	if err == nil {
+		if err := c.checkResponse(server, r); err != nil {
+			return nil, err
+		}
+		c.notePeerStat(server, r)
+	}
+	return r, withCode(err)
+}
+
+// eraPivot returns the time the era of the server's timestamps is
+// chosen by, given the local receive time.
+func (c *Client) eraPivot(received time.Time) time.Time {
+	if !c.EraPivot.IsZero() {
+		return c.EraPivot
+	}
+	return received
+}
+
+// checkResponse applies the client's limits on reference age and root
+// distance to r.
+func (c *Client) checkResponse(server string, r *Response) error {
+	if c.MaxReferenceAge > 0 && r.ReferenceAge > c.MaxReferenceAge {
+		return staleError(server, r.ReferenceAge)
+	}
+	limit := c.MaxRootDistance
+	if limit == 0 {
+		limit = MAXDIST
+	}
+	if d := r.RootDistance(); limit > 0 && d > limit {
+		return &Error{Code: NTP_ERR_DISTANCE, Err: fmt.Errorf("ntp: %s has a root distance of %v, above %v", server, d, limit)}
+	}
+	return nil
+}
+
+func staleError(server string, age time.Duration) error {
+	if age == maxDuration {
+		return &Error{Code: NTP_ERR_STALE, Err: fmt.Errorf("ntp: %s has never synchronized", server)}
+	}
+	return &Error{Code: NTP_ERR_STALE, Err: fmt.Errorf("ntp: %s last synchronized %v before replying", server, age.Round(time.Second))}
+}
+
+// QueryInto is Query for callers that poll at a high rate or cannot
+// afford garbage. The request is taken from req (whose reference and
+// transmit timestamps are set as SendClock says), the reply is decoded
+// into resp, and buf, which must hold at least PACKET_SIZE bytes, is
+// used for both.
+// With ReuseConn set, a query on an established socket makes no heap
+// allocations when kernel timestamps are off. QueryInto does not log.
+// The send and receive times are only available from the deprecated
+// package variables; GetInto returns them with the reply instead.
+// Without IntoTimeout, a lost reply leaves it waiting for good.
+// Like the other queries, it waits for the reply that echoes the
+// request's transmit timestamp; unlike them, it hands that reply back
+// as is, without checking its mode, version, stratum or leap indicator.
+func (c *Client) QueryInto(req *DataPacket, server string, resp *DataPacket, buf []byte) error {
+	sent, received, err := c.queryInto(req, server, resp, buf)
+	if err != nil {
+		return err
+	}
+	ClientReceiveTimeStamp, ClientTransmitTimeStamp = received, sent
+	return nil
+}
+
+// GetInto is Get without garbage, for the same callers as QueryInto:
+// it sends a version 4 client request and fills in resp, using buf
+// (at least PACKET_SIZE bytes) for the packets. resp.ReferenceID is
+// left empty, since building it allocates; the reference ID is in
+// resp.Packet.
+func (c *Client) GetInto(resp *Response, server string, buf []byte) error {
+	if err := c.backingOff(server); err != nil {
+		return err
+	}
+	// The request is encoded into buf before the reply is decoded, so
+	// resp.Packet can hold both without a request escaping to the heap.
+	resp.Packet = DataPacket{Byte1: 4<<3 | 3}
+	resp.ReferenceID = ""
+	var err error
+	resp.Sent, resp.Received, err = c.queryInto(&resp.Packet, server, &resp.Packet, buf)
+	if err != nil {
+		return err
+	}
+	err = checkReply(4<<3|3, &resp.Packet)
+	c.noteReply(server, err)
+	if err != nil {
+		return err
+	}
+	resp.annotate(c.eraPivot(resp.Received))
+	return c.checkResponse(server, resp)
+}
+
+func (c *Client) queryInto(req *DataPacket, server string, resp *DataPacket, buf []byte) (time.Time, time.Time, error) {
+	if len(buf) < PACKET_SIZE {
+		return time.Time{}, time.Time{}, errShortBuffer
+	}
+	sc, conn, err := c.acquire(context.Background(), server, nil)
+	if err != nil {
+		return time.Time{}, time.Time{}, withCode(err)
+	}
+	if c.IntoTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.IntoTimeout))
+	}
+	sent, received, _, err := c.roundTrip(conn, req, resp, buf, nil)
+	if c.IntoTimeout > 0 && sc != nil && err == nil {
+		conn.SetDeadline(time.Time{})
+	}
+	c.release(sc, conn, err)
+	return sent, received, withCode(err)
+}
+
+// QueryInto is Client.QueryInto on a fresh socket.
+func QueryInto(req *DataPacket, server string, resp *DataPacket, buf []byte) error {
+	return new(Client).QueryInto(req, server, resp, buf)
+}
+
+// acquire returns a socket for server: the kept one (locked for the
+// caller) with ReuseConn, or a new one.
+func (c *Client) acquire(ctx context.Context, server string, o *options) (*serverConn, net.Conn, error) {
+	if !c.ReuseConn || c.PacketConn != nil {
+		conn, err := c.dial(ctx, server, o)
+		return nil, conn, err
+	}
+	sc := c.serverConn(o.key(server))
+	sc.mu.Lock()
+	if sc.conn == nil {
+		conn, err := c.dial(ctx, server, o)
+		if err != nil {
+			sc.mu.Unlock()
+			return nil, nil, err
+		}
+		sc.conn = conn
+	}
+	return sc, sc.conn, nil
+}
+
+// release gives back a socket from acquire once the exchange on it has
+// finished with err.
+func (c *Client) release(sc *serverConn, conn net.Conn, err error) {
+	if sc == nil {
+		conn.Close()
+		return
+	}
+	if err != nil {
+		// The socket may be unusable (e.g. ICMP port unreachable
+		// latched on it); dial afresh next time.
+		sc.conn.Close()
+		sc.conn = nil
+	}
+	sc.mu.Unlock()
+}
+
+func (c *Client) dial(ctx context.Context, server string, o *options) (net.Conn, error) {
+	if c.Transport != nil {
+		if c.LocalOnly {
+			return nil, errLocalOnly
+		}
+		return &transportConn{t: c.Transport, server: server}, nil
+	}
+	var conn net.Conn
+	var err error
+	if c.PacketConn != nil {
+		var addr string
+		addr, err = c.resolve(ctx, o.address(server), o.udp())
+		if err == nil {
+			conn, err = c.dialShared(addr, o.udp())
+		}
+	} else if c.Dial != nil {
+		conn, err = c.Dial(server)
+	} else {
+		var d net.Dialer
+		if o != nil && o.localAddr != nil {
+			d.LocalAddr = o.localAddr
+		}
+		conn, err = c.dialUDP(ctx, &d, server, o)
+	}
+	if err != nil {
+		c.logf("error on connecting to NTP Server: %v\n", err)
+		return nil, err
+	}
+	uc, ok := conn.(*net.UDPConn)
+	if !ok {
+		if c.LocalOnly {
+			conn.Close()
+			return nil, errLocalOnly
+		}
+		return conn, nil
+	}
+	if c.LocalOnly {
+		if err := setHopLimit(uc, 1); err != nil {
+			c.logf("error on limiting the query to the local network: %v\n", err)
+			conn.Close()
+			return nil, err
+		}
+	} else if o != nil && o.ttl != 0 {
+		if err := setHopLimit(uc, o.ttl); err != nil {
+			c.logf("error on setting the TTL: %v\n", err)
+			conn.Close()
+			return nil, err
+		}
+	}
+	hw := false
+	if c.HardwareInterface != "" {
+		err := sockts.EnableHardware(uc, c.HardwareInterface)
+		if err == nil {
+			err = c.openPHC()
+		}
+		if err == nil {
+			hw = true
+		} else if err != sockts.ErrUnsupported {
+			c.logf("error on enabling hardware timestamps: %v\n", err)
+		}
+	}
+	rx := c.KernelTimestamps || c.HardwareInterface != ""
+	if err := sockts.Enable(uc, hw, rx, c.TransmitTimestamps); err != nil && err != sockts.ErrUnsupported {
+		c.logf("error on enabling kernel timestamps: %v\n", err)
+	}
+	if err := setLowLatency(uc, c.BusyPoll, c.Priority); err != nil {
+		c.logf("error on setting socket options: %v\n", err)
+	}
+	if err := setDSCP(uc, c.DSCP); err != nil {
+		c.logf("error on setting DSCP: %v\n", err)
+	}
+	return conn, nil
+}
+
+// dialUDP dials server with d, racing its IPv6 and IPv4 addresses
+// under DualStack.
+func (c *Client) dialUDP(ctx context.Context, d *net.Dialer, server string, o *options) (net.Conn, error) {
+	addr := o.address(server)
+	if c.DualStack && !c.LocalOnly && o.udp() == "udp" && d.LocalAddr == nil {
+		v6, v4, ok, err := c.raceAddrs(ctx, addr)
+		switch {
+		case err != nil:
+			return nil, err
+		case ok:
+			return dialRace(v6, v4)
+		case v6.IsValid():
+			addr = v6.String()
+		case v4.IsValid():
+			addr = v4.String()
+		}
+	} else {
+		var err error
+		if addr, err = c.resolve(ctx, addr, o.udp()); err != nil {
+			return nil, err
+		}
+	}
+	return d.DialContext(ctx, o.udp(), addr)
+}
+
+var errNoAddress = newError(NTP_ERR_RESOLVE, "ntp: no address of the requested family")
+
+// resolve looks up the host of addr with LookupHost, if set and the
+// host is not already an IP address, and returns addr with the host
+// replaced by the first address suitable for network.
+func (c *Client) resolve(ctx context.Context, addr, network string) (string, error) {
+	if c.LookupHost == nil {
+		return addr, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return addr, nil
+	}
+	ips, err := c.LookupHost(ctx, host)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", &Error{Code: NTP_ERR_RESOLVE, Err: err}
+	}
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		if network == "udp" || (network == "udp4") == ip.Is4() {
+			return net.JoinHostPort(ip.String(), port), nil
+		}
+	}
+	return "", errNoAddress
+}
+
+func (c *Client) openPHC() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.phc != nil {
+		return nil
+	}
+	phc, err := sockts.OpenPHC(c.HardwareInterface)
+	if err != nil {
+		return err
+	}
+	c.phc = phc
+	return nil
+}
+
+// read reads one datagram from conn and returns it with its receive
+// time.
+func (c *Client) read(conn net.Conn, buf []byte) (int, time.Time, error) {
+	if c.KernelTimestamps || c.HardwareInterface != "" || c.TransmitTimestamps {
+		if uc, ok := conn.(*net.UDPConn); ok {
+			return sockts.ReadRX(uc, buf, c.hardwareClock())
+		}
+	}
+	n, err := conn.Read(buf)
+	return n, c.now(), err
+}
+
+func (c *Client) now() time.Time {
+	return c.clock().Now()
+}
+
+// clock returns c.Clock, or SystemClock if it is not set.
+func (c *Client) clock() Clock {
+	if c.Clock != nil {
+		return c.Clock
+	}
+	return SystemClock
+}
+
+// transmitTime returns when the last request on conn left the host,
+// or sent if the kernel did not report it.
+func (c *Client) transmitTime(conn net.Conn, sent time.Time) time.Time {
+	if c.TransmitTimestamps {
+		if uc, ok := conn.(*net.UDPConn); ok {
+			if t, ok := sockts.ReadTX(uc, c.hardwareClock()); ok {
+				return t
+			}
+		}
+	}
+	return sent
+}
+
+func (c *Client) hardwareClock() *sockts.PHC {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.phc
+}
+
+func (c *Client) serverConn(server string) *serverConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conns == nil {
+		c.conns = make(map[string]*serverConn)
+	}
+	sc, ok := c.conns[server]
+	if !ok {
+		sc = &serverConn{}
+		c.conns[server] = sc
+	}
+	return sc
+}
+
+// Close closes any sockets kept open by ReuseConn and the hardware
+// clock opened for HardwareInterface. The Client can be used again
+// afterwards.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	conns := c.conns
+	c.conns = nil
+	phc := c.phc
+	c.phc = nil
+	if c.pin != nil {
+		close(c.pin.quit)
+		c.pin = nil
+	}
+	c.mu.Unlock()
+	var first error
+	if phc != nil {
+		first = phc.Close()
+	}
+	for _, sc := range conns {
+		sc.mu.Lock()
+		if sc.conn != nil {
+			if err := sc.conn.Close(); err != nil && first == nil {
+				first = err
+			}
+			sc.conn = nil
+		}
+		sc.mu.Unlock()
+	}
+	return first
+}
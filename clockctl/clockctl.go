@@ -0,0 +1,62 @@
+// Package clockctl adjusts the system clock. It is kept out of package
+// ntp so that importing the client never implies the ability to change
+// the clock; the calls need the appropriate privileges (CAP_SYS_TIME on
+// Linux, SeSystemtimePrivilege on Windows, root elsewhere).
+//
+// The backends are compiled only with the ntp_clockctl build tag:
+//
+//	go build -tags ntp_clockctl ./cmd/ntp
+//
+// Without it every adjustment returns ErrUnsupported, so a binary that
+// merely links this package, directly or through a dependency, cannot
+// change the clock unless its builder asked for that.
+package clockctl
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+)
+
+// ErrUnsupported is returned on platforms without a backend for the
+// requested adjustment, and everywhere in builds without the
+// ntp_clockctl tag. Its code is ntp.NTP_ERR_UNSUPPORTED; failures
+// of the system calls themselves map to codes through ntp.CodeOf.
+var ErrUnsupported error = &ntp.Error{Code: ntp.NTP_ERR_UNSUPPORTED, Err: errors.New("clockctl: not supported on this platform")}
+
+// Apply carries out a correction from an ntp.Discipline, and so can be
+// its Apply field: it steps the clock, or slews it by the phase
+// correction, and sets the frequency. Where the frequency cannot be set
+// (everywhere but Linux and Windows) it slews by the correction the
+// frequency would have made over the coming interval as well, e.g. with
+// adjtime(2) on darwin and the BSDs; the clock then keeps its rate
+// between updates only as well as the slews add up.
+func Apply(c ntp.Correction) error {
+	var phase time.Duration
+	if c.Step != 0 {
+		if err := Step(c.Step); err != nil {
+			return err
+		}
+	} else {
+		phase = c.Phase
+	}
+	if err := SetFrequency(c.Frequency); err == ErrUnsupported {
+		phase += time.Duration(c.Frequency * 1e-6 * float64(c.Interval))
+	} else if err != nil {
+		return err
+	}
+	if phase != 0 {
+		return Slew(phase)
+	}
+	return nil
+}
+
+// Wait returns once a slew started by Slew has finished. Only Windows
+// needs it, where this process rather than the kernel times the slew;
+// elsewhere it returns at once. If ctx is done first, Wait ends the
+// slew where it stands and returns ctx's error.
+func Wait(ctx context.Context) error {
+	return waitSlew(ctx)
+}
@@ -0,0 +1,65 @@
+//go:build ntp_clockctl
+
+package clockctl
+
+import (
+	"syscall"
+	"time"
+)
+
+// adjtimex modes and status bits from <sys/timex.h>.
+const (
+	adjFrequency = 0x0002
+	adjMaxError  = 0x0004
+	adjEstError  = 0x0008
+	adjStatus    = 0x0010
+	staPLL       = 0x0001
+	staUnsync    = 0x0040
+)
+
+// maxFreq is the kernel's limit on the frequency offset, in ppm.
+const maxFreq = 500
+
+// Frequency returns the kernel's frequency correction in parts per
+// million.
+func Frequency() (float64, error) {
+	var tx syscall.Timex
+	if _, err := syscall.Adjtimex(&tx); err != nil {
+		return 0, err
+	}
+	return float64(tx.Freq) / 65536, nil
+}
+
+// SetFrequency sets the kernel's frequency correction to ppm parts per
+// million, clamped to ±500; positive makes the clock run faster. The
+// kernel keeps applying it until it is set again, so a discipline
+// loop's frequency estimate holds between updates.
+func SetFrequency(ppm float64) error {
+	ppm = min(max(ppm, -maxFreq), maxFreq)
+	tx := syscall.Timex{Modes: adjFrequency}
+	setLong(&tx.Freq, int64(ppm*65536))
+	_, err := syscall.Adjtimex(&tx)
+	return err
+}
+
+// SetSynchronized tells the kernel whether the clock is synchronized
+// and, if so, its maximum and estimated error. Other programs read
+// them through adjtimex, and the kernel copies the time to the RTC
+// every 11 minutes only while the clock is synchronized. The kernel
+// grows the maximum error by itself until the next call. The kernel's
+// own PLL is switched off, since the corrections come from this side.
+func SetSynchronized(synced bool, maxErr, estErr time.Duration) error {
+	var tx syscall.Timex
+	if _, err := syscall.Adjtimex(&tx); err != nil {
+		return err
+	}
+	status := tx.Status &^ (staPLL | staUnsync)
+	if !synced {
+		status |= staUnsync
+	}
+	tx = syscall.Timex{Modes: adjStatus | adjMaxError | adjEstError, Status: status}
+	setLong(&tx.Maxerror, int64(maxErr/time.Microsecond))
+	setLong(&tx.Esterror, int64(estErr/time.Microsecond))
+	_, err := syscall.Adjtimex(&tx)
+	return err
+}
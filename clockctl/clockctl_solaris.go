@@ -0,0 +1,50 @@
+//go:build ntp_clockctl
+
+package clockctl
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The syscall package has neither call on illumos and Solaris, where
+// system calls go through libc; bind them the way golang.org/x/sys
+// does.
+
+//go:cgo_import_dynamic libc_adjtime adjtime "libc.so"
+//go:cgo_import_dynamic libc_clock_settime clock_settime "libc.so"
+
+//go:linkname libc_adjtime libc_adjtime
+//go:linkname libc_clock_settime libc_clock_settime
+
+var (
+	libc_adjtime       uintptr
+	libc_clock_settime uintptr
+)
+
+//go:linkname sysvicall6 syscall.sysvicall6
+func sysvicall6(trap, nargs, a1, a2, a3, a4, a5, a6 uintptr) (r1, r2 uintptr, err syscall.Errno)
+
+// CLOCK_REALTIME from <sys/time_impl.h>.
+const clockRealtime = 3
+
+// Step sets the clock forward (or back, for negative offsets) by offset
+// in one jump.
+func Step(offset time.Duration) error {
+	ts := syscall.NsecToTimespec(time.Now().Add(offset).UnixNano())
+	if _, _, e := sysvicall6(uintptr(unsafe.Pointer(&libc_clock_settime)), 2, clockRealtime, uintptr(unsafe.Pointer(&ts)), 0, 0, 0, 0); e != 0 {
+		return e
+	}
+	return nil
+}
+
+// Slew gradually corrects the clock by offset without ever stepping
+// it, using adjtime(2).
+func Slew(offset time.Duration) error {
+	tv := syscall.NsecToTimeval(offset.Nanoseconds())
+	if _, _, e := sysvicall6(uintptr(unsafe.Pointer(&libc_adjtime)), 2, uintptr(unsafe.Pointer(&tv)), 0, 0, 0, 0, 0); e != 0 {
+		return e
+	}
+	return nil
+}
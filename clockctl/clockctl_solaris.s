@@ -0,0 +1,4 @@
+//go:build ntp_clockctl
+
+// Present so that the linknamed sysvicall6 in clockctl_solaris.go may
+// be declared without a body.
@@ -0,0 +1,295 @@
+//go:build ntp_clockctl
+
+package clockctl
+
+import (
+	"context"
+	"math"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/chaitanyav/ntp"
+)
+
+var (
+	kernel32                    = syscall.NewLazyDLL("kernel32.dll")
+	procGetSystemTimeAdjustment = kernel32.NewProc("GetSystemTimeAdjustment")
+	procSetSystemTimeAdjustment = kernel32.NewProc("SetSystemTimeAdjustment")
+	procSetSystemTime           = kernel32.NewProc("SetSystemTime")
+
+	advapi32                  = syscall.NewLazyDLL("advapi32.dll")
+	procLookupPrivilegeValueW = advapi32.NewProc("LookupPrivilegeValueW")
+	procAdjustTokenPrivileges = advapi32.NewProc("AdjustTokenPrivileges")
+)
+
+const (
+	tokenAdjustPrivileges = 0x0020
+	tokenQuery            = 0x0008
+	sePrivilegeEnabled    = 0x0002
+
+	errorNotAllAssigned   syscall.Errno = 1300
+	errorPrivilegeNotHeld syscall.Errno = 1314
+)
+
+// maxFreq limits SetFrequency to ±500ppm, as the Linux kernel does.
+const maxFreq = 500
+
+// slewRate is how fast Slew moves the clock, 500ppm: the rate of
+// adjtime(2) elsewhere.
+const slewRate = 500e-6
+
+// systemTime is SYSTEMTIME.
+type systemTime struct {
+	Year, Month, DayOfWeek, Day        uint16
+	Hour, Minute, Second, Milliseconds uint16
+}
+
+// tokenPrivileges is TOKEN_PRIVILEGES with room for one privilege.
+type tokenPrivileges struct {
+	PrivilegeCount uint32
+	LowPart        uint32
+	HighPart       int32
+	Attributes     uint32
+}
+
+// Windows has no kernel slew: SetSystemTimeAdjustment only sets how
+// much time each clock tick adds. Slew raises or lowers that rate and
+// a timer restores it, so base is the rate SetFrequency asked for and
+// rate the part a slew in progress adds on top. done is closed when
+// that slew ends.
+var slew struct {
+	sync.Mutex
+	base  float64 // ppm
+	rate  float64 // ppm
+	timer *time.Timer
+	done  chan struct{}
+}
+
+var (
+	privOnce sync.Once
+	privErr  error
+)
+
+// Step sets the clock forward (or back, for negative offsets) by offset
+// in one jump, to the millisecond, which is all SetSystemTime takes.
+// It ends any slew in progress.
+func Step(offset time.Duration) error {
+	if err := enablePrivilege(); err != nil {
+		return err
+	}
+	slew.Lock()
+	defer slew.Unlock()
+	if slew.done != nil {
+		endSlewLocked()
+		if err := setRate(slew.base); err != nil {
+			return err
+		}
+	}
+	t := time.Now().Add(offset).UTC()
+	st := systemTime{
+		Year:         uint16(t.Year()),
+		Month:        uint16(t.Month()),
+		DayOfWeek:    uint16(t.Weekday()),
+		Day:          uint16(t.Day()),
+		Hour:         uint16(t.Hour()),
+		Minute:       uint16(t.Minute()),
+		Second:       uint16(t.Second()),
+		Milliseconds: uint16(t.Nanosecond() / 1e6),
+	}
+	if r, _, err := procSetSystemTime.Call(uintptr(unsafe.Pointer(&st))); r == 0 {
+		return sysErr(err)
+	}
+	return nil
+}
+
+// Slew gradually corrects the clock by offset without ever stepping
+// it, running the clock 500ppm fast or slow for as long as that takes:
+// two seconds per millisecond of offset. A new call replaces any
+// adjustment still in progress. This process, not the kernel, ends the
+// slew, so it must wait for it (see Wait) before exiting; otherwise the
+// clock keeps the slewed rate until the rate is next set.
+func Slew(offset time.Duration) error {
+	slew.Lock()
+	defer slew.Unlock()
+	endSlewLocked()
+	if offset == 0 {
+		return setRate(slew.base)
+	}
+	rate := slewRate * 1e6
+	if offset < 0 {
+		rate, offset = -rate, -offset
+	}
+	if err := setRate(slew.base + rate); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	slew.rate, slew.done = rate, done
+	slew.timer = time.AfterFunc(time.Duration(float64(offset)/slewRate), func() {
+		slew.Lock()
+		defer slew.Unlock()
+		if slew.done != done {
+			return
+		}
+		endSlewLocked()
+		// Nothing is left to report a failure to; the next Slew or
+		// SetFrequency sets the rate again.
+		setRate(slew.base)
+	})
+	return nil
+}
+
+// endSlewLocked forgets the slew in progress, if any, without touching
+// the clock. The caller holds slew.
+func endSlewLocked() {
+	if slew.timer != nil {
+		slew.timer.Stop()
+		slew.timer = nil
+	}
+	if slew.done != nil {
+		close(slew.done)
+		slew.done = nil
+	}
+	slew.rate = 0
+}
+
+func waitSlew(ctx context.Context) error {
+	for {
+		slew.Lock()
+		done := slew.done
+		slew.Unlock()
+		if done == nil {
+			return nil
+		}
+		select {
+		case <-done:
+			// A new slew may have replaced this one; wait for that too.
+		case <-ctx.Done():
+			slew.Lock()
+			defer slew.Unlock()
+			if slew.done == done {
+				endSlewLocked()
+				if err := setRate(slew.base); err != nil {
+					return err
+				}
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// Frequency returns the frequency correction in parts per million, as
+// set by SetFrequency or by another program through
+// SetSystemTimeAdjustment; it is zero while the system disciplines the
+// clock itself.
+func Frequency() (float64, error) {
+	adj, inc, disabled, err := timeAdjustment()
+	if err != nil {
+		return 0, err
+	}
+	if disabled {
+		return 0, nil
+	}
+	slew.Lock()
+	defer slew.Unlock()
+	return (float64(adj)/float64(inc)-1)*1e6 - slew.rate, nil
+}
+
+// SetFrequency sets the clock's frequency correction to ppm parts per
+// million, clamped to ±500; positive makes the clock run faster. It
+// holds until it is set again, even after this process exits, and the
+// system's own adjustments, such as the Windows Time service's, stay
+// off meanwhile. Windows adds a whole number of 100ns units per tick,
+// so at the usual 15.625ms tick the rate is rounded to about 6ppm.
+func SetFrequency(ppm float64) error {
+	ppm = min(max(ppm, -maxFreq), maxFreq)
+	slew.Lock()
+	defer slew.Unlock()
+	if err := setRate(ppm + slew.rate); err != nil {
+		return err
+	}
+	slew.base = ppm
+	return nil
+}
+
+// setRate makes each clock tick add its nominal increment corrected by
+// ppm.
+func setRate(ppm float64) error {
+	if err := enablePrivilege(); err != nil {
+		return err
+	}
+	_, inc, _, err := timeAdjustment()
+	if err != nil {
+		return err
+	}
+	adj := uint32(math.Round(float64(inc) * (1 + ppm*1e-6)))
+	if r, _, err := procSetSystemTimeAdjustment.Call(uintptr(adj), 0); r == 0 {
+		return sysErr(err)
+	}
+	return nil
+}
+
+// timeAdjustment returns GetSystemTimeAdjustment's per-tick adjustment
+// and nominal increment, both in 100ns units, and whether the
+// adjustment is disabled.
+func timeAdjustment() (adj, inc uint32, disabled bool, err error) {
+	var d int32
+	r, _, e := procGetSystemTimeAdjustment.Call(
+		uintptr(unsafe.Pointer(&adj)), uintptr(unsafe.Pointer(&inc)), uintptr(unsafe.Pointer(&d)))
+	if r == 0 {
+		return 0, 0, false, e
+	}
+	return adj, inc, d != 0, nil
+}
+
+// enablePrivilege enables SeSystemtimePrivilege in the process token,
+// which administrators hold but have disabled until asked for.
+func enablePrivilege() error {
+	privOnce.Do(func() {
+		privErr = sysErr(adjustPrivilege("SeSystemtimePrivilege"))
+	})
+	return privErr
+}
+
+func adjustPrivilege(name string) error {
+	proc, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return err
+	}
+	var tok syscall.Token
+	if err := syscall.OpenProcessToken(proc, tokenAdjustPrivileges|tokenQuery, &tok); err != nil {
+		return err
+	}
+	defer tok.Close()
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	tp := tokenPrivileges{PrivilegeCount: 1, Attributes: sePrivilegeEnabled}
+	if r, _, err := procLookupPrivilegeValueW.Call(0, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&tp.LowPart))); r == 0 {
+		return err
+	}
+	r, _, err := procAdjustTokenPrivileges.Call(uintptr(tok), 0, uintptr(unsafe.Pointer(&tp)), 0, 0, 0)
+	if r == 0 {
+		return err
+	}
+	// AdjustTokenPrivileges succeeds even when the token does not hold
+	// the privilege, and says so only through the last error.
+	if err == errorNotAllAssigned {
+		return err
+	}
+	return nil
+}
+
+// sysErr gives the privilege errors, which os.ErrPermission does not
+// cover, the code ntp.NTP_ERR_PERMISSION.
+func sysErr(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case errorNotAllAssigned, errorPrivilegeNotHeld:
+		return &ntp.Error{Code: ntp.NTP_ERR_PERMISSION, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !ntp_clockctl || !(linux || windows)
+
+package clockctl
+
+func Frequency() (float64, error) {
+	return 0, ErrUnsupported
+}
+
+func SetFrequency(ppm float64) error {
+	return ErrUnsupported
+}
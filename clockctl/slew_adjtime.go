@@ -0,0 +1,16 @@
+//go:build ntp_clockctl && (darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package clockctl
+
+import (
+	"syscall"
+	"time"
+)
+
+// Slew gradually corrects the clock by offset without ever stepping
+// it, using adjtime(2). A new call replaces any adjustment still in
+// progress.
+func Slew(offset time.Duration) error {
+	tv := syscall.NsecToTimeval(offset.Nanoseconds())
+	return syscall.Adjtime(&tv, nil)
+}
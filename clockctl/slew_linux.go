@@ -0,0 +1,27 @@
+//go:build ntp_clockctl
+
+package clockctl
+
+import (
+	"syscall"
+	"time"
+)
+
+// ADJ_OFFSET_SINGLESHOT from <sys/timex.h>: the old adjtime(2)
+// semantics, slewing by Offset microseconds at the kernel's fixed rate.
+const adjOffsetSingleshot = 0x8001
+
+// Slew gradually corrects the clock by offset without ever stepping
+// it.
+func Slew(offset time.Duration) error {
+	tx := syscall.Timex{Modes: adjOffsetSingleshot}
+	setLong(&tx.Offset, int64(offset/time.Microsecond))
+	_, err := syscall.Adjtimex(&tx)
+	return err
+}
+
+// setLong stores v in a C long field of a syscall struct, whose Go type
+// is int32 or int64 depending on GOARCH.
+func setLong[T int32 | int64](field *T, v int64) {
+	*field = T(v)
+}
@@ -0,0 +1,9 @@
+//go:build !ntp_clockctl || !(linux || darwin || freebsd || netbsd || openbsd || dragonfly || solaris || windows)
+
+package clockctl
+
+import "time"
+
+func Slew(offset time.Duration) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,15 @@
+//go:build ntp_clockctl && (darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package clockctl
+
+import (
+	"syscall"
+	"time"
+)
+
+// Step sets the clock forward (or back, for negative offsets) by offset
+// in one jump.
+func Step(offset time.Duration) error {
+	tv := syscall.NsecToTimeval(time.Now().Add(offset).UnixNano())
+	return syscall.Settimeofday(&tv)
+}
@@ -0,0 +1,9 @@
+//go:build !ntp_clockctl || !linux
+
+package clockctl
+
+import "time"
+
+func SetSynchronized(synced bool, maxErr, estErr time.Duration) error {
+	return ErrUnsupported
+}
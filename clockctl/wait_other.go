@@ -0,0 +1,9 @@
+//go:build !ntp_clockctl || !windows
+
+package clockctl
+
+import "context"
+
+func waitSlew(ctx context.Context) error {
+	return nil
+}
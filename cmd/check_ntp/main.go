@@ -0,0 +1,192 @@
+// Command check_ntp is a Nagios and Icinga plugin that checks a
+// server's clock against this host's.
+//
+// Usage:
+//
+//	check_ntp -H host [-p port] [-w range] [-c range] [-W range] [-C range] [-t timeout] [-q]
+//
+// It queries the server once and compares the absolute offset in
+// seconds against -w and -c, and the server's stratum against -W and
+// -C. Ranges take the plugin guidelines' syntax: "10" alerts outside 0
+// to 10, "5:" below 5, "~:5" above 5, "5:10" outside 5 to 10, and
+// "@5:10" inside it. It prints one line, such as
+//
+//	NTP OK: Offset 0.001372 secs, stratum 2|offset=0.001372s;60;120; stratum=2;;;0;16
+//
+// and exits 0 (OK), 1 (WARNING), 2 (CRITICAL) or 3 (UNKNOWN). A server
+// that does not answer, sends a kiss-of-death or is itself unsynchronized
+// is CRITICAL, or UNKNOWN with -q.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+)
+
+// Plugin states, which are also the exit statuses.
+const (
+	stateOK = iota
+	stateWarning
+	stateCritical
+	stateUnknown
+)
+
+// maxStratum is the stratum of an unsynchronized server.
+const maxStratum = 16
+
+var stateNames = [...]string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("check_ntp", flag.ContinueOnError)
+	host := fs.String("H", "", "server to check")
+	port := fs.Int("p", 123, "server's port")
+	warnOffset := fs.String("w", "60", "offset range in seconds outside which to warn")
+	critOffset := fs.String("c", "120", "offset range in seconds outside which to go critical")
+	warnStratum := fs.String("W", "", "stratum range outside which to warn")
+	critStratum := fs.String("C", "", "stratum range outside which to go critical")
+	timeout := fs.Duration("t", 10*time.Second, "time to wait for the server")
+	version := fs.Int("version", 4, "NTP version to send")
+	unknown := fs.Bool("q", false, "report UNKNOWN rather than CRITICAL when the server gives no usable reply")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: check_ntp -H host [-p port] [-w range] [-c range] [-W range] [-C range] [-t timeout] [-q]")
+		fs.PrintDefaults()
+	}
+	// The output line must come first, so the flag package's own
+	// messages are only let through for -h.
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stdout)
+			fs.Usage()
+			return stateUnknown
+		}
+		return report(stateUnknown, err.Error(), "")
+	}
+	switch {
+	case *host == "":
+		return report(stateUnknown, "no server given with -H", "")
+	case fs.NArg() > 0:
+		return report(stateUnknown, "unexpected argument "+fs.Arg(0), "")
+	}
+	var th [4]threshold
+	for i, s := range []string{*warnOffset, *critOffset, *warnStratum, *critStratum} {
+		var err error
+		if th[i], err = parseThreshold(s); err != nil {
+			return report(stateUnknown, err.Error(), "")
+		}
+	}
+
+	noReply := stateCritical
+	if *unknown {
+		noReply = stateUnknown
+	}
+	// The client rejects kiss-of-death, unsynchronized and otherwise
+	// unusable replies itself; only a stratum of 16 with the leap
+	// indicator clear gets through.
+	var c ntp.Client
+	r, err := c.QueryContext(context.Background(), *host,
+		ntp.WithPort(*port), ntp.WithTimeout(*timeout), ntp.WithVersion(*version))
+	if err != nil {
+		return report(noReply, fmt.Sprintf("%v (%s)", err, ntp.CodeOf(err)), "")
+	}
+	if r.Stratum >= maxStratum {
+		return report(noReply, fmt.Sprintf("%s: server clock is not synchronized (%s)", *host, ntp.NTP_ERR_UNSYNCED), "")
+	}
+
+	offset := r.ClockOffset.Seconds()
+	stratum := float64(r.Stratum)
+	state := stateOK
+	switch {
+	case th[1].alert(abs(offset)) || th[3].alert(stratum):
+		state = stateCritical
+	case th[0].alert(abs(offset)) || th[2].alert(stratum):
+		state = stateWarning
+	}
+	msg := fmt.Sprintf("Offset %s secs, stratum %d", strconv.FormatFloat(offset, 'f', 6, 64), r.Stratum)
+	perf := fmt.Sprintf("offset=%ss;%s;%s; stratum=%d;%s;%s;0;%d", strconv.FormatFloat(offset, 'f', 6, 64),
+		th[0].text, th[1].text, r.Stratum, th[2].text, th[3].text, maxStratum)
+	return report(state, msg, perf)
+}
+
+// report prints the plugin's output line and returns state.
+func report(state int, msg, perf string) int {
+	line := "NTP " + stateNames[state] + ": " + msg
+	if perf != "" {
+		line += "|" + perf
+	}
+	fmt.Println(line)
+	return state
+}
+
+// threshold is a range in the plugin guidelines' syntax; the zero
+// threshold never alerts.
+type threshold struct {
+	text   string
+	lo, hi float64
+	inside bool // alert inside [lo, hi] rather than outside it
+}
+
+func parseThreshold(s string) (threshold, error) {
+	t := threshold{text: s}
+	if s == "" {
+		return t, nil
+	}
+	if strings.HasPrefix(s, "@") {
+		t.inside = true
+		s = s[1:]
+	}
+	lo, hi, ok := strings.Cut(s, ":")
+	if !ok {
+		lo, hi = "0", lo
+	}
+	var err error
+	switch lo {
+	case "~":
+		t.lo = math.Inf(-1)
+	case "":
+		t.lo = 0
+	default:
+		if t.lo, err = strconv.ParseFloat(lo, 64); err != nil {
+			return t, fmt.Errorf("bad range %q", t.text)
+		}
+	}
+	if hi == "" {
+		t.hi = math.Inf(1)
+	} else if t.hi, err = strconv.ParseFloat(hi, 64); err != nil {
+		return t, fmt.Errorf("bad range %q", t.text)
+	}
+	if t.lo > t.hi {
+		return t, fmt.Errorf("bad range %q: start is above end", t.text)
+	}
+	return t, nil
+}
+
+// alert reports whether v falls where t alerts.
+func (t threshold) alert(v float64) bool {
+	if t.text == "" {
+		return false
+	}
+	in := v >= t.lo && v <= t.hi
+	return in == t.inside
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
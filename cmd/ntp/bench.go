@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chaitanyav/ntp/bench"
+)
+
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	duration := fs.Duration("d", 10*time.Second, "length of the run")
+	concurrency := fs.Int("c", 16, "number of outstanding queries")
+	timeout := fs.Duration("timeout", orDuration(profile.Timeout, time.Second), "time to wait for a reply before counting it as lost")
+	ramp := fs.String("ramp", "constant:100", "rate profile: constant:RATE, linear:FROM-TO/OVER or step:FROM+BY/EVERY[,MAX]")
+	interval := fs.Duration("interval", time.Second, "width of the per-interval breakdown")
+	version := fs.Uint("version", uint(orInt(profile.Version, 4)), "NTP version to send")
+	asJSON := fs.Bool("json", profile.Format == "json", "write the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: ntp bench [flags] [server]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	targets := servers(fs.Args())
+	if len(targets) == 0 || fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	r, err := bench.ParseRamp(*ramp)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ntp bench:", err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	rep, err := bench.Run(ctx, bench.Config{
+		Server:      targets[0],
+		Duration:    *duration,
+		Concurrency: *concurrency,
+		Timeout:     *timeout,
+		Ramp:        r,
+		Interval:    *interval,
+		Version:     byte(*version),
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ntp bench:", err)
+		return 1
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+	} else {
+		printBench(os.Stdout, rep)
+	}
+	return 0
+}
+
+func printBench(w io.Writer, rep *bench.Report) {
+	fmt.Fprintf(w, "server:   %s\n", rep.Server)
+	fmt.Fprintf(w, "sent:     %d\n", rep.Sent)
+	fmt.Fprintf(w, "received: %d\n", rep.Received)
+	fmt.Fprintf(w, "lost:     %d (%.2f%%)\n", rep.Lost, rep.Loss*100)
+	fmt.Fprintf(w, "errors:   %d\n", rep.Errors)
+	fmt.Fprintf(w, "skipped:  %d\n", rep.Skipped)
+	l := rep.Latency
+	fmt.Fprintf(w, "latency:  min %v  mean %v  p50 %v  p90 %v  p99 %v  max %v\n\n",
+		l.Min, l.Mean, l.P50, l.P90, l.P99, l.Max)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "start\trate\tsent\trecv\tlost\tp50\tp99\t")
+	for _, iv := range rep.Intervals {
+		fmt.Fprintf(tw, "%v\t%.0f\t%d\t%d\t%d\t%v\t%v\t\n",
+			iv.Start, iv.Rate, iv.Sent, iv.Received, iv.Lost, iv.Latency.P50, iv.Latency.P99)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chaitanyav/ntp/leapsec"
+)
+
+var defaultLeapURLs = []string{
+	"https://hpiers.obspm.fr/iers/bul/bulc/ntp/leap-seconds.list",
+	"https://data.iana.org/time-zones/tzdb/leap-seconds.list",
+}
+
+// runLeapfile keeps an installed leap-seconds.list current. It is meant
+// to be run periodically from cron or a systemd timer, e.g.
+//
+//	[Timer]
+//	OnCalendar=weekly
+//	RandomizedDelaySec=1d
+//
+// and does nothing unless the installed file is missing, invalid, or
+// within -renew of its expiry. It exits 0 when the installed file is
+// valid afterwards and 1 otherwise.
+func runLeapfile(args []string) int {
+	fs := flag.NewFlagSet("leapfile", flag.ContinueOnError)
+	var urls stringList
+	fs.Var(&urls, "url", "URL to fetch leap-seconds.list from; may be repeated (default IERS, then IANA)")
+	out := fs.String("o", orString(profile.LeapFile, "/usr/share/zoneinfo/leap-seconds.list"), "file to install")
+	renew := fs.Duration("renew", 28*24*time.Hour, "fetch a new file when the installed one expires within this long")
+	force := fs.Bool("f", false, "fetch even if the installed file is current")
+	timeout := fs.Duration("timeout", orDuration(profile.Timeout, 30*time.Second), "HTTP timeout per URL")
+	quiet := fs.Bool("q", false, "only report errors")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: ntp leapfile [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if len(urls) == 0 {
+		urls = profile.LeapURLs
+	}
+	if len(urls) == 0 {
+		urls = defaultLeapURLs
+	}
+	logf := func(format string, args ...interface{}) {
+		if !*quiet {
+			fmt.Printf(format+"\n", args...)
+		}
+	}
+
+	now := time.Now()
+	installed, err := readLeapFile(*out)
+	switch {
+	case err == nil && installed.Expired(now):
+		logf("%s expired on %s", *out, installed.Expires.Format("2006-01-02"))
+	case err == nil && !*force && installed.Expires.Sub(now) > *renew:
+		logf("%s is current (expires %s)", *out, installed.Expires.Format("2006-01-02"))
+		return 0
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		fmt.Fprintf(os.Stderr, "ntp leapfile: ignoring installed file: %v\n", err)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	for _, url := range urls {
+		data, table, err := fetchLeapFile(client, url, now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ntp leapfile: %s: %v\n", url, err)
+			continue
+		}
+		if installed != nil && table.Updated.Before(installed.Updated) {
+			fmt.Fprintf(os.Stderr, "ntp leapfile: %s: older than the installed file\n", url)
+			continue
+		}
+		if err := installFile(*out, data); err != nil {
+			fmt.Fprintln(os.Stderr, "ntp leapfile:", err)
+			return 1
+		}
+		logf("installed %s from %s (expires %s)", *out, url, table.Expires.Format("2006-01-02"))
+		return 0
+	}
+	if installed != nil && !installed.Expired(now) {
+		// Still usable; let the next run try again.
+		return 0
+	}
+	return 1
+}
+
+func readLeapFile(path string) (*leapsec.Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return leapsec.Parse(f)
+}
+
+func fetchLeapFile(client *http.Client, url string, now time.Time) ([]byte, *leapsec.Table, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	table, err := leapsec.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	if table.Expired(now) {
+		return nil, nil, fmt.Errorf("file expired on %s", table.Expires.Format("2006-01-02"))
+	}
+	return data, table, nil
+}
+
+// installFile replaces path with data so that readers see either the
+// old or the new file, never a partial one.
+func installFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Command ntp is a collection of NTP tools built on this package.
+//
+// Usage:
+//
+//	ntp [-config file] [-profile name] <command> [flags] [arguments]
+//
+// Defaults for the commands (servers, timeout, output format, keys, NTS
+// settings) are read from the configuration file described in package
+// config; the profile is chosen with -profile or $NTP_PROFILE.
+// $NTP_SERVERS, $NTP_TIMEOUT and $NTP_VERSION override the profile, and
+// command-line flags override everything. Run "ntp help" for the list
+// of commands.
+//
+// Error messages end with the failure's stable code in parentheses,
+// e.g. "(NTP_ERR_TIMEOUT)"; see ntp.Code.
+//
+// "ntp set" changes the clock only in binaries built with the
+// ntp_clockctl tag (go build -tags ntp_clockctl); elsewhere it fails
+// with NTP_ERR_UNSUPPORTED. See package clockctl.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/chaitanyav/ntp/config"
+)
+
+type command struct {
+	run   func(args []string) int
+	usage string
+}
+
+var commands = map[string]command{
+	"bench":    {runBench, "load-test an NTP server"},
+	"leapfile": {runLeapfile, "fetch and install leap-seconds.list"},
+	"query":    {runQuery, "query servers and report the clock offset"},
+	"set":      {runSet, "query servers and correct the system clock"},
+	"serve":    {runServe, "poll servers and serve time quality as JSON over HTTP"},
+	"status":   {runStatus, "report synchronization status like w32tm /query /status"},
+}
+
+// profile holds the defaults resolved from the configuration file.
+var profile config.Profile
+
+func main() {
+	global := flag.NewFlagSet("ntp", flag.ContinueOnError)
+	configPath := global.String("config", "", "configuration file (default $NTP_CONFIG or the user config dir)")
+	profileName := global.String("profile", os.Getenv("NTP_PROFILE"), "configuration profile to use")
+	global.Usage = usage
+	if err := global.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+	args := global.Args()
+	if len(args) < 1 {
+		usage()
+		os.Exit(2)
+	}
+	name := args[0]
+	if name == "help" {
+		usage()
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "ntp: unknown command %q\n", name)
+		usage()
+		os.Exit(2)
+	}
+	var err error
+	if profile, err = config.LoadProfile(*configPath, *profileName); err != nil {
+		fmt.Fprintln(os.Stderr, "ntp:", err)
+		os.Exit(2)
+	}
+	os.Exit(cmd.run(args[1:]))
+}
+
+// servers returns the command-line servers, or the profile's when none
+// were given.
+func servers(args []string) []string {
+	if len(args) > 0 {
+		return args
+	}
+	return profile.Servers
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: ntp [-config file] [-profile name] <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+func orDuration(d, def time.Duration) time.Duration {
+	if d != 0 {
+		return d
+	}
+	return def
+}
+
+func orInt(n, def int) int {
+	if n != 0 {
+		return n
+	}
+	return def
+}
+
+func orString(s, def string) string {
+	if s != "" {
+		return s
+	}
+	return def
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+	"github.com/chaitanyav/ntp/httpsdate"
+)
+
+// Exit codes shared by query and set. They follow ntpdate, which
+// scripts have long relied on: 0 when a server answered (and, for set,
+// the clock was adjusted), 1 otherwise.
+const (
+	exitOK       = 0
+	exitNoServer = 1
+	exitAdjust   = 1
+	exitUsage    = 2
+)
+
+type queryFlags struct {
+	fs      *flag.FlagSet
+	timeout *time.Duration
+	version *uint
+	quiet   *bool
+	https   stringList
+}
+
+func newQueryFlags(name, args string) *queryFlags {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	q := &queryFlags{
+		fs:      fs,
+		timeout: fs.Duration("timeout", orDuration(profile.Timeout, 2*time.Second), "time to wait for each server"),
+		version: fs.Uint("version", uint(orInt(profile.Version, 4)), "NTP version to send"),
+		quiet:   fs.Bool("q", false, "quiet: print nothing, report only through the exit status"),
+	}
+	fs.Var(&q.https, "https", "HTTPS URL to take coarse time from if no NTP server answers (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: ntp %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return q
+}
+
+// queryAll queries every server concurrently and returns the replies in
+// argument order, leaving nil for servers that did not answer or whose
+// replies the client rejected, e.g. as unsynchronized or too far from
+// their reference clocks to trust.
+func (q *queryFlags) queryAll(targets []string) []*ntp.Response {
+	var c ntp.Client
+	results := make([]*ntp.Response, len(targets))
+	var wg sync.WaitGroup
+	for i, server := range targets {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			r, err := c.QueryContext(context.Background(), server,
+				ntp.WithTimeout(*q.timeout), ntp.WithVersion(int(*q.version)))
+			if err != nil {
+				if !*q.quiet {
+					fmt.Fprintf(os.Stderr, "%s: %v (%s)\n", server, err, ntp.CodeOf(err))
+				}
+				return
+			}
+			results[i] = r
+		}(i, server)
+	}
+	wg.Wait()
+	if !*q.quiet {
+		for _, r := range results {
+			if r != nil {
+				fmt.Printf("server %s, stratum %d, offset %+.6f, delay %.5f\n",
+					r.Addr, r.Stratum, r.ClockOffset.Seconds(), r.RTT.Seconds())
+			}
+		}
+	}
+	return results
+}
+
+// httpsFallback estimates the offset from the -https URLs, for when no
+// NTP server answered. Certificate validity periods are not checked,
+// since the clock may be too wrong for them to pass.
+func (q *queryFlags) httpsFallback() *ntp.Response {
+	if len(q.https) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5**q.timeout)
+	defer cancel()
+	r, err := httpsdate.Estimate(ctx, httpsdate.Config{URLs: q.https, Timeout: 5 * *q.timeout, IgnoreExpiry: true})
+	if err != nil {
+		if !*q.quiet {
+			fmt.Fprintf(os.Stderr, "https: %v (%s)\n", err, ntp.CodeOf(err))
+		}
+		return nil
+	}
+	if !*q.quiet {
+		for _, s := range r.Samples {
+			fmt.Printf("https %s, offset %+.3f +/- %.3f\n", s.URL, s.Offset.Seconds(), s.Uncertainty.Seconds())
+		}
+	}
+	return &ntp.Response{Addr: r.URL, ClockOffset: r.Offset, RTT: r.RTT}
+}
+
+// best picks the reply to act on: the one with the lowest round-trip
+// delay, or nil when no server answered.
+func best(results []*ntp.Response) *ntp.Response {
+	var b *ntp.Response
+	for _, r := range results {
+		if r != nil && (b == nil || r.RTT < b.RTT) {
+			b = r
+		}
+	}
+	return b
+}
+
+func runQuery(args []string) int {
+	q := newQueryFlags("query", "[server ...]")
+	if err := q.fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	targets := servers(q.fs.Args())
+	if len(targets) == 0 && len(q.https) == 0 {
+		q.fs.Usage()
+		return exitUsage
+	}
+	b := best(q.queryAll(targets))
+	if b == nil {
+		b = q.httpsFallback()
+	}
+	if b == nil {
+		if !*q.quiet {
+			fmt.Fprintln(os.Stderr, "no server suitable for synchronization found")
+		}
+		return exitNoServer
+	}
+	if !*q.quiet {
+		fmt.Printf("%s offset %+.6f sec from server %s\n", time.Now().Format(time.Stamp), b.ClockOffset.Seconds(), b.Addr)
+	}
+	return exitOK
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/chaitanyav/ntp/timeservice"
+)
+
+// runServe polls servers continuously and serves the corrected time,
+// per-source state and recent history as JSON on -listen, which
+// defaults to a loopback address so that the API is only reachable
+// from this host. /livez and /readyz answer health probes, the latter
+// failing while unsynchronized or off by more than -max-offset.
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := fs.String("listen", "127.0.0.1:8123", "address to serve the JSON API on")
+	interval := fs.Duration("interval", 64*time.Second, "time between polls of each server")
+	timeout := fs.Duration("timeout", orDuration(profile.Timeout, 2*time.Second), "time to wait for each server")
+	version := fs.Uint("version", uint(orInt(profile.Version, 4)), "NTP version to send")
+	history := fs.Int("history", 64, "polls to keep per server")
+	maxOffset := fs.Duration("max-offset", 0, "offset beyond which /readyz fails (0 for no limit)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: ntp serve [flags] [server ...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	targets := servers(fs.Args())
+	if len(targets) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	svc := timeservice.New(timeservice.Config{
+		Servers:  targets,
+		Interval: *interval,
+		Timeout:  *timeout,
+		Version:  byte(*version),
+		History:  *history,
+	})
+	defer svc.Close()
+	fmt.Fprintf(os.Stderr, "serving on http://%s/v1/time\n", *listen)
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", svc.JSONHandler())
+	health := svc.HealthHandler(*maxOffset)
+	mux.Handle("/livez", health)
+	mux.Handle("/readyz", health)
+	if err := http.ListenAndServe(*listen, mux); err != nil {
+		fmt.Fprintln(os.Stderr, "ntp:", err)
+		return exitNoServer
+	}
+	return exitOK
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+	"github.com/chaitanyav/ntp/clockctl"
+	"github.com/chaitanyav/ntp/w32time"
+)
+
+// stepThreshold is ntpdate's boundary between slewing and stepping,
+// the default for -step.
+const stepThreshold = 500 * time.Millisecond
+
+func runSet(args []string) int {
+	q := newQueryFlags("set", "[server ...]")
+	forceStep := q.fs.Bool("b", false, "always step the clock")
+	forceSlew := q.fs.Bool("B", false, "always slew the clock, however large the offset")
+	threshold := q.fs.Duration("step", stepThreshold, "step the clock rather than slew it when the offset exceeds this")
+	override := q.fs.Bool("w32time", false, "adjust the clock even when the Windows Time service owns it")
+	if err := q.fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	targets := servers(q.fs.Args())
+	if (len(targets) == 0 && len(q.https) == 0) || (*forceStep && *forceSlew) {
+		q.fs.Usage()
+		return exitUsage
+	}
+	// Two synchronizers fighting over the clock is worse than either
+	// alone, so defer to W32Time unless told otherwise.
+	if svc, err := w32time.QueryService(); err == nil && svc.OwnsClock() && !*override {
+		if !*q.quiet {
+			fmt.Fprintln(os.Stderr, "the Windows Time service owns the clock; use \"ntp status\" to monitor it, or -w32time to override")
+		}
+		return exitAdjust
+	}
+	b := best(q.queryAll(targets))
+	if b == nil {
+		b = q.httpsFallback()
+	}
+	if b == nil {
+		if !*q.quiet {
+			fmt.Fprintln(os.Stderr, "no server suitable for synchronization found")
+		}
+		return exitNoServer
+	}
+
+	offset := b.ClockOffset
+	abs := offset
+	if abs < 0 {
+		abs = -abs
+	}
+	step := *forceStep || (!*forceSlew && abs > *threshold)
+	verb := "adjust"
+	var err error
+	if step {
+		verb = "step"
+		err = clockctl.Step(offset)
+	} else {
+		err = clockctl.Slew(offset)
+	}
+	if err != nil {
+		if !*q.quiet {
+			fmt.Fprintf(os.Stderr, "can't %s time: %v (%s)\n", verb, err, ntp.CodeOf(err))
+			if err == clockctl.ErrUnsupported {
+				fmt.Fprintln(os.Stderr, "rebuild with -tags ntp_clockctl to let this binary adjust the clock")
+			}
+		}
+		return exitAdjust
+	}
+	if !*q.quiet {
+		fmt.Printf("%s %s time server %s offset %+.6f sec\n", time.Now().Format(time.Stamp), verb, b.Addr, offset.Seconds())
+	}
+	if !step {
+		// Where the slew is timed from here rather than by the kernel,
+		// exiting early would leave the clock running at the slewed rate.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if err := clockctl.Wait(ctx); err != nil {
+			if !*q.quiet {
+				fmt.Fprintf(os.Stderr, "slew interrupted: %v\n", err)
+			}
+			return exitAdjust
+		}
+	}
+	return exitOK
+}
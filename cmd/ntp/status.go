@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/chaitanyav/ntp"
+	"github.com/chaitanyav/ntp/w32time"
+)
+
+// runStatus prints synchronization status in the layout of w32tm
+// /query /status. Where the Windows Time service owns the clock its own
+// status is shown and no server is queried; everywhere else the status
+// is that of the best reply from the servers.
+func runStatus(args []string) int {
+	q := newQueryFlags("status", "[server ...]")
+	if err := q.fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if svc, err := w32time.QueryService(); err == nil && svc.OwnsClock() {
+		st, err := w32time.Query()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "w32tm: %v (%s)\n", err, ntp.CodeOf(err))
+			return exitNoServer
+		}
+		if !*q.quiet {
+			fmt.Println("Windows Time service owns the clock (monitor only)")
+			st.Format(os.Stdout)
+		}
+		return exitOK
+	}
+
+	targets := servers(q.fs.Args())
+	if len(targets) == 0 {
+		q.fs.Usage()
+		return exitUsage
+	}
+	b := best(q.queryAll(targets))
+	if b == nil {
+		if !*q.quiet {
+			fmt.Fprintln(os.Stderr, "no server suitable for synchronization found")
+		}
+		return exitNoServer
+	}
+	if !*q.quiet {
+		w32time.FromPacket(&b.Packet, b.Addr, b.Received).Format(os.Stdout)
+	}
+	return exitOK
+}
@@ -0,0 +1,129 @@
+// Command ntp_exporter probes NTP servers and exposes the results as
+// Prometheus metrics.
+//
+// Servers given with -server (or taken from the configuration profile)
+// are probed every -interval and reported on /metrics. Any server can
+// also be probed on demand, blackbox-exporter style, with
+// /probe?target=host[:port], so a single exporter can serve a
+// Prometheus job whose targets are relabelled into the target
+// parameter. Both are written by package metrics, whose Collector
+// observes every query through ntp.Client's OnQuery.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+	"github.com/chaitanyav/ntp/config"
+	"github.com/chaitanyav/ntp/metrics"
+)
+
+type stringList []string
+
+func (l *stringList) String() string { return fmt.Sprint(*l) }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+type exporter struct {
+	timeout time.Duration
+	version int
+	verbose bool
+
+	// client queries the scheduled targets and reports each query to
+	// collected, which serves /metrics.
+	client    *ntp.Client
+	collected metrics.Collector
+}
+
+func main() {
+	listen := flag.String("listen", ":9559", "address to serve metrics on")
+	configPath := flag.String("config", "", "configuration file (default $NTP_CONFIG or the user config dir)")
+	profileName := flag.String("profile", os.Getenv("NTP_PROFILE"), "configuration profile to take servers from")
+	interval := flag.Duration("interval", 30*time.Second, "how often to probe the configured servers")
+	timeout := flag.Duration("timeout", 0, "per-probe timeout (default from profile, else 5s)")
+	verbose := flag.Bool("v", false, "log every failed scheduled probe")
+	var servers stringList
+	flag.Var(&servers, "server", "server to probe on a schedule; may be repeated")
+	flag.Parse()
+
+	p, err := config.LoadProfile(*configPath, *profileName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(servers) == 0 {
+		servers = p.Servers
+	}
+	e := &exporter{timeout: *timeout, version: 4, verbose: *verbose}
+	if e.timeout == 0 {
+		e.timeout = p.Timeout
+	}
+	if e.timeout == 0 {
+		e.timeout = 5 * time.Second
+	}
+	if p.Version != 0 {
+		e.version = p.Version
+	}
+	e.client = &ntp.Client{ReuseConn: true, OnQuery: e.collected.Observe}
+	for i, s := range servers {
+		// Spread the targets over the interval rather than probing
+		// them all at once.
+		go e.poll(s, *interval, time.Duration(i)*(*interval)/time.Duration(len(servers)))
+	}
+
+	http.Handle("/metrics", &e.collected)
+	http.HandleFunc("/probe", e.serveProbe)
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `<html><body><h1>NTP exporter</h1><p><a href="/metrics">metrics</a></p></body></html>`)
+	})
+	log.Printf("listening on %s, probing %d servers every %v", *listen, len(servers), *interval)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
+
+// poll probes one server forever; the client's OnQuery records each
+// outcome.
+func (e *exporter) poll(server string, interval, delay time.Duration) {
+	time.Sleep(delay)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := e.client.QueryContext(context.Background(), server,
+			ntp.WithTimeout(e.timeout), ntp.WithVersion(e.version))
+		if err != nil && e.verbose {
+			log.Printf("probe %s: %v (%s)", server, err, ntp.CodeOf(err))
+		}
+		<-ticker.C
+	}
+}
+
+// serveProbe probes the target parameter once and writes only that
+// probe's metrics, from a Collector of its own, followed by how long
+// the probe took.
+func (e *exporter) serveProbe(w http.ResponseWriter, r *http.Request) {
+	server := r.URL.Query().Get("target")
+	if server == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	var m metrics.Collector
+	c := &ntp.Client{OnQuery: m.Observe}
+	start := time.Now()
+	_, err := c.QueryContext(r.Context(), server, ntp.WithTimeout(e.timeout), ntp.WithVersion(e.version))
+	if err != nil {
+		log.Printf("probe %s: %v (%s)", server, err, ntp.CodeOf(err))
+	}
+	took := time.Since(start)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+	fmt.Fprintf(w, "# HELP ntp_probe_duration_seconds Time taken by the probe.\n# TYPE ntp_probe_duration_seconds gauge\nntp_probe_duration_seconds %s\n",
+		strconv.FormatFloat(took.Seconds(), 'g', -1, 64))
+}
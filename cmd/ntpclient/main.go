@@ -0,0 +1,111 @@
+// Command ntpclient queries NTP servers and prints what each answered
+// as a table.
+//
+// Usage:
+//
+//	ntpclient [-timeout d] [-version n] [-config file] [-profile name] [server ...]
+//
+// Servers may include a port; without any, those of the configuration
+// profile are queried. Every server is queried once, concurrently, and
+// each that answers gets a row in argument order:
+//
+//	server           stratum  refid          offset(ms)  delay(ms)  rootdisp(ms)
+//	time.example.com 2        192.0.2.1      +0.412      18.204     0.610
+//
+// Servers that fail are reported on standard error with the failure's
+// code. It exits 0 if any server answered, 1 if none did and 2 on a
+// usage error.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+	"github.com/chaitanyav/ntp/config"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("ntpclient", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", 0, "time to wait for each server (default from profile, else 2s)")
+	version := fs.Int("version", 0, "NTP version to send (default from profile, else 4)")
+	configPath := fs.String("config", "", "configuration file (default $NTP_CONFIG or the user config dir)")
+	profileName := fs.String("profile", os.Getenv("NTP_PROFILE"), "configuration profile to take defaults from")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: ntpclient [flags] [server ...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	p, err := config.LoadProfile(*configPath, *profileName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ntpclient:", err)
+		return 2
+	}
+	targets := fs.Args()
+	if len(targets) == 0 {
+		targets = p.Servers
+	}
+	if len(targets) == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *timeout == 0 {
+		*timeout = p.Timeout
+	}
+	if *timeout == 0 {
+		*timeout = 2 * time.Second
+	}
+	if *version == 0 {
+		*version = p.Version
+	}
+	if *version == 0 {
+		*version = 4
+	}
+
+	var c ntp.Client
+	results := make([]*ntp.Response, len(targets))
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, server := range targets {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			results[i], errs[i] = c.QueryContext(context.Background(), server,
+				ntp.WithTimeout(*timeout), ntp.WithVersion(*version))
+		}(i, server)
+	}
+	wg.Wait()
+
+	answered := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "server\tstratum\trefid\toffset(ms)\tdelay(ms)\trootdisp(ms)")
+	for i, r := range results {
+		if errs[i] != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v (%s)\n", targets[i], errs[i], ntp.CodeOf(errs[i]))
+			continue
+		}
+		answered++
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%+.3f\t%.3f\t%.3f\n", targets[i], r.Stratum, r.ReferenceID,
+			ms(r.ClockOffset), ms(r.RTT), ms(r.RootDispersion))
+	}
+	tw.Flush()
+	if answered == 0 {
+		return 1
+	}
+	return 0
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
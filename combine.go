@@ -0,0 +1,102 @@
+package ntp
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// NMIN is the number of survivors below which Cluster stops discarding
+// outliers, as in RFC 5905.
+const NMIN = 3
+
+// Estimate is the system offset combined from the survivors of
+// Cluster.
+type Estimate struct {
+	// Offset is the survivors' offsets averaged with weights of one over
+	// their root distance.
+	Offset time.Duration
+	// Jitter combines the system peer's jitter with the RMS spread of
+	// the survivors' offsets about its own.
+	Jitter time.Duration
+	// Uncertainty bounds the error of Offset, assuming the majority is
+	// right: the true offset lies within the intersection, so Offset is
+	// off by at most its distance to the farther end.
+	Uncertainty time.Duration
+	// Peer is the system peer, the best survivor, and Survivors all of
+	// them best first; both index the Responses given to Cluster.
+	Peer      int
+	Survivors []int
+}
+
+// Cluster whittles the truechimers of x down to the servers that agree
+// most closely, as RFC 5905's cluster algorithm does, and returns them
+// ordered from best to worst: lower stratum first, then less root
+// distance. It repeatedly drops the survivor whose offset is furthest
+// from the others' (the largest selection jitter) until that spread is
+// below the least jitter of any single survivor, or NMIN are left.
+// jitter holds each server's own jitter, e.g. from a Filter, indexed
+// like rs; if nil, each server's precision stands in for it.
+func Cluster(rs []*Response, x *Intersection, jitter []time.Duration) []int {
+	s := append([]int(nil), x.Truechimers...)
+	metric := func(i int) time.Duration {
+		return time.Duration(rs[i].Stratum)*MAXDIST + rs[i].RootDistance()
+	}
+	sort.SliceStable(s, func(a, b int) bool { return metric(s[a]) < metric(s[b]) })
+
+	for len(s) > NMIN {
+		worst, maxSel := 0, -1.0
+		minPeer := time.Duration(math.MaxInt64)
+		for a, i := range s {
+			var sum float64
+			for _, j := range s {
+				d := float64(rs[i].ClockOffset - rs[j].ClockOffset)
+				sum += d * d
+			}
+			if sel := math.Sqrt(sum / float64(len(s)-1)); sel > maxSel {
+				worst, maxSel = a, sel
+			}
+			minPeer = min(minPeer, peerJitter(rs, jitter, i))
+		}
+		if maxSel <= float64(minPeer) {
+			break
+		}
+		s = append(s[:worst], s[worst+1:]...)
+	}
+	return s
+}
+
+// peerJitter is server i's own jitter for Cluster and Combine.
+func peerJitter(rs []*Response, jitter []time.Duration, i int) time.Duration {
+	if jitter != nil {
+		return jitter[i]
+	}
+	return rs[i].Precision
+}
+
+// Combine averages the offsets of survivors, as returned by Cluster on
+// x, into an Estimate. jitter is as for Cluster. It returns nil if
+// there are no survivors.
+func Combine(rs []*Response, x *Intersection, survivors []int, jitter []time.Duration) *Estimate {
+	if len(survivors) == 0 {
+		return nil
+	}
+	peer := survivors[0]
+	var wsum, osum, jsum float64
+	for _, i := range survivors {
+		w := 1 / float64(rs[i].RootDistance())
+		wsum += w
+		osum += w * float64(rs[i].ClockOffset)
+		d := float64(rs[i].ClockOffset - rs[peer].ClockOffset)
+		jsum += w * d * d
+	}
+	pj := float64(peerJitter(rs, jitter, peer))
+	e := &Estimate{
+		Offset:    time.Duration(osum / wsum),
+		Jitter:    time.Duration(math.Sqrt(pj*pj + jsum/wsum)),
+		Peer:      peer,
+		Survivors: survivors,
+	}
+	e.Uncertainty = max(x.High-e.Offset, e.Offset-x.Low)
+	return e
+}
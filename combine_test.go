@@ -0,0 +1,81 @@
+package ntp_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+	"github.com/chaitanyav/ntp/ntptest"
+)
+
+func TestClusterCombine(t *testing.T) {
+	disp := 10 * time.Millisecond
+	rs := queryAll(t,
+		ntptest.Config{Stratum: 2, Offset: 0, RootDispersion: disp},
+		ntptest.Config{Stratum: 1, Offset: time.Millisecond, RootDispersion: disp},
+		ntptest.Config{Stratum: 2, Offset: 2 * time.Millisecond, RootDispersion: disp},
+		ntptest.Config{Stratum: 3, Offset: 4 * time.Millisecond, RootDispersion: disp},
+		ntptest.Config{Stratum: 2, Offset: 30 * time.Millisecond, RootDispersion: disp},
+	)
+	x, err := ntp.Intersect(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(x.Falsetickers, []int{4}) {
+		t.Fatalf("falsetickers %v, want [4]", x.Falsetickers)
+	}
+	// Without jitters the servers' precision, about 1µs, stands in,
+	// which no spread of milliseconds is below: Cluster keeps dropping
+	// the furthest out down to NMIN, lowest stratum first.
+	s := ntp.Cluster(rs, x, nil)
+	if len(s) != ntp.NMIN {
+		t.Fatalf("survivors %v, want %d of them", s, ntp.NMIN)
+	}
+	if s[0] != 1 {
+		t.Errorf("best survivor %d, want the stratum 1 server", s[0])
+	}
+	if slices.Contains(s, 3) {
+		t.Errorf("survivors %v include the server furthest from the rest", s)
+	}
+
+	e := ntp.Combine(rs, x, s, nil)
+	if e == nil {
+		t.Fatal("Combine returned nil")
+	}
+	if e.Peer != 1 || !slices.Equal(e.Survivors, s) {
+		t.Errorf("peer %d and survivors %v, want 1 and %v", e.Peer, e.Survivors, s)
+	}
+	if e.Offset < -500*time.Microsecond || e.Offset > 2500*time.Microsecond {
+		t.Errorf("combined offset %v, want one within the survivors' 0 to 2ms", e.Offset)
+	}
+	if e.Uncertainty < e.Offset-x.Low || e.Uncertainty < x.High-e.Offset {
+		t.Errorf("uncertainty %v does not reach both ends of [%v, %v] from %v", e.Uncertainty, x.Low, x.High, e.Offset)
+	}
+}
+
+func TestCombineWeights(t *testing.T) {
+	// With no round trip, root delay or precision, the root distance
+	// is MINDISP/2 plus the root dispersion.
+	d := 10 * time.Millisecond
+	rs := []*ntp.Response{
+		{ClockOffset: 0, RootDispersion: d - ntp.MINDISP/2},
+		{ClockOffset: 3 * time.Millisecond, RootDispersion: 2*d - ntp.MINDISP/2},
+	}
+	x, err := ntp.Intersect(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := ntp.Combine(rs, x, []int{0, 1}, []time.Duration{time.Millisecond, time.Millisecond})
+	// Weights of one over the distance, 1/d and 1/2d, put the
+	// average a third of the way from 0 to 3ms.
+	if e.Offset != time.Millisecond {
+		t.Errorf("combined offset %v, want 1ms", e.Offset)
+	}
+	if e.Jitter < time.Millisecond {
+		t.Errorf("jitter %v, want at least the peer's own 1ms", e.Jitter)
+	}
+	if e := ntp.Combine(rs, x, nil, nil); e != nil {
+		t.Errorf("Combine with no survivors = %+v, want nil", e)
+	}
+}
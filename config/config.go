@@ -0,0 +1,412 @@
+// Package config reads the configuration file shared by the ntp
+// commands. The file holds a set of defaults and any number of named
+// profiles, e.g.
+//
+//	# used by every profile unless overridden
+//	servers = ["pool.ntp.org"]
+//	timeout = "2s"
+//	default_profile = "lab"
+//
+//	[profile.prod]
+//	servers = ["ntp1.example.com", "ntp2.example.com"]
+//	keys = "/etc/ntp/keys"
+//	key_id = 1
+//
+//	[profile.lab]
+//	extends = "prod"
+//	servers = ["10.0.0.5"]
+//	format = "json"
+//
+// The syntax is the small subset of TOML needed for this: comments,
+// [profile.NAME] tables, and string, integer, boolean and string-array
+// values.
+//
+// The package also reads the richer configuration of a long-running
+// daemon, in TOML or YAML; see Daemon.
+//
+// Settings are taken, from lowest to highest precedence, from the file
+// defaults, the profiles a profile extends, the profile itself, and
+// the NTP_SERVERS, NTP_TIMEOUT and NTP_VERSION environment variables
+// (see ApplyEnv). Command-line flags override all of these.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile is a resolved set of command defaults.
+type Profile struct {
+	Name string
+
+	Servers []string
+	Timeout time.Duration
+	Version int
+	Format  string
+
+	// Keys is the path to a symmetric key file and KeyID the key to
+	// use from it.
+	Keys  string
+	KeyID int
+
+	// NTS enables Network Time Security; NTSServer is the NTS-KE
+	// server when it differs from the time server.
+	NTS       bool
+	NTSServer string
+
+	// LeapFile is where the leapfile command installs
+	// leap-seconds.list, and LeapURLs the mirrors it fetches from.
+	LeapFile string
+	LeapURLs []string
+}
+
+type value struct {
+	line int
+	kind int
+	str  string
+	list []string
+	num  int64
+	bool bool
+}
+
+type section struct {
+	name   string
+	line   int
+	values map[string]value
+}
+
+// File is a parsed configuration file.
+type File struct {
+	path           string
+	defaults       *section
+	profiles       map[string]*section
+	DefaultProfile string
+}
+
+// Value kinds.
+const (
+	kindString = iota + 1
+	kindList
+	kindInt
+	kindBool
+)
+
+var kindNames = map[int]string{
+	kindString: "a string",
+	kindList:   "an array of strings",
+	kindInt:    "an integer",
+	kindBool:   "a boolean",
+}
+
+type setting struct {
+	kind int
+	set  func(p *Profile, v value) error
+}
+
+var known = map[string]setting{
+	"servers": {kindList, func(p *Profile, v value) error { p.Servers = v.list; return nil }},
+	"timeout": {kindString, func(p *Profile, v value) error {
+		d, err := time.ParseDuration(v.str)
+		if err != nil {
+			return err
+		}
+		p.Timeout = d
+		return nil
+	}},
+	"version": {kindInt, func(p *Profile, v value) error {
+		if v.num < 1 || v.num > 4 {
+			return fmt.Errorf("unsupported version %d", v.num)
+		}
+		p.Version = int(v.num)
+		return nil
+	}},
+	"format": {kindString, func(p *Profile, v value) error {
+		if v.str != "text" && v.str != "json" {
+			return fmt.Errorf("unknown format %q", v.str)
+		}
+		p.Format = v.str
+		return nil
+	}},
+	"keys":       {kindString, func(p *Profile, v value) error { p.Keys = v.str; return nil }},
+	"key_id":     {kindInt, func(p *Profile, v value) error { p.KeyID = int(v.num); return nil }},
+	"nts":        {kindBool, func(p *Profile, v value) error { p.NTS = v.bool; return nil }},
+	"nts_server": {kindString, func(p *Profile, v value) error { p.NTSServer = v.str; return nil }},
+	"leapfile":   {kindString, func(p *Profile, v value) error { p.LeapFile = v.str; return nil }},
+	"leap_urls":  {kindList, func(p *Profile, v value) error { p.LeapURLs = v.list; return nil }},
+	"extends":    {kindString, func(p *Profile, v value) error { return nil }},
+}
+
+// DefaultPath is where the commands look for the file when none is
+// given: $NTP_CONFIG if set, otherwise ntp/config.toml under the user
+// configuration directory.
+func DefaultPath() string {
+	if p := os.Getenv("NTP_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ntp", "config.toml")
+}
+
+// LoadProfile resolves the named profile from the file at path, or from
+// DefaultPath when path is empty, and applies the environment on top. A
+// missing default file yields a profile from the environment alone
+// rather than an error, unless a profile was asked for.
+func LoadProfile(path, name string) (Profile, error) {
+	explicit := path != ""
+	if !explicit {
+		path = DefaultPath()
+	}
+	var p Profile
+	if path != "" {
+		f, err := Load(path)
+		switch {
+		case err == nil:
+			if p, err = f.Resolve(name); err != nil {
+				return Profile{}, err
+			}
+		case !explicit && name == "" && errors.Is(err, fs.ErrNotExist):
+		default:
+			return Profile{}, err
+		}
+	}
+	if err := ApplyEnv(&p); err != nil {
+		return Profile{}, err
+	}
+	return p, nil
+}
+
+// ApplyEnv overrides p with the environment, so that containers can be
+// pointed at internal time servers without a configuration file:
+// NTP_SERVERS is a comma- or space-separated server list, NTP_TIMEOUT a
+// duration such as "2s", and NTP_VERSION the protocol version. Unset
+// or empty variables leave p alone. Package ntp reads the same
+// variables as the defaults of library queries.
+func ApplyEnv(p *Profile) error {
+	if v := os.Getenv("NTP_SERVERS"); v != "" {
+		servers := strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+		if len(servers) == 0 {
+			return errors.New("NTP_SERVERS: no servers listed")
+		}
+		p.Servers = servers
+	}
+	if v := os.Getenv("NTP_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("NTP_TIMEOUT: bad duration %q", v)
+		}
+		p.Timeout = d
+	}
+	if v := os.Getenv("NTP_VERSION"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 4 {
+			return fmt.Errorf("NTP_VERSION: unsupported version %q", v)
+		}
+		p.Version = n
+	}
+	return nil
+}
+
+// Load reads and parses the file at path.
+func Load(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(path, f)
+}
+
+// Parse parses a configuration file; name is used in error messages.
+func Parse(name string, r io.Reader) (*File, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{
+		path:     name,
+		defaults: &section{values: map[string]value{}},
+		profiles: map[string]*section{},
+	}
+	cur := f.defaults
+	for i, line := range strings.Split(string(data), "\n") {
+		n := i + 1
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, f.errorf(n, "unterminated table header")
+			}
+			header := strings.TrimSpace(line[1 : len(line)-1])
+			pname, ok := strings.CutPrefix(header, "profile.")
+			if !ok || pname == "" {
+				return nil, f.errorf(n, "unknown table [%s]; expected [profile.NAME]", header)
+			}
+			pname = unquote(pname)
+			if _, dup := f.profiles[pname]; dup {
+				return nil, f.errorf(n, "profile %q defined twice", pname)
+			}
+			cur = &section{name: pname, line: n, values: map[string]value{}}
+			f.profiles[pname] = cur
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, f.errorf(n, "expected key = value")
+		}
+		key = strings.TrimSpace(key)
+		v, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, f.errorf(n, "%s: %v", key, err)
+		}
+		v.line = n
+		if key == "default_profile" && cur == f.defaults && v.kind == kindString {
+			f.DefaultProfile = v.str
+			continue
+		}
+		st, ok := known[key]
+		if !ok {
+			return nil, f.errorf(n, "unknown key %q", key)
+		}
+		if v.kind != st.kind {
+			return nil, f.errorf(n, "%s must be %s", key, kindNames[st.kind])
+		}
+		if _, dup := cur.values[key]; dup {
+			return nil, f.errorf(n, "%s set twice", key)
+		}
+		if err := st.set(&Profile{}, v); err != nil {
+			return nil, f.errorf(n, "%s: %v", key, err)
+		}
+		cur.values[key] = v
+	}
+	if f.DefaultProfile != "" {
+		if _, ok := f.profiles[f.DefaultProfile]; !ok {
+			return nil, fmt.Errorf("%s: default_profile %q is not defined", name, f.DefaultProfile)
+		}
+	}
+	return f, nil
+}
+
+// Profiles lists the names of the profiles in the file.
+func (f *File) Profiles() []string {
+	names := make([]string, 0, len(f.profiles))
+	for name := range f.profiles {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Resolve builds the named profile: the file defaults, then each
+// profile it extends (outermost first), then the profile itself. An
+// empty name selects DefaultProfile, or just the defaults if there is
+// none.
+func (f *File) Resolve(name string) (Profile, error) {
+	if name == "" {
+		name = f.DefaultProfile
+	}
+	var chain []*section
+	seen := map[string]bool{}
+	for next := name; next != ""; {
+		s, ok := f.profiles[next]
+		if !ok {
+			return Profile{}, fmt.Errorf("%s: no profile %q", f.path, next)
+		}
+		if seen[next] {
+			return Profile{}, f.errorf(s.line, "profile %q extends itself", next)
+		}
+		seen[next] = true
+		chain = append(chain, s)
+		next = s.values["extends"].str
+	}
+	chain = append(chain, f.defaults)
+
+	p := Profile{Name: name}
+	for i := len(chain) - 1; i >= 0; i-- {
+		for key, v := range chain[i].values {
+			if err := known[key].set(&p, v); err != nil {
+				return Profile{}, f.errorf(v.line, "%s: %v", key, err)
+			}
+		}
+	}
+	return p, nil
+}
+
+func (f *File) errorf(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", f.path, line, fmt.Sprintf(format, args...))
+}
+
+func parseValue(raw string) (value, error) {
+	switch {
+	case raw == "":
+		return value{}, errors.New("missing value")
+	case raw == "true" || raw == "false":
+		return value{kind: kindBool, bool: raw == "true"}, nil
+	case raw[0] == '"':
+		s, err := strconv.Unquote(raw)
+		if err != nil {
+			return value{}, fmt.Errorf("bad string %s", raw)
+		}
+		return value{kind: kindString, str: s}, nil
+	case raw[0] == '[':
+		if !strings.HasSuffix(raw, "]") {
+			return value{}, errors.New("unterminated array")
+		}
+		list := []string{}
+		for _, item := range strings.Split(raw[1:len(raw)-1], ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			s, err := strconv.Unquote(item)
+			if err != nil {
+				return value{}, fmt.Errorf("bad array element %s", item)
+			}
+			list = append(list, s)
+		}
+		return value{kind: kindList, list: list}, nil
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return value{}, fmt.Errorf("cannot parse %q", raw)
+	}
+	return value{kind: kindInt, num: n}, nil
+}
+
+// stripComment removes a trailing # comment that is not inside a
+// string.
+func stripComment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+func unquote(s string) string {
+	if u, err := strconv.Unquote(s); err == nil {
+		return u
+	}
+	return s
+}
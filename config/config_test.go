@@ -0,0 +1,150 @@
+package config_test
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chaitanyav/ntp/config"
+)
+
+// example is the daemon configuration of the Daemon doc comment.
+var example = &config.Daemon{
+	Sources:   []config.Source{{Address: "time.example.com", Type: "server", MinPoll: 6, MaxPoll: 10, IBurst: true}},
+	Refclocks: []config.Refclock{{Driver: "PPS", Device: "/dev/pps0", RefID: "PPS", Poll: 4}},
+	ACL:       []config.ACLRule{{Network: netip.MustParsePrefix("10.0.0.0/8"), Action: "allow"}},
+	Server:    config.Server{Listen: []string{":123"}},
+	Metrics:   config.Metrics{Listen: ":9559", Path: "/metrics"},
+	Logging:   config.Logging{Level: "info", Format: "text"},
+}
+
+func TestParseDaemon(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		doc    string
+		want   *config.Daemon
+	}{
+		{"toml", "toml", `
+[[sources]]
+address = "time.example.com"
+iburst = true
+
+[[refclocks]]
+driver = "PPS"
+device = "/dev/pps0"
+
+[[acl]]
+network = "10.0.0.0/8"
+action = "allow"
+
+[server]
+listen = [":123"]
+
+[metrics]
+listen = ":9559"
+`, example},
+		{"yaml", "yaml", `
+sources:
+  - address: time.example.com
+    iburst: true
+refclocks:
+  - driver: PPS
+    device: /dev/pps0
+acl:
+  - network: 10.0.0.0/8
+    action: allow
+server:
+  listen: [":123"]
+metrics:
+  listen: ":9559"
+`, example},
+		{"toml array over lines", "toml", `
+[keys]
+trusted = [
+  1, # first
+  2,
+]
+[[sources]]
+address = "a"
+type = "pool"
+minpoll = 4
+key = 1
+`, &config.Daemon{
+			Sources: []config.Source{{Address: "a", Type: "pool", MinPoll: 4, MaxPoll: 10, Key: 1}},
+			Keys:    config.Keys{Trusted: []int{1, 2}},
+			Logging: config.Logging{Level: "info", Format: "text"},
+		}},
+		{"yaml sequence at key indentation", "yaml", `
+sources:
+- address: 'a # not a comment'
+  prefer: true
+- address: "b"
+logging:
+  level: WARN  # case is ignored
+driftfile: ~
+`, &config.Daemon{
+			Sources: []config.Source{
+				{Address: "a # not a comment", Type: "server", MinPoll: 6, MaxPoll: 10, Prefer: true},
+				{Address: "b", Type: "server", MinPoll: 6, MaxPoll: 10},
+			},
+			Logging: config.Logging{Level: "warn", Format: "text"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := config.ParseDaemon("test", strings.NewReader(tt.doc), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(d, tt.want) {
+				t.Errorf("got %+v, want %+v", d, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDaemonErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		doc    string
+		line   int
+		msg    string
+	}{
+		{"toml unterminated string", "toml", "[[sources]]\naddress = \"a\n", 2, "bad string"},
+		{"toml unterminated array", "toml", "[server]\nlisten = [\n  \":123\",\n", 2, "unterminated array"},
+		{"toml unterminated header", "toml", "[[sources]]\naddress = \"a\"\n[server\n", 3, "unterminated table header"},
+		{"toml duplicate key", "toml", "driftfile = \"a\"\n\ndriftfile = \"b\"\n", 3, "set twice"},
+		{"toml duplicate table", "toml", "[server]\n[metrics]\n[server]\n", 3, "table defined twice"},
+		{"toml type mismatch", "toml", "[[sources]]\naddress = \"a\"\niburst = \"true\"\n", 3, "must be true or false"},
+		{"toml list for a table", "toml", "[[sources]]\naddress = \"a\"\n[[server]]\n", 3, "must be a table"},
+		{"toml unknown field", "toml", "[[sources]]\naddress = \"a\"\n# comment\nserver = \"b\"\n", 4, "unknown setting"},
+		{"toml missing field", "toml", "[[sources]]\ntype = \"pool\"\n", 1, "address is required"},
+
+		{"yaml bad indentation", "yaml", "sources:\n  - address: a\n     iburst: true\n", 3, "bad indentation"},
+		{"yaml bad sequence indentation", "yaml", "sources:\n  - address: a\n   - address: b\n", 3, "bad indentation"},
+		{"yaml tab", "yaml", "sources:\n\t- address: a\n", 2, "tabs"},
+		{"yaml unterminated string", "yaml", "sources:\n  - address: 'a\n", 2, "unterminated string"},
+		{"yaml bad string", "yaml", "driftfile: \"a\n", 1, "bad string"},
+		{"yaml duplicate key", "yaml", "sources:\n  - address: a\n    address: b\n", 3, "set twice"},
+		{"yaml type mismatch", "yaml", "sources:\n  - address: a\n    minpoll: six\n", 3, "must be an integer"},
+		{"yaml out of range", "yaml", "sources:\n  - address: a\n    maxpoll: 99\n", 3, "out of range"},
+		{"yaml unknown field", "yaml", "sources:\n  - address: a\n\n    burst: true\n", 4, "unknown setting"},
+		{"yaml unsupported value", "yaml", "sources: &s\n", 1, "not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := config.ParseDaemon("test", strings.NewReader(tt.doc), tt.format)
+			if err == nil {
+				t.Fatal("parsed without error")
+			}
+			prefix := fmt.Sprintf("test:%d: ", tt.line)
+			if !strings.HasPrefix(err.Error(), prefix) || !strings.Contains(err.Error(), tt.msg) {
+				t.Errorf("error %q, want one at line %d about %q", err, tt.line, tt.msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,454 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Daemon is the configuration of a long-running time service: where it
+// gets time from, whom it serves, and how it reports. It is read from a
+// TOML or YAML file, e.g.
+//
+//	[[sources]]
+//	address = "time.example.com"
+//	iburst = true
+//
+//	[[refclocks]]
+//	driver = "PPS"
+//	device = "/dev/pps0"
+//
+//	[[acl]]
+//	network = "10.0.0.0/8"
+//	action = "allow"
+//
+//	[server]
+//	listen = [":123"]
+//
+//	[metrics]
+//	listen = ":9559"
+//
+// or, the same in YAML,
+//
+//	sources:
+//	  - address: time.example.com
+//	    iburst: true
+//	refclocks:
+//	  - driver: PPS
+//	    device: /dev/pps0
+//	acl:
+//	  - network: 10.0.0.0/8
+//	    action: allow
+//	server:
+//	  listen: [":123"]
+//	metrics:
+//	  listen: ":9559"
+//
+// Omitted settings take the defaults documented on each field.
+type Daemon struct {
+	Sources   []Source
+	Refclocks []Refclock
+	ACL       []ACLRule
+	Keys      Keys
+	Server    Server
+	Metrics   Metrics
+	Logging   Logging
+
+	// DriftFile is where the frequency estimate is kept across
+	// restarts; empty disables it.
+	DriftFile string
+}
+
+// Source is an upstream NTP server, pool or peer.
+type Source struct {
+	Address string
+	// Type is "server" (the default), "pool" or "peer".
+	Type string
+	// MinPoll and MaxPoll bound the poll interval as log2 seconds;
+	// they default to 6 (64s) and 10 (1024s).
+	MinPoll, MaxPoll int
+	IBurst           bool
+	Prefer           bool
+	// Key is the symmetric key ID to authenticate with; 0 for none.
+	Key int
+	NTS bool
+}
+
+// Refclock is a local reference clock.
+type Refclock struct {
+	// Driver is one of "PPS", "SHM", "SOCK" or "PHC".
+	Driver string
+	Device string
+	// RefID is the reference identifier, at most four characters; it
+	// defaults to the driver name.
+	RefID string
+	// Offset corrects the clock's readings; Delay is the dispersion
+	// to assume for them.
+	Offset time.Duration
+	Delay  time.Duration
+	// Poll is the log2 seconds between samples, default 4.
+	Poll   int
+	Prefer bool
+}
+
+// ACLRule grants or restricts access for clients in Network. The rule
+// with the longest matching prefix applies.
+type ACLRule struct {
+	Network netip.Prefix
+	// Action is "allow", "deny", "noquery" (time but no control
+	// queries) or "limited" (rate-limited).
+	Action string
+}
+
+// Keys locates the symmetric keys.
+type Keys struct {
+	File    string
+	Trusted []int
+}
+
+// Server controls serving time to clients.
+type Server struct {
+	// Listen lists the addresses to serve on; it defaults to ":123"
+	// when ACL rules are given and is empty (no serving) otherwise.
+	Listen []string
+}
+
+// Metrics controls the Prometheus endpoint.
+type Metrics struct {
+	// Listen is the HTTP address, empty to disable.
+	Listen string
+	// Path defaults to "/metrics".
+	Path string
+}
+
+// Logging controls diagnostic output.
+type Logging struct {
+	// Level is "debug", "info" (the default), "warn" or "error".
+	Level string
+	// Format is "text" (the default) or "json".
+	Format string
+	// File is the log file; empty means standard error.
+	File string
+}
+
+// LoadDaemon reads a daemon configuration from path. Files ending in
+// .yaml or .yml are read as YAML and all others as TOML.
+func LoadDaemon(path string) (*Daemon, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	format := "toml"
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		format = "yaml"
+	}
+	return ParseDaemon(path, f, format)
+}
+
+// ParseDaemon parses, checks and fills in the defaults of a daemon
+// configuration in format, "toml" or "yaml". Errors name the file and
+// line; name is used as the file name.
+func ParseDaemon(name string, r io.Reader, format string) (*Daemon, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var root *node
+	switch format {
+	case "toml":
+		root, err = parseTOML(name, string(data))
+	case "yaml":
+		root, err = parseYAML(name, string(data))
+	default:
+		return nil, fmt.Errorf("config: unknown format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := &decoder{name: name}
+	cfg := d.daemon(root)
+	if d.err != nil {
+		return nil, d.err
+	}
+	return cfg, nil
+}
+
+// decoder checks a parsed tree against the schema, keeping the first
+// error.
+type decoder struct {
+	name string
+	err  error
+}
+
+func (d *decoder) errorf(n *node, path, format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%s:%d: %s: %s", d.name, n.line, path, fmt.Sprintf(format, args...))
+	}
+}
+
+// fields calls fn for each key of the table n, rejecting keys not in
+// known.
+func (d *decoder) fields(n *node, path string, known string, fn func(key, path string, v *node)) {
+	if n.kind != nodeMap {
+		d.errorf(n, path, "must be a table, not %s", n.kindName())
+		return
+	}
+	allowed := strings.Fields(known)
+	for _, key := range n.keys {
+		p := key
+		if path != "" {
+			p = path + "." + key
+		}
+		ok := false
+		for _, a := range allowed {
+			ok = ok || a == key
+		}
+		if !ok {
+			d.errorf(n.vals[key], p, "unknown setting")
+			continue
+		}
+		fn(key, p, n.vals[key])
+	}
+}
+
+func (d *decoder) list(n *node, path string, fn func(i int, path string, v *node)) {
+	if n.kind != nodeList {
+		d.errorf(n, path, "must be a list, not %s", n.kindName())
+		return
+	}
+	for i, item := range n.items {
+		fn(i, fmt.Sprintf("%s[%d]", path, i), item)
+	}
+}
+
+func (d *decoder) str(n *node, path string) string {
+	if n.kind != nodeScalar {
+		d.errorf(n, path, "must be a string, not %s", n.kindName())
+	}
+	return n.text
+}
+
+func (d *decoder) oneOf(n *node, path string, choices string) string {
+	s := d.str(n, path)
+	for _, c := range strings.Fields(choices) {
+		if strings.EqualFold(s, c) {
+			return c
+		}
+	}
+	d.errorf(n, path, "%q is not one of %s", s, strings.ReplaceAll(choices, " ", ", "))
+	return s
+}
+
+func (d *decoder) integer(n *node, path string, min, max int) int {
+	if n.kind != nodeScalar || n.quoted {
+		d.errorf(n, path, "must be an integer")
+		return 0
+	}
+	v, err := strconv.Atoi(n.text)
+	if err != nil {
+		d.errorf(n, path, "must be an integer")
+		return 0
+	}
+	if v < min || v > max {
+		d.errorf(n, path, "%d is out of range %d to %d", v, min, max)
+	}
+	return v
+}
+
+func (d *decoder) boolean(n *node, path string) bool {
+	if n.kind != nodeScalar || n.quoted || (n.text != "true" && n.text != "false") {
+		d.errorf(n, path, "must be true or false")
+		return false
+	}
+	return n.text == "true"
+}
+
+func (d *decoder) duration(n *node, path string) time.Duration {
+	v, err := time.ParseDuration(d.str(n, path))
+	if err != nil {
+		d.errorf(n, path, "bad duration %q", n.text)
+	}
+	return v
+}
+
+func (d *decoder) strList(n *node, path string) []string {
+	var out []string
+	d.list(n, path, func(_ int, p string, v *node) { out = append(out, d.str(v, p)) })
+	return out
+}
+
+func (d *decoder) daemon(n *node) *Daemon {
+	cfg := &Daemon{}
+	d.fields(n, "", "sources refclocks acl keys server metrics logging driftfile", func(key, path string, v *node) {
+		switch key {
+		case "sources":
+			d.list(v, path, func(_ int, p string, v *node) { cfg.Sources = append(cfg.Sources, d.source(v, p)) })
+		case "refclocks":
+			d.list(v, path, func(_ int, p string, v *node) { cfg.Refclocks = append(cfg.Refclocks, d.refclock(v, p)) })
+		case "acl":
+			d.list(v, path, func(_ int, p string, v *node) { cfg.ACL = append(cfg.ACL, d.aclRule(v, p)) })
+		case "keys":
+			d.fields(v, path, "file trusted", func(key, p string, v *node) {
+				switch key {
+				case "file":
+					cfg.Keys.File = d.str(v, p)
+				case "trusted":
+					d.list(v, p, func(_ int, p string, v *node) {
+						cfg.Keys.Trusted = append(cfg.Keys.Trusted, d.integer(v, p, 1, 65535))
+					})
+				}
+			})
+		case "server":
+			d.fields(v, path, "listen", func(_, p string, v *node) { cfg.Server.Listen = d.strList(v, p) })
+		case "metrics":
+			d.fields(v, path, "listen path", func(key, p string, v *node) {
+				switch key {
+				case "listen":
+					cfg.Metrics.Listen = d.str(v, p)
+				case "path":
+					cfg.Metrics.Path = d.str(v, p)
+				}
+			})
+		case "logging":
+			d.fields(v, path, "level format file", func(key, p string, v *node) {
+				switch key {
+				case "level":
+					cfg.Logging.Level = d.oneOf(v, p, "debug info warn error")
+				case "format":
+					cfg.Logging.Format = d.oneOf(v, p, "text json")
+				case "file":
+					cfg.Logging.File = d.str(v, p)
+				}
+			})
+		case "driftfile":
+			cfg.DriftFile = d.str(v, path)
+		}
+	})
+	if d.err != nil {
+		return nil
+	}
+
+	if cfg.Server.Listen == nil && len(cfg.ACL) > 0 {
+		cfg.Server.Listen = []string{":123"}
+	}
+	if cfg.Metrics.Listen != "" && cfg.Metrics.Path == "" {
+		cfg.Metrics.Path = "/metrics"
+	}
+	if cfg.Logging.Level == "" {
+		cfg.Logging.Level = "info"
+	}
+	if cfg.Logging.Format == "" {
+		cfg.Logging.Format = "text"
+	}
+	if len(cfg.Sources) == 0 && len(cfg.Refclocks) == 0 {
+		d.errorf(n, "sources", "at least one source or refclock is required")
+	}
+	return cfg
+}
+
+func (d *decoder) source(n *node, path string) Source {
+	s := Source{Type: "server", MinPoll: 6, MaxPoll: 10}
+	var address *node
+	d.fields(n, path, "address type minpoll maxpoll iburst prefer key nts", func(key, p string, v *node) {
+		switch key {
+		case "address":
+			s.Address, address = d.str(v, p), v
+		case "type":
+			s.Type = d.oneOf(v, p, "server pool peer")
+		case "minpoll":
+			s.MinPoll = d.integer(v, p, 3, 17)
+		case "maxpoll":
+			s.MaxPoll = d.integer(v, p, 3, 17)
+		case "iburst":
+			s.IBurst = d.boolean(v, p)
+		case "prefer":
+			s.Prefer = d.boolean(v, p)
+		case "key":
+			s.Key = d.integer(v, p, 1, 65535)
+		case "nts":
+			s.NTS = d.boolean(v, p)
+		}
+	})
+	switch {
+	case d.err != nil:
+	case address == nil || s.Address == "":
+		d.errorf(n, path, "address is required")
+	case s.MinPoll > s.MaxPoll:
+		d.errorf(n, path, "minpoll %d is above maxpoll %d", s.MinPoll, s.MaxPoll)
+	case s.NTS && s.Key != 0:
+		d.errorf(n, path, "nts and key are mutually exclusive")
+	}
+	return s
+}
+
+func (d *decoder) refclock(n *node, path string) Refclock {
+	r := Refclock{Poll: 4}
+	d.fields(n, path, "driver device refid offset delay poll prefer", func(key, p string, v *node) {
+		switch key {
+		case "driver":
+			r.Driver = d.oneOf(v, p, "PPS SHM SOCK PHC")
+		case "device":
+			r.Device = d.str(v, p)
+		case "refid":
+			if r.RefID = d.str(v, p); len(r.RefID) > 4 {
+				d.errorf(v, p, "%q is longer than four characters", r.RefID)
+			}
+		case "offset":
+			r.Offset = d.duration(v, p)
+		case "delay":
+			r.Delay = d.duration(v, p)
+		case "poll":
+			r.Poll = d.integer(v, p, -6, 17)
+		case "prefer":
+			r.Prefer = d.boolean(v, p)
+		}
+	})
+	switch {
+	case d.err != nil:
+	case r.Driver == "":
+		d.errorf(n, path, "driver is required")
+	case r.Device == "":
+		d.errorf(n, path, "device is required")
+	}
+	if r.RefID == "" {
+		r.RefID = r.Driver
+	}
+	return r
+}
+
+func (d *decoder) aclRule(n *node, path string) ACLRule {
+	var r ACLRule
+	d.fields(n, path, "network action", func(key, p string, v *node) {
+		switch key {
+		case "network":
+			s := d.str(v, p)
+			pfx, err := netip.ParsePrefix(s)
+			if err != nil {
+				// A bare address is a rule for that host.
+				addr, aerr := netip.ParseAddr(s)
+				if aerr != nil {
+					d.errorf(v, p, "%q is not an address or CIDR prefix", s)
+					return
+				}
+				pfx = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			r.Network = pfx.Masked()
+		case "action":
+			r.Action = d.oneOf(v, p, "allow deny noquery limited")
+		}
+	})
+	switch {
+	case d.err != nil:
+	case !r.Network.IsValid():
+		d.errorf(n, path, "network is required")
+	case r.Action == "":
+		d.errorf(n, path, "action is required")
+	}
+	return r
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads the part of TOML that daemon files need: comments,
+// key = value pairs, [table] and [table.sub] headers, [[array]] tables,
+// and string, integer and boolean values, alone or in arrays that may
+// span lines.
+func parseTOML(name string, data string) (*node, error) {
+	root := newMap(1)
+	cur := root
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		n := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+		errorf := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%s:%d: %s", name, n, fmt.Sprintf(format, args...))
+		}
+
+		if strings.HasPrefix(line, "[") {
+			array := strings.HasPrefix(line, "[[")
+			end := "]"
+			if array {
+				end = "]]"
+			}
+			if !strings.HasSuffix(line, end) {
+				return nil, errorf("unterminated table header")
+			}
+			header := strings.TrimSpace(line[len(end) : len(line)-len(end)])
+			if header == "" {
+				return nil, errorf("empty table name")
+			}
+			t, err := tomlTable(root, strings.Split(header, "."), array, n)
+			if err != nil {
+				return nil, errorf("[%s]: %v", header, err)
+			}
+			cur = t
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, errorf("expected key = value")
+		}
+		key = unquote(strings.TrimSpace(key))
+		raw = strings.TrimSpace(raw)
+		// An array may continue over the following lines until its
+		// brackets balance.
+		for strings.HasPrefix(raw, "[") && !balanced(raw) && i+1 < len(lines) {
+			i++
+			raw += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+		v, err := tomlValue(raw, n)
+		if err != nil {
+			return nil, errorf("%s: %v", key, err)
+		}
+		if err := cur.set(key, v); err != nil {
+			return nil, errorf("%v", err)
+		}
+	}
+	return root, nil
+}
+
+// tomlTable finds or creates the table at path. For an [[array]] header
+// it appends a new table to the array instead.
+func tomlTable(root *node, path []string, array bool, line int) (*node, error) {
+	t := root
+	for i, part := range path {
+		part = unquote(strings.TrimSpace(part))
+		last := i == len(path)-1
+		next := t.vals[part]
+		switch {
+		case last && array:
+			if next == nil {
+				next = &node{line: line, kind: nodeList}
+				t.set(part, next)
+			} else if next.kind != nodeList {
+				return nil, fmt.Errorf("%s is already %s", part, next.kindName())
+			}
+			m := newMap(line)
+			next.items = append(next.items, m)
+			return m, nil
+		case next == nil:
+			next = newMap(line)
+			t.set(part, next)
+		case last && next.kind == nodeMap:
+			return nil, errors.New("table defined twice")
+		case next.kind == nodeList && len(next.items) > 0:
+			// [a.b] after [[a]] refers to the latest element of a.
+			next = next.items[len(next.items)-1]
+		}
+		if next.kind != nodeMap {
+			return nil, fmt.Errorf("%s is not a table", part)
+		}
+		t = next
+	}
+	return t, nil
+}
+
+func tomlValue(raw string, line int) (*node, error) {
+	switch {
+	case raw == "":
+		return nil, errors.New("missing value")
+	case raw[0] == '[':
+		if !strings.HasSuffix(raw, "]") || !balanced(raw) {
+			return nil, errors.New("unterminated array")
+		}
+		list := &node{line: line, kind: nodeList}
+		for _, item := range splitList(raw[1 : len(raw)-1]) {
+			v, err := tomlValue(item, line)
+			if err != nil {
+				return nil, err
+			}
+			if v.kind != nodeScalar {
+				return nil, errors.New("nested arrays are not supported")
+			}
+			list.items = append(list.items, v)
+		}
+		return list, nil
+	case raw[0] == '"':
+		s, err := strconv.Unquote(raw)
+		if err != nil {
+			return nil, fmt.Errorf("bad string %s", raw)
+		}
+		return &node{line: line, kind: nodeScalar, text: s, quoted: true}, nil
+	case raw == "true" || raw == "false":
+		return &node{line: line, kind: nodeScalar, text: raw}, nil
+	}
+	if _, err := strconv.ParseInt(raw, 10, 64); err != nil {
+		return nil, fmt.Errorf("cannot parse %q", raw)
+	}
+	return &node{line: line, kind: nodeScalar, text: raw}, nil
+}
+
+// balanced reports whether every [ in s outside a string is closed.
+func balanced(s string) bool {
+	depth := 0
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '[' && !quoted:
+			depth++
+		case c == ']' && !quoted:
+			depth--
+		}
+	}
+	return depth == 0
+}
+
+// splitList splits the inside of a flow array at the commas that are
+// outside strings, dropping empty elements so that a trailing comma is
+// allowed.
+func splitList(s string) []string {
+	var items []string
+	quoted := false
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			switch s[i] {
+			case '\\':
+				if quoted {
+					i++
+				}
+				continue
+			case '"', '\'':
+				quoted = !quoted
+				continue
+			case ',':
+				if quoted {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		if item := strings.TrimSpace(s[start:i]); item != "" {
+			items = append(items, item)
+		}
+		start = i + 1
+	}
+	return items
+}
@@ -0,0 +1,52 @@
+package config
+
+import "fmt"
+
+// node is a value parsed from a TOML or YAML document, before it is
+// checked against a schema. Every node remembers its line so that
+// schema errors can point at it.
+type node struct {
+	line int
+	kind int
+
+	// text is the value of a scalar; quoted is set when it was written
+	// as a string literal.
+	text   string
+	quoted bool
+
+	items []*node
+
+	keys []string // in file order
+	vals map[string]*node
+}
+
+// Node kinds.
+const (
+	nodeScalar = iota + 1
+	nodeList
+	nodeMap
+)
+
+func newMap(line int) *node {
+	return &node{line: line, kind: nodeMap, vals: map[string]*node{}}
+}
+
+// set adds key to a map node; it fails if the key is already present.
+func (n *node) set(key string, v *node) error {
+	if _, dup := n.vals[key]; dup {
+		return fmt.Errorf("%s set twice", key)
+	}
+	n.keys = append(n.keys, key)
+	n.vals[key] = v
+	return nil
+}
+
+func (n *node) kindName() string {
+	switch n.kind {
+	case nodeList:
+		return "a list"
+	case nodeMap:
+		return "a table"
+	}
+	return "a value"
+}
@@ -0,0 +1,264 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is one significant line of a YAML document.
+type yamlLine struct {
+	n      int
+	indent int
+	text   string
+}
+
+// parseYAML reads the block-style part of YAML that daemon files need:
+// nested mappings, sequences of scalars or mappings, flow sequences
+// such as [a, b], and plain, single- or double-quoted scalars. Anchors,
+// tags, flow mappings and multi-line scalars are rejected.
+func parseYAML(name string, data string) (*node, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(data, "\n") {
+		text := strings.TrimRight(yamlComment(raw), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("%s:%d: tabs are not allowed in indentation", name, i+1)
+		}
+		lines = append(lines, yamlLine{n: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	p := &yamlParser{name: name, lines: lines}
+	if len(lines) == 0 {
+		return newMap(1), nil
+	}
+	root, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(lines) {
+		return nil, p.errorf(lines[p.i].n, "unexpected indentation")
+	}
+	if root.kind != nodeMap {
+		return nil, p.errorf(root.line, "the document must be a mapping")
+	}
+	return root, nil
+}
+
+type yamlParser struct {
+	name  string
+	lines []yamlLine
+	i     int
+}
+
+func (p *yamlParser) errorf(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.name, line, fmt.Sprintf(format, args...))
+}
+
+// block parses the sequence or mapping whose entries start at indent.
+func (p *yamlParser) block(indent int) (*node, error) {
+	first := p.lines[p.i]
+	if isSeqItem(first.text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) sequence(indent int) (*node, error) {
+	seq := &node{line: p.lines[p.i].n, kind: nodeList}
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent < indent || l.indent == indent && !isSeqItem(l.text) {
+			break
+		}
+		if l.indent > indent {
+			return nil, p.errorf(l.n, "bad indentation of a sequence entry")
+		}
+		rest := strings.TrimLeft(l.text[1:], " ")
+		if rest == "" {
+			// The item is the block on the following lines.
+			p.i++
+			if p.i >= len(p.lines) || p.lines[p.i].indent <= indent {
+				seq.items = append(seq.items, &node{line: l.n, kind: nodeScalar})
+				continue
+			}
+			item, err := p.block(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			seq.items = append(seq.items, item)
+			continue
+		}
+		if _, _, ok := splitKey(rest); ok && rest[0] != '{' && rest[0] != '[' {
+			// "- key: value" opens a mapping indented to where its
+			// first key starts; parse it from a rewritten line.
+			inner := indent + len(l.text) - len(rest)
+			p.lines[p.i] = yamlLine{n: l.n, indent: inner, text: rest}
+			item, err := p.mapping(inner)
+			if err != nil {
+				return nil, err
+			}
+			seq.items = append(seq.items, item)
+			continue
+		}
+		item, err := p.scalar(rest, l.n)
+		if err != nil {
+			return nil, err
+		}
+		seq.items = append(seq.items, item)
+		p.i++
+	}
+	return seq, nil
+}
+
+func (p *yamlParser) mapping(indent int) (*node, error) {
+	m := newMap(p.lines[p.i].n)
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, p.errorf(l.n, "bad indentation of a mapping entry")
+		}
+		if isSeqItem(l.text) {
+			return nil, p.errorf(l.n, "expected a key, found a sequence entry")
+		}
+		key, rest, ok := splitKey(l.text)
+		if !ok {
+			return nil, p.errorf(l.n, "expected key: value")
+		}
+		p.i++
+		var v *node
+		switch {
+		case rest != "":
+			var err error
+			if v, err = p.scalar(rest, l.n); err != nil {
+				return nil, err
+			}
+		case p.i < len(p.lines) && p.lines[p.i].indent > indent:
+			var err error
+			if v, err = p.block(p.lines[p.i].indent); err != nil {
+				return nil, err
+			}
+		case p.i < len(p.lines) && p.lines[p.i].indent == indent && isSeqItem(p.lines[p.i].text):
+			// A sequence may sit at the same indentation as its key.
+			var err error
+			if v, err = p.sequence(indent); err != nil {
+				return nil, err
+			}
+		default:
+			v = &node{line: l.n, kind: nodeScalar}
+		}
+		if err := m.set(key, v); err != nil {
+			return nil, p.errorf(l.n, "%v", err)
+		}
+	}
+	return m, nil
+}
+
+// scalar parses an inline value: a flow sequence or a scalar.
+func (p *yamlParser) scalar(s string, line int) (*node, error) {
+	switch s[0] {
+	case '[':
+		if !strings.HasSuffix(s, "]") {
+			return nil, p.errorf(line, "unterminated flow sequence")
+		}
+		list := &node{line: line, kind: nodeList}
+		for _, item := range splitList(s[1 : len(s)-1]) {
+			v, err := p.scalar(item, line)
+			if err != nil {
+				return nil, err
+			}
+			if v.kind != nodeScalar {
+				return nil, p.errorf(line, "nested sequences are not supported")
+			}
+			list.items = append(list.items, v)
+		}
+		return list, nil
+	case '{', '&', '*', '!', '|', '>':
+		return nil, p.errorf(line, "%q values are not supported", s[:1])
+	}
+	text, quoted, err := yamlUnquote(s)
+	if err != nil {
+		return nil, p.errorf(line, "%v", err)
+	}
+	if !quoted && (text == "~" || text == "null") {
+		text = ""
+	}
+	return &node{line: line, kind: nodeScalar, text: text, quoted: quoted}, nil
+}
+
+func isSeqItem(s string) bool {
+	return s == "-" || strings.HasPrefix(s, "- ")
+}
+
+// splitKey splits "key: value" at the first colon that is outside
+// quotes and followed by a space or the end of the line.
+func splitKey(s string) (key, rest string, ok bool) {
+	quote := byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 {
+				quote = c
+			}
+		case c == ':' && (i+1 == len(s) || s[i+1] == ' '):
+			k, _, err := yamlUnquote(strings.TrimSpace(s[:i]))
+			if err != nil || k == "" {
+				return "", "", false
+			}
+			return k, strings.TrimSpace(s[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+func yamlUnquote(s string) (string, bool, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		u, err := strconv.Unquote(s)
+		if err != nil {
+			return "", false, fmt.Errorf("bad string %s", s)
+		}
+		return u, true, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", false, errors.New("unterminated string " + s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), true, nil
+	}
+	return s, false, nil
+}
+
+// yamlComment removes a # comment: one at the start of the line or
+// after a space, outside quotes.
+func yamlComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || line[i-1] == ' ' || line[i-1] == '[' || line[i-1] == ',' {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,295 @@
+package ntp
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// Clock discipline parameters from RFC 5905. STEPT is the default
+// offset above which the clock is stepped rather than slewed, WATCH
+// how long such an offset must persist before it is believed, and
+// PANICT the offset beyond which the discipline refuses to act at all.
+// MAXFREQ bounds the frequency correction, 500ppm.
+const (
+	STEPT   = 128 * time.Millisecond
+	WATCH   = 900 * time.Second
+	PANICT  = 1000 * time.Second
+	MAXFREQ = 500e-6
+)
+
+const (
+	// allan is the Allan intercept, in seconds: below it phase noise
+	// dominates and the PLL is used, above it frequency noise and the
+	// FLL takes over.
+	allan = 1500
+	// avg is the averaging constant for jitter and wander.
+	avg = 4
+	// loopPLL is the PLL loop gain, ntpd's CLOCK_PLL. RFC 5905's
+	// sample code has 65536, for a differently scaled offset.
+	loopPLL = 16
+	// loopFLL is the FLL loop gain, the poll exponent at which the FLL
+	// contributes fully (1/avg).
+	loopFLL = MAXPOLL + 1
+	// precisionFloor stands in for the local clock's precision in the
+	// jitter estimate.
+	precisionFloor = 1e-6
+)
+
+// ClockState is the state of a Discipline, RFC 5905's NSET to SYNC.
+type ClockState uint8
+
+const (
+	ClockUnset   ClockState = iota // no offset yet and no frequency given
+	ClockFreqSet                   // starting from a known frequency
+	ClockFreq                      // measuring the frequency
+	ClockSpike                     // a large offset is being watched
+	ClockSync                      // locked
+)
+
+var clockState = [5]string{"unset", "frequency set", "measuring frequency", "spike", "synchronized"}
+
+func (s ClockState) String() string {
+	if int(s) < len(clockState) {
+		return clockState[s]
+	}
+	return "ClockState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Correction is what a Discipline asks of the local clock after an
+// update.
+type Correction struct {
+	// Step, when not zero, is the amount to step the clock by at once;
+	// Phase is then zero.
+	Step time.Duration
+	// Phase is the amount to slew the clock by over the coming poll
+	// interval.
+	Phase time.Duration
+	// Frequency is the correction to the clock's rate, in parts per
+	// million, to hold until the next update: the total, not a
+	// change. Positive speeds the clock up.
+	Frequency float64
+	// Interval is the time until the next update, the poll interval
+	// the loop suggests. Where the clock's rate cannot be set, slewing
+	// by Frequency over Interval stands in for it.
+	Interval time.Duration
+}
+
+var errPanic = newError(NTP_ERR_PANIC, "ntp: offset exceeds the panic threshold; set the clock by hand")
+
+// Discipline is the RFC 5905 clock discipline: a hybrid phase- and
+// frequency-locked loop that turns a stream of offsets, as from a
+// Monitor, into corrections to the local clock's phase and rate. At
+// short poll intervals the PLL dominates, and its time constant grows
+// with the poll interval; beyond the Allan intercept the FLL takes
+// over. Offsets above StepThreshold are treated as spikes until they
+// persist for WATCH, and then step the clock; smaller ones are slewed.
+//
+// Discipline only computes; Apply, if set, is what changes the clock,
+// e.g. with package clockctl. The zero value, with no initial
+// frequency, starts by measuring the frequency for WATCH. A Discipline
+// is not safe for concurrent use.
+type Discipline struct {
+	// Frequency is the known frequency correction in parts per
+	// million, e.g. from a drift file. Set it before the first Update
+	// to skip measuring it.
+	Frequency float64
+	// AllowPanic lets the first update step the clock however far
+	// off it is, as ntpd -g does; later offsets above PANICT still
+	// fail.
+	AllowPanic bool
+	// StepThreshold is the offset above which the clock is stepped
+	// rather than slewed, once the offset has lasted for WATCH; zero
+	// means STEPT, 128ms. Raise it where steps do more harm than a
+	// long slew, e.g. under databases that order events by time; a
+	// negative value never steps, slewing any offset up to PANICT.
+	StepThreshold time.Duration
+	// Apply, if set, carries out each correction Update returns.
+	Apply func(Correction) error
+
+	started bool
+	state   ClockState
+	t       time.Time // time of the last update acted on
+	offset  float64   // seconds
+	last    float64
+	base    float64 // first offset in ClockFreq
+	freq    float64 // fraction
+	jitter  float64
+	wander  float64
+	poll    int
+	count   int
+}
+
+// Update feeds the offset measured at t, e.g. Estimate.Offset and the
+// time it was taken, into the loop. It returns the correction to make,
+// already passed to Apply, or ok false if the offset is being held
+// back as a possible spike or while the frequency is measured. It
+// fails with code NTP_ERR_PANIC, without changing anything, if offset
+// exceeds PANICT.
+func (d *Discipline) Update(offset time.Duration, t time.Time) (c Correction, ok bool, err error) {
+	if !d.started {
+		d.started = true
+		d.poll = MINPOLL
+		d.freq = clampFreq(d.Frequency * 1e-6)
+		if d.Frequency != 0 {
+			d.state = ClockFreqSet
+		}
+	}
+	abs := offset
+	if abs < 0 {
+		abs = -abs
+	}
+	panicOK := d.AllowPanic && d.t.IsZero()
+	if abs > PANICT && !panicOK {
+		return c, false, errPanic
+	}
+	off := offset.Seconds()
+	mu := t.Sub(d.t).Seconds()
+	tau := float64(int64(1) << d.poll)
+	freq := 0.0
+
+	if step := d.stepThreshold(); step >= 0 && abs > step {
+		switch d.state {
+		case ClockSync:
+			// Possibly a spike; see whether it lasts.
+			d.state = ClockSpike
+			return c, false, nil
+		case ClockFreq:
+			if mu < WATCH.Seconds() {
+				return c, false, nil
+			}
+			freq = (off - d.base) / mu
+		case ClockSpike:
+			if mu < WATCH.Seconds() {
+				return c, false, nil
+			}
+		}
+		c.Step = offset
+		d.count = 0
+		d.poll = MINPOLL
+		d.freq = clampFreq(d.freq + freq)
+		c.Frequency = d.freq * 1e6
+		c.Interval = time.Second << d.poll
+		if d.state == ClockUnset {
+			d.reset(ClockFreq, t, 0)
+		} else {
+			d.reset(ClockSync, t, 0)
+		}
+		return c, true, d.apply(c)
+	}
+
+	etemp := d.jitter * d.jitter
+	dtemp := max(math.Abs(off-d.last), precisionFloor)
+	d.jitter = math.Sqrt(etemp + (dtemp*dtemp-etemp)/avg)
+	switch d.state {
+	case ClockUnset:
+		// Take the first offset as the base for measuring the
+		// frequency; correcting it meanwhile would spoil the
+		// measurement.
+		d.reset(ClockFreq, t, off)
+		d.base = off
+		return c, false, nil
+	case ClockFreqSet:
+		d.reset(ClockSync, t, off)
+	case ClockFreq:
+		if mu < WATCH.Seconds() {
+			return c, false, nil
+		}
+		freq = (off - d.base) / mu
+		fallthrough
+	default:
+		// The FLL's gain rises with the poll interval beyond half the
+		// Allan intercept; the PLL integrates over the shorter of the
+		// update and poll intervals, so oversampling is allowed but
+		// not undersampling.
+		if tau > allan/2 {
+			gain := max(float64(loopFLL-d.poll), avg)
+			freq += (off - d.offset) / (max(mu, allan) * gain)
+		}
+		dt := 4 * loopPLL * tau
+		freq += off * min(mu, tau) / (dt * dt)
+		d.reset(ClockSync, t, off)
+	}
+
+	d.freq = clampFreq(d.freq + freq)
+	etemp = d.wander * d.wander
+	d.wander = math.Sqrt(etemp + (freq*freq-etemp)/avg)
+	d.adaptPoll()
+
+	c.Phase = time.Duration(off / loopPLL * 1e9)
+	c.Frequency = d.freq * 1e6
+	c.Interval = time.Second << d.poll
+	return c, true, d.apply(c)
+}
+
+func (d *Discipline) stepThreshold() time.Duration {
+	if d.StepThreshold == 0 {
+		return STEPT
+	}
+	return d.StepThreshold
+}
+
+func clampFreq(f float64) float64 {
+	return min(max(f, -MAXFREQ), MAXFREQ)
+}
+
+func (d *Discipline) reset(state ClockState, t time.Time, off float64) {
+	d.state = state
+	d.last, d.offset = off, off
+	d.t = t
+}
+
+// adaptPoll lengthens the poll interval while the offset stays within
+// a few times the jitter and shortens it when it does not.
+func (d *Discipline) adaptPoll() {
+	if math.Abs(d.offset) < pollGate*d.jitter {
+		d.count += d.poll
+		if d.count > pollLimit {
+			d.count = pollLimit
+			if d.poll < MAXPOLL {
+				d.count = 0
+				d.poll++
+			}
+		}
+	} else {
+		d.count -= 2 * d.poll
+		if d.count < -pollLimit {
+			d.count = -pollLimit
+			if d.poll > MINPOLL {
+				d.count = 0
+				d.poll--
+			}
+		}
+	}
+}
+
+func (d *Discipline) apply(c Correction) error {
+	if d.Apply == nil {
+		return nil
+	}
+	return d.Apply(c)
+}
+
+// State returns where the loop is, from unset to synchronized.
+func (d *Discipline) State() ClockState { return d.state }
+
+// Poll returns the poll exponent the loop suggests: it rises while the
+// loop is quiet and falls when corrections grow.
+func (d *Discipline) Poll() int {
+	if !d.started {
+		return MINPOLL
+	}
+	return d.poll
+}
+
+// FrequencyPPM returns the current frequency correction in parts per
+// million, e.g. to save in a drift file.
+func (d *Discipline) FrequencyPPM() float64 { return d.freq * 1e6 }
+
+// Jitter returns the RMS of the differences between successive
+// offsets, averaged over a few updates.
+func (d *Discipline) Jitter() time.Duration { return time.Duration(d.jitter * 1e9) }
+
+// Wander returns the RMS of the frequency changes, averaged over a few
+// updates, in parts per million: how stable the local oscillator is.
+func (d *Discipline) Wander() float64 { return d.wander * 1e6 }
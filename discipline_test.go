@@ -0,0 +1,149 @@
+package ntp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+	"github.com/chaitanyav/ntp/ntptest"
+)
+
+var t0 = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestDisciplineMeasuresFrequencyFirst(t *testing.T) {
+	var d ntp.Discipline
+	if _, ok, err := d.Update(10*time.Millisecond, t0); ok || err != nil {
+		t.Fatalf("first update = %v, %v; want it held back", ok, err)
+	}
+	if d.State() != ntp.ClockFreq {
+		t.Errorf("state %v, want %v", d.State(), ntp.ClockFreq)
+	}
+	// Within WATCH the offsets only feed the measurement.
+	if _, ok, _ := d.Update(11*time.Millisecond, t0.Add(ntp.WATCH/2)); ok {
+		t.Error("update within WATCH was acted on")
+	}
+	// After it, the drift of 10ms over WATCH becomes the frequency.
+	c, ok, err := d.Update(20*time.Millisecond, t0.Add(ntp.WATCH))
+	if !ok || err != nil {
+		t.Fatalf("update after WATCH = %v, %v", ok, err)
+	}
+	if want := 10e-3 / ntp.WATCH.Seconds() * 1e6; c.Frequency < want {
+		t.Errorf("frequency %.3fppm, want at least the measured %.3fppm", c.Frequency, want)
+	}
+	if d.State() != ntp.ClockSync {
+		t.Errorf("state %v, want %v", d.State(), ntp.ClockSync)
+	}
+}
+
+func TestDisciplineSlew(t *testing.T) {
+	var applied []ntp.Correction
+	d := ntp.Discipline{Frequency: 10, Apply: func(c ntp.Correction) error {
+		applied = append(applied, c)
+		return nil
+	}}
+	c, ok, err := d.Update(32*time.Millisecond, t0)
+	if !ok || err != nil {
+		t.Fatalf("update with a known frequency = %v, %v", ok, err)
+	}
+	if c.Step != 0 || c.Phase != 2*time.Millisecond {
+		t.Errorf("correction %+v, want a 2ms slew, a sixteenth of the offset", c)
+	}
+	if c.Frequency != 10 {
+		t.Errorf("frequency %vppm, want the given 10ppm", c.Frequency)
+	}
+	if c.Interval != time.Second<<ntp.MINPOLL {
+		t.Errorf("interval %v, want 2^MINPOLL seconds", c.Interval)
+	}
+	if len(applied) != 1 || applied[0] != c {
+		t.Errorf("Apply got %+v, want [%+v]", applied, c)
+	}
+}
+
+func TestDisciplineSpikeThenStep(t *testing.T) {
+	d := ntp.Discipline{Frequency: 1}
+	d.Update(time.Millisecond, t0)
+	if _, ok, _ := d.Update(500*time.Millisecond, t0.Add(time.Minute)); ok {
+		t.Fatal("a sudden 500ms offset was acted on")
+	}
+	if d.State() != ntp.ClockSpike {
+		t.Errorf("state %v, want %v", d.State(), ntp.ClockSpike)
+	}
+	if _, ok, _ := d.Update(500*time.Millisecond, t0.Add(2*time.Minute)); ok {
+		t.Fatal("a 500ms offset was acted on before WATCH")
+	}
+	c, ok, err := d.Update(500*time.Millisecond, t0.Add(time.Minute+ntp.WATCH))
+	if !ok || err != nil {
+		t.Fatalf("update after WATCH = %v, %v", ok, err)
+	}
+	if c.Step != 500*time.Millisecond || c.Phase != 0 {
+		t.Errorf("correction %+v, want a 500ms step", c)
+	}
+	if d.State() != ntp.ClockSync {
+		t.Errorf("state %v, want %v", d.State(), ntp.ClockSync)
+	}
+}
+
+func TestDisciplinePanic(t *testing.T) {
+	var d ntp.Discipline
+	if _, _, err := d.Update(2*ntp.PANICT, t0); ntp.CodeOf(err) != ntp.NTP_ERR_PANIC {
+		t.Errorf("offset beyond PANICT gave %v, want NTP_ERR_PANIC", err)
+	}
+	d = ntp.Discipline{AllowPanic: true, Frequency: 1}
+	c, ok, err := d.Update(2*ntp.PANICT, t0)
+	if !ok || err != nil || c.Step != 2*ntp.PANICT {
+		t.Errorf("first update with AllowPanic = %+v, %v, %v; want a step", c, ok, err)
+	}
+	if _, _, err := d.Update(2*ntp.PANICT, t0.Add(time.Minute)); ntp.CodeOf(err) != ntp.NTP_ERR_PANIC {
+		t.Errorf("second offset beyond PANICT gave %v, want NTP_ERR_PANIC", err)
+	}
+}
+
+func TestDisciplineNeverSteps(t *testing.T) {
+	d := ntp.Discipline{Frequency: 1, StepThreshold: -1}
+	c, ok, err := d.Update(10*time.Second, t0)
+	if !ok || err != nil || c.Step != 0 || c.Phase <= 0 {
+		t.Errorf("10s offset with stepping off = %+v, %v, %v; want a slew", c, ok, err)
+	}
+}
+
+// TestDisciplineLoop closes the loop through a server: its offset
+// stands for the error of a local clock that loses 20ppm, each
+// correction is taken off it, and the discipline should learn the
+// rate and hold the offset down.
+func TestDisciplineLoop(t *testing.T) {
+	const drift = 20e-6
+	offset := 40 * time.Millisecond
+	srv := ntptest.NewServer(ntptest.Config{Offset: offset})
+	defer srv.Close()
+	var c ntp.Client
+	d := ntp.Discipline{Frequency: 1}
+	now := t0
+	var freq float64
+	for i := 0; i < 800; i++ {
+		r, err := c.Get(srv.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		corr, ok, err := d.Update(r.ClockOffset, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		interval := time.Second << ntp.MINPOLL
+		if ok {
+			offset -= corr.Step + corr.Phase
+			freq = corr.Frequency * 1e-6
+			interval = corr.Interval
+		}
+		// The server's clock pulls ahead as the local one runs slow
+		// by the drift less the frequency correction.
+		offset += time.Duration((drift - freq) * float64(interval))
+		now = now.Add(interval)
+		srv.SetConfig(ntptest.Config{Offset: offset})
+	}
+	if offset < -time.Millisecond || offset > time.Millisecond {
+		t.Errorf("offset after 800 updates %v, want it within 1ms", offset)
+	}
+	if ppm := d.FrequencyPPM(); ppm < 17 || ppm > 23 {
+		t.Errorf("frequency %.2fppm, want about the 20ppm drift", ppm)
+	}
+}
@@ -0,0 +1,55 @@
+package ntp
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The environment sets the defaults of every query, so that a program
+// in a container can be pointed at internal time servers without a
+// change to its code:
+//
+//	NTP_SERVERS  servers to query when none is named, comma- or space-separated
+//	NTP_TIMEOUT  timeout of each query, e.g. "2s"
+//	NTP_VERSION  NTP version to send, 1 to 4
+//
+// Options given to a call take precedence over the environment, which
+// takes precedence over the built-in defaults. Unset or empty
+// variables are ignored; malformed ones fail every query with code
+// NTP_ERR_CONFIG, rather than being silently ignored. Package config
+// reads the same variables for the commands.
+const (
+	envServers = "NTP_SERVERS"
+	envTimeout = "NTP_TIMEOUT"
+	envVersion = "NTP_VERSION"
+)
+
+// envOptions applies NTP_TIMEOUT and NTP_VERSION to o.
+func envOptions(o *options) error {
+	if v := os.Getenv(envTimeout); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return &Error{Code: NTP_ERR_CONFIG, Err: fmt.Errorf("ntp: %s must be a positive duration, not %q", envTimeout, v)}
+		}
+		o.timeout = d
+	}
+	if v := os.Getenv(envVersion); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 4 {
+			return &Error{Code: NTP_ERR_CONFIG, Err: fmt.Errorf("ntp: %s must be a version from 1 to 4, not %q", envVersion, v)}
+		}
+		o.version = n
+	}
+	return nil
+}
+
+// DefaultServers returns the servers listed in NTP_SERVERS, which Get,
+// QueryContext and Time query when given an empty server name and a
+// Monitor polls when its Servers are empty. It returns nil if the
+// variable is unset or lists none.
+func DefaultServers() []string {
+	return strings.FieldsFunc(os.Getenv(envServers), func(r rune) bool { return r == ',' || r == ' ' })
+}
@@ -0,0 +1,145 @@
+package ntp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+)
+
+// Code is a stable, machine-readable cause of failure. Codes are
+// meant for programs that consume this package's output without
+// linking against it (logs, CLI output, JSON); their values never
+// change, while the English text of errors may.
+type Code string
+
+const (
+	NTP_ERR_TIMEOUT     Code = "NTP_ERR_TIMEOUT"     // no reply before the deadline
+	NTP_ERR_CANCELED    Code = "NTP_ERR_CANCELED"    // the caller gave up
+	NTP_ERR_RESOLVE     Code = "NTP_ERR_RESOLVE"     // the server name did not resolve
+	NTP_ERR_UNREACHABLE Code = "NTP_ERR_UNREACHABLE" // refused or unreachable (ICMP)
+	NTP_ERR_NETWORK     Code = "NTP_ERR_NETWORK"     // any other socket failure
+	NTP_ERR_MALFORMED   Code = "NTP_ERR_MALFORMED"   // the reply could not be decoded
+	NTP_ERR_MODE        Code = "NTP_ERR_MODE"        // the reply is not from a server
+	NTP_ERR_ORIGIN      Code = "NTP_ERR_ORIGIN"      // the reply does not answer our request
+	NTP_ERR_STALE       Code = "NTP_ERR_STALE"       // the server has not synchronized recently
+	NTP_ERR_UNSYNCED    Code = "NTP_ERR_UNSYNCED"    // the server says its clock is unsynchronized
+	NTP_ERR_DISTANCE    Code = "NTP_ERR_DISTANCE"    // the server's root distance is too large
+	NTP_ERR_NO_MAJORITY Code = "NTP_ERR_NO_MAJORITY" // no majority of servers agrees on the time
+	NTP_ERR_PANIC       Code = "NTP_ERR_PANIC"       // the offset is too large to correct automatically
+	NTP_ERR_KOD         Code = "NTP_ERR_KOD"         // kiss-of-death with another code
+	NTP_ERR_KOD_RATE    Code = "NTP_ERR_KOD_RATE"    // kiss-of-death RATE: poll less often
+	NTP_ERR_KOD_DENY    Code = "NTP_ERR_KOD_DENY"    // kiss-of-death DENY or RSTR: go away
+	NTP_ERR_AUTH        Code = "NTP_ERR_AUTH"        // authentication failed
+	NTP_ERR_PERMISSION  Code = "NTP_ERR_PERMISSION"  // not privileged enough
+	NTP_ERR_UNSUPPORTED Code = "NTP_ERR_UNSUPPORTED" // not available on this platform
+	NTP_ERR_CONFIG      Code = "NTP_ERR_CONFIG"      // the request or client is misconfigured
+	NTP_ERR_CLOSED      Code = "NTP_ERR_CLOSED"      // the client was closed
+	NTP_ERR_UNKNOWN     Code = "NTP_ERR_UNKNOWN"     // none of the above
+)
+
+// Error is the type of every error returned by the client. Use
+// errors.As to get at the Code; Error() is the text of Err alone, so
+// messages read as before.
+type Error struct {
+	Code Code
+	Err  error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+func newError(code Code, text string) error {
+	return &Error{Code: code, Err: errors.New(text)}
+}
+
+// CodeOf returns the Code of err: the one attached by this module, or
+// failing that the closest match for a standard library error. It
+// returns "" for a nil err.
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	var dns *net.DNSError
+	var ne net.Error
+	var addr *net.AddrError
+	var op *net.OpError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return NTP_ERR_CANCELED
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return NTP_ERR_TIMEOUT
+	case errors.As(err, &dns):
+		return NTP_ERR_RESOLVE
+	case errors.As(err, &ne) && ne.Timeout():
+		return NTP_ERR_TIMEOUT
+	case isUnreachable(err):
+		return NTP_ERR_UNREACHABLE
+	case errors.Is(err, os.ErrPermission):
+		return NTP_ERR_PERMISSION
+	case errors.Is(err, errors.ErrUnsupported):
+		return NTP_ERR_UNSUPPORTED
+	case errors.Is(err, net.ErrClosed):
+		return NTP_ERR_CLOSED
+	case errors.As(err, &addr):
+		return NTP_ERR_CONFIG
+	case errors.As(err, &op):
+		return NTP_ERR_NETWORK
+	}
+	return NTP_ERR_UNKNOWN
+}
+
+// withCode returns err as an *Error, classifying it with CodeOf if it
+// has no Code yet.
+func withCode(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return &Error{Code: CodeOf(err), Err: err}
+}
+
+// ErrKissOfDeath matches, with errors.Is, every error for a
+// kiss-of-death reply: one with stratum 0, whose reference ID holds a
+// four-letter kiss code instead of a source. Such a reply carries no
+// time. Use errors.As with a *KissOfDeathError for the kiss code.
+var ErrKissOfDeath = errors.New("ntp: kiss-of-death reply")
+
+// KissOfDeathError is a kiss-of-death reply. The client returns it
+// inside an *Error whose Code is KissCode of the reply.
+type KissOfDeathError struct {
+	// Kiss is the kiss code, e.g. "RATE", "DENY" or "RSTR".
+	Kiss string
+}
+
+func (e *KissOfDeathError) Error() string { return "ntp: kiss-of-death reply " + e.Kiss }
+
+// Is reports whether target is ErrKissOfDeath.
+func (e *KissOfDeathError) Is(target error) bool { return target == ErrKissOfDeath }
+
+// KissOfDeath returns the error for a kiss-of-death reply with the
+// given reference ID.
+func KissOfDeath(refID uint32) error {
+	kiss := []byte{byte(refID >> 24), byte(refID >> 16), byte(refID >> 8), byte(refID)}
+	return &Error{Code: KissCode(refID), Err: &KissOfDeathError{Kiss: strings.TrimRight(string(kiss), "\x00")}}
+}
+
+// KissCode returns the Code for a kiss-of-death reply with the given
+// reference ID.
+func KissCode(refID uint32) Code {
+	switch string([]byte{byte(refID >> 24), byte(refID >> 16), byte(refID >> 8), byte(refID)}) {
+	case "RATE":
+		return NTP_ERR_KOD_RATE
+	case "DENY", "RSTR":
+		return NTP_ERR_KOD_DENY
+	}
+	return NTP_ERR_KOD
+}
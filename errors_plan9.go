@@ -0,0 +1,10 @@
+package ntp
+
+import "strings"
+
+// isUnreachable reports whether err is an ICMP error reported by the
+// socket. Plan 9 reports them only as text.
+func isUnreachable(err error) bool {
+	s := err.Error()
+	return strings.Contains(s, "connection refused") || strings.Contains(s, "unreachable")
+}
@@ -0,0 +1,14 @@
+//go:build !plan9 && !windows
+
+package ntp
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isUnreachable reports whether err is an ICMP error reported by the
+// socket: the port refused the datagram, or no route reached the host.
+func isUnreachable(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH)
+}
@@ -0,0 +1,21 @@
+package ntp
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Winsock error numbers not named by package syscall.
+const (
+	wsaENETUNREACH  = syscall.Errno(10051)
+	wsaECONNREFUSED = syscall.Errno(10061)
+	wsaEHOSTUNREACH = syscall.Errno(10065)
+)
+
+// isUnreachable reports whether err is an ICMP error reported by the
+// socket. Winsock reports a port unreachable on a UDP socket as
+// WSAECONNRESET, not as a refused connection.
+func isUnreachable(err error) bool {
+	return errors.Is(err, syscall.WSAECONNRESET) || errors.Is(err, wsaECONNREFUSED) ||
+		errors.Is(err, wsaEHOSTUNREACH) || errors.Is(err, wsaENETUNREACH)
+}
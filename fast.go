@@ -0,0 +1,93 @@
+package ntp
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// fastTimeout bounds a QueryFast exchange so that a lost reply does not
+// hang the caller.
+const fastTimeout = 5 * time.Second
+
+var errNotServer = newError(NTP_ERR_MODE, "ntp: reply is not a server response")
+
+// QueryFast is a stripped-down SNTP exchange for small devices that only
+// need the clock offset and round-trip delay, e.g. firmware setting its
+// clock once a minute. It sends a bare client request, reads the reply
+// into buf, which must hold at least PACKET_SIZE bytes, and works out
+// the result from the wire timestamps directly. The reply is checked
+// as Get checks it, against a zero Client's limits; nothing is logged
+// or stored in the package variables.
+func QueryFast(server string, buf []byte) (offset, delay time.Duration, err error) {
+	defer func() { err = withCode(err) }()
+	if len(buf) < PACKET_SIZE {
+		return 0, 0, errShortBuffer
+	}
+	conn, err := net.Dial("udp", (*options)(nil).address(server))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	t1, xmit := fastRequest(buf)
+	conn.SetDeadline(t1.Add(fastTimeout))
+	if _, err := conn.Write(buf[:PACKET_SIZE]); err != nil {
+		return 0, 0, err
+	}
+	var t4 time.Time
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, 0, err
+		}
+		t4 = time.Now()
+		// The server echoes our transmit timestamp as its originate
+		// timestamp; anything else is stale or forged.
+		if n >= PACKET_SIZE && NTPTime(binary.BigEndian.Uint64(buf[24:])) == xmit {
+			break
+		}
+	}
+	return fastResult(server, buf, t1, t4)
+}
+
+// fastRequest writes a bare client request into buf and returns the
+// time it was made and the transmit timestamp it carries: 64 random
+// bits, as Client sends by default, so that the request says nothing
+// about the local clock and a reply can only be forged by someone who
+// saw it.
+func fastRequest(buf []byte) (time.Time, NTPTime) {
+	clear(buf[:PACKET_SIZE])
+	buf[0] = 4<<3 | 3 // version 4, client mode
+	xmit := NTPTime(rand.Uint64())
+	binary.BigEndian.PutUint64(buf[40:], uint64(xmit))
+	return time.Now(), xmit
+}
+
+// fastResult checks the reply from server in buf, which holds at least
+// PACKET_SIZE bytes, to the request sent at t1 and received at t4, and
+// works out the offset and delay.
+func fastResult(server string, buf []byte, t1, t4 time.Time) (offset, delay time.Duration, err error) {
+	var p DataPacket
+	p.decode(buf)
+	if err := checkReply(4<<3|3, &p); err != nil {
+		return 0, 0, err
+	}
+	// Differences are taken in NTP format so that no time.Time is built
+	// for the server timestamps.
+	t2, t3 := p.ReceiveTimeStamp, p.TransmitTimeStamp
+	offset = (t2.Sub(NewNTPTime(t1)) + t3.Sub(NewNTPTime(t4))) / 2
+	delay = t4.Sub(t1) - t3.Sub(t2)
+	r := Response{
+		RTT:            delay,
+		RootDelay:      p.RootDelay.Duration(),
+		RootDispersion: p.RootDispersion.Duration(),
+		Precision:      p.DecodePrecision(),
+		ReferenceAge:   referenceAge(&p),
+	}
+	if err := (&Client{}).checkResponse(server, &r); err != nil {
+		return 0, 0, err
+	}
+	return offset, delay, nil
+}
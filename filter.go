@@ -0,0 +1,179 @@
+package ntp
+
+import (
+	"math"
+	"time"
+)
+
+// NSTAGE is the number of samples a Filter keeps, MAXDISP the
+// dispersion of an empty or unusable stage, and SGATE the multiple of
+// the jitter beyond which a jump in offset is taken for a popcorn
+// spike, as in RFC 5905.
+const (
+	NSTAGE  = 8
+	MAXDISP = 16 * time.Second
+	SGATE   = 3
+)
+
+// Sample is one measurement of a server: its clock offset, the round
+// trip delay, the dispersion (error bound) the measurement itself
+// contributes, and the local time it was taken.
+type Sample struct {
+	Offset     time.Duration
+	Delay      time.Duration
+	Dispersion time.Duration
+	Time       time.Time
+}
+
+// SampleOf returns the sample r measured: its offset and round trip,
+// and a dispersion of the server's precision plus PHI times the round
+// trip, taken at r.Received.
+func SampleOf(r *Response) Sample {
+	return Sample{
+		Offset:     r.ClockOffset,
+		Delay:      r.RTT,
+		Dispersion: r.Precision + time.Duration(PHI*float64(r.RTT)),
+		Time:       r.Received,
+	}
+}
+
+// Filter is the RFC 5905 clock filter for one server. It keeps the
+// latest NSTAGE samples and picks the one with the least delay, whose
+// offset is least disturbed by queueing on the path, along with the
+// dispersion and jitter of the set. Feed it every reply from one
+// server, e.g. those of a Burst followed by one per poll. The zero
+// value is ready to use; a Filter is not safe for concurrent use.
+//
+// A best sample whose offset jumps from the last one by more than
+// SGATE times the jitter is a popcorn spike, such as one packet held up
+// on one leg of the path, and is passed over rather than handed on to
+// a clock discipline. Only a jump that comes soon after the last
+// sample is suspect: once twice the interval between the two latest
+// samples, the poll interval in use, has gone by, the new offset is
+// believed.
+type Filter struct {
+	stages [NSTAGE]Sample // newest first
+	n      int
+	update time.Time // when the stages were last aged
+	best   Sample
+	used   Sample // the best sample last returned with ok
+	disp   time.Duration
+	jitter time.Duration
+}
+
+// Add puts s in the filter, dropping the oldest sample if it is full,
+// and returns the best sample. ok is false when that sample is no
+// newer than the one Add last returned with ok, so that a clock
+// discipline does not act on the same measurement twice, or when it is
+// a popcorn spike, in which case the sample last returned stays the
+// best; the new sample may still have changed the dispersion and
+// jitter.
+func (f *Filter) Add(s Sample) (best Sample, ok bool) {
+	if f.n > 0 {
+		if dt := s.Time.Sub(f.update); dt > 0 {
+			age := time.Duration(PHI * float64(dt))
+			for i := 0; i < f.n; i++ {
+				f.stages[i].Dispersion = min(f.stages[i].Dispersion+age, MAXDISP)
+			}
+		}
+	}
+	prevJitter := f.jitter
+	f.update = s.Time
+	copy(f.stages[1:], f.stages[:NSTAGE-1])
+	f.stages[0] = s
+	f.n = min(f.n+1, NSTAGE)
+
+	// Order the stages by delay, with any already at MAXDISP last.
+	var order [NSTAGE]int
+	for i := range order {
+		order[i] = i
+	}
+	for i := 1; i < f.n; i++ {
+		for j := i; j > 0 && f.distance(order[j]) < f.distance(order[j-1]); j-- {
+			order[j], order[j-1] = order[j-1], order[j]
+		}
+	}
+
+	// The set's dispersion weighs each stage by half the one before
+	// it in that order, counting empty stages as MAXDISP; its jitter
+	// is the RMS difference of the usable offsets from the best one.
+	f.best = f.stages[order[0]]
+	var disp, sum float64
+	m := 0
+	for i := NSTAGE - 1; i >= 0; i-- {
+		d := float64(MAXDISP)
+		if i < f.n {
+			st := f.stages[order[i]]
+			d = float64(st.Dispersion)
+			if st.Dispersion < MAXDISP {
+				diff := float64(st.Offset - f.best.Offset)
+				sum += diff * diff
+				m++
+			}
+		}
+		disp = (disp + d) / 2
+	}
+	f.disp = time.Duration(disp)
+	f.jitter = 0
+	if m > 1 {
+		f.jitter = time.Duration(math.Sqrt(sum / float64(m-1)))
+	}
+
+	if f.used.Time.IsZero() {
+		f.used = f.best
+		return f.best, true
+	}
+	if !f.best.Time.After(f.used.Time) {
+		return f.best, false
+	}
+	jump := f.best.Offset - f.used.Offset
+	if jump < 0 {
+		jump = -jump
+	}
+	if prevJitter > 0 && jump > SGATE*prevJitter && f.best.Time.Sub(f.used.Time) < 2*s.Time.Sub(f.stages[1].Time) {
+		f.best = f.used
+		return f.best, false
+	}
+	f.used = f.best
+	return f.best, true
+}
+
+// distance is what Add orders stage i by.
+func (f *Filter) distance(i int) time.Duration {
+	s := f.stages[i]
+	if s.Dispersion < MAXDISP {
+		return s.Delay
+	}
+	return MAXDISP + s.Dispersion
+}
+
+// Best returns the sample Add last picked, or the zero Sample if it has
+// not been called.
+func (f *Filter) Best() Sample { return f.best }
+
+// Dispersion returns the dispersion of the set as of the latest
+// sample; empty stages count as MAXDISP, so it starts near MAXDISP and
+// falls as samples arrive.
+func (f *Filter) Dispersion() time.Duration {
+	if f.n == 0 {
+		return MAXDISP
+	}
+	return f.disp
+}
+
+// Jitter returns the RMS difference between the offsets of the usable
+// samples and that of the best one: how much the offset is seen to
+// wander from one measurement to the next.
+func (f *Filter) Jitter() time.Duration { return f.jitter }
+
+// Len returns the number of samples held, at most NSTAGE.
+func (f *Filter) Len() int { return f.n }
+
+// Samples returns the samples held, newest first.
+func (f *Filter) Samples() []Sample {
+	return append([]Sample(nil), f.stages[:f.n]...)
+}
+
+// Reset empties the filter, e.g. after the clock is stepped and the old
+// offsets no longer mean anything.
+func (f *Filter) Reset() { *f = Filter{} }
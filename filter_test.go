@@ -0,0 +1,107 @@
+package ntp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+	"github.com/chaitanyav/ntp/ntptest"
+)
+
+func TestFilterPicksLeastDelay(t *testing.T) {
+	srv := ntptest.NewServer(ntptest.Config{Offset: 100 * time.Millisecond})
+	defer srv.Close()
+	var c ntp.Client
+	var f ntp.Filter
+	// Delay only holds up the reply, so each millisecond of it takes
+	// half a millisecond off the offset the client measures.
+	for _, delay := range []time.Duration{30 * time.Millisecond, time.Millisecond, 15 * time.Millisecond} {
+		srv.SetConfig(ntptest.Config{Offset: 100 * time.Millisecond, Delay: delay})
+		r, err := c.Get(srv.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Add(ntp.SampleOf(r))
+	}
+	best := f.Best()
+	if best.Delay > 10*time.Millisecond {
+		t.Errorf("best sample has delay %v, want the 1ms one", best.Delay)
+	}
+	if d := best.Offset - 100*time.Millisecond; d < -5*time.Millisecond || d > 5*time.Millisecond {
+		t.Errorf("best sample has offset %v, want about 100ms", best.Offset)
+	}
+	if f.Len() != 3 {
+		t.Errorf("Len = %d, want 3", f.Len())
+	}
+	if f.Jitter() <= 0 {
+		t.Errorf("Jitter = %v, want it above zero for offsets that differ", f.Jitter())
+	}
+}
+
+func TestFilterStages(t *testing.T) {
+	var f ntp.Filter
+	if f.Dispersion() != ntp.MAXDISP {
+		t.Errorf("empty filter has dispersion %v, want MAXDISP", f.Dispersion())
+	}
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < ntp.NSTAGE+2; i++ {
+		f.Add(ntp.Sample{Delay: 10 * time.Millisecond, Dispersion: time.Millisecond, Time: t0.Add(time.Duration(i) * time.Minute)})
+	}
+	if f.Len() != ntp.NSTAGE {
+		t.Errorf("Len = %d, want NSTAGE", f.Len())
+	}
+	if d := f.Dispersion(); d >= 10*time.Millisecond {
+		t.Errorf("full filter has dispersion %v, want it well below 10ms", d)
+	}
+	ss := f.Samples()
+	if !ss[0].Time.After(ss[len(ss)-1].Time) {
+		t.Errorf("Samples are not newest first: %v before %v", ss[0].Time, ss[len(ss)-1].Time)
+	}
+	f.Reset()
+	if f.Len() != 0 {
+		t.Errorf("Len after Reset = %d, want 0", f.Len())
+	}
+}
+
+func TestFilterSameSampleOnce(t *testing.T) {
+	var f ntp.Filter
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, ok := f.Add(ntp.Sample{Offset: time.Millisecond, Delay: 5 * time.Millisecond, Time: t0}); !ok {
+		t.Fatal("first sample not ok")
+	}
+	// A slower sample leaves the first one best, which has been used.
+	best, ok := f.Add(ntp.Sample{Offset: 2 * time.Millisecond, Delay: 50 * time.Millisecond, Time: t0.Add(time.Minute)})
+	if ok {
+		t.Error("the same best sample was returned twice with ok")
+	}
+	if !best.Time.Equal(t0) {
+		t.Errorf("best sample is from %v, want the first one", best.Time)
+	}
+}
+
+func TestFilterPopcornSpike(t *testing.T) {
+	var f ntp.Filter
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(i int) time.Time { return t0.Add(time.Duration(i) * 64 * time.Second) }
+	for i, off := range []time.Duration{0, time.Millisecond, 0, time.Millisecond} {
+		f.Add(ntp.Sample{Offset: off, Delay: 10 * time.Millisecond, Time: at(i)})
+	}
+	used := f.Best()
+
+	// A fast reply 100ms off, right after the last, is taken for a
+	// spike and passed over.
+	best, ok := f.Add(ntp.Sample{Offset: 100 * time.Millisecond, Delay: 5 * time.Millisecond, Time: at(4)})
+	if ok {
+		t.Errorf("spike %v was passed on", best.Offset)
+	}
+	if best != used {
+		t.Errorf("best after a spike = %+v, want %+v", best, used)
+	}
+
+	// Once twice the poll interval has gone by since the sample last
+	// used, the new offset is believed.
+	best, ok = f.Add(ntp.Sample{Offset: 100 * time.Millisecond, Delay: 4 * time.Millisecond, Time: at(5)})
+	if !ok || best.Offset != 100*time.Millisecond {
+		t.Errorf("a lasting offset of 100ms gave %v, %v; want it believed", best.Offset, ok)
+	}
+}
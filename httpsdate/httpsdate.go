@@ -0,0 +1,188 @@
+// Package httpsdate estimates the clock offset from the Date header of
+// HTTPS responses, for networks that block NTP entirely. The result is
+// coarse, since Date has a resolution of one second, but it is enough
+// to set a badly wrong clock (a device without an RTC, say) close
+// enough to validate certificates and then hand over to NTP.
+package httpsdate
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+)
+
+// Config controls Estimate. Zero fields take the defaults noted.
+type Config struct {
+	// URLs are fetched with HEAD requests; use several hosts under
+	// different operators so that one wrong server is outvoted.
+	URLs []string
+	// Timeout bounds each host (default 10s).
+	Timeout time.Duration
+	// IgnoreExpiry verifies certificate chains and host names but not
+	// their validity periods, which a wrong clock would otherwise make
+	// fail: exactly the situation this package is for. Leave it off
+	// once the clock is known to be roughly right.
+	IgnoreExpiry bool
+	// Client, if set, is used instead of one built from the fields
+	// above.
+	Client *http.Client
+}
+
+// Sample is the measurement from one URL.
+type Sample struct {
+	URL  string
+	Date time.Time
+	// Offset is how far the server's clock is ahead of the local one,
+	// and RTT the round trip of the request it came from.
+	Offset time.Duration
+	RTT    time.Duration
+	// Uncertainty bounds the error of Offset: half a second for the
+	// truncation of Date plus half the round trip.
+	Uncertainty time.Duration
+}
+
+// Result combines the samples of every URL that answered.
+type Result struct {
+	// Sample is the median by offset of Samples.
+	Sample
+	Samples []Sample
+}
+
+var (
+	errNoURLs  = &ntp.Error{Code: ntp.NTP_ERR_CONFIG, Err: errors.New("httpsdate: no URLs")}
+	errNoDate  = &ntp.Error{Code: ntp.NTP_ERR_MALFORMED, Err: errors.New("httpsdate: response has no valid Date header")}
+	errNoChain = &ntp.Error{Code: ntp.NTP_ERR_AUTH, Err: errors.New("httpsdate: server sent no certificate")}
+)
+
+// Estimate queries every URL concurrently and combines the answers. It
+// fails only if none of them answers, with the first URL's error.
+func Estimate(ctx context.Context, cfg Config) (*Result, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, errNoURLs
+	}
+	client := cfg.Client
+	if client == nil {
+		client = newClient(cfg)
+	}
+	samples := make([]*Sample, len(cfg.URLs))
+	errs := make([]error, len(cfg.URLs))
+	var wg sync.WaitGroup
+	for i, url := range cfg.URLs {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			samples[i], errs[i] = Query(ctx, client, url)
+		}(i, url)
+	}
+	wg.Wait()
+
+	r := &Result{}
+	for _, s := range samples {
+		if s != nil {
+			r.Samples = append(r.Samples, *s)
+		}
+	}
+	if len(r.Samples) == 0 {
+		return nil, errs[0]
+	}
+	sorted := append([]Sample(nil), r.Samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+	r.Sample = sorted[len(sorted)/2]
+	return r, nil
+}
+
+// Query measures the offset from one URL with client. The first
+// request sets up the connection; the second, on the same connection,
+// is the one measured, so the TLS handshake does not count towards the
+// round trip.
+func Query(ctx context.Context, client *http.Client, url string) (*Sample, error) {
+	if _, _, err := head(ctx, client, url); err != nil {
+		return nil, err
+	}
+	t1 := time.Now()
+	date, t4, err := head(ctx, client, url)
+	if err != nil {
+		return nil, err
+	}
+	rtt := t4.Sub(t1)
+	// The server wrote Date at some point in the round trip and
+	// truncated it to the second; assume the middle of both.
+	mid := t1.Add(rtt / 2)
+	return &Sample{
+		URL:         url,
+		Date:        date,
+		Offset:      date.Add(500 * time.Millisecond).Sub(mid),
+		RTT:         rtt,
+		Uncertainty: 500*time.Millisecond + rtt/2,
+	}, nil
+}
+
+func head(ctx context.Context, client *http.Client, url string) (time.Time, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return time.Time{}, time.Time{}, &ntp.Error{Code: ntp.NTP_ERR_CONFIG, Err: err}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Time{}, time.Time{}, &ntp.Error{Code: ntp.CodeOf(err), Err: err}
+	}
+	t4 := time.Now()
+	resp.Body.Close()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return time.Time{}, time.Time{}, errNoDate
+	}
+	return date, t4, nil
+}
+
+func newClient(cfg Config) *http.Client {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = http.ProxyFromEnvironment
+	if cfg.IgnoreExpiry {
+		tr.TLSClientConfig = &tls.Config{
+			// The chain is verified below instead, at a time every
+			// certificate in it considers valid.
+			InsecureSkipVerify: true,
+			VerifyConnection:   verifyIgnoringExpiry,
+		}
+	}
+	return &http.Client{Transport: tr, Timeout: timeout}
+}
+
+// verifyIgnoringExpiry verifies the peer's chain and name against the
+// system roots as of the latest NotBefore in the chain, so that only
+// the validity period goes unchecked.
+func verifyIgnoringExpiry(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errNoChain
+	}
+	leaf := cs.PeerCertificates[0]
+	at := leaf.NotBefore
+	inter := x509.NewCertPool()
+	for _, c := range cs.PeerCertificates[1:] {
+		inter.AddCert(c)
+		if c.NotBefore.After(at) {
+			at = c.NotBefore
+		}
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Intermediates: inter,
+		CurrentTime:   at.Add(time.Second),
+	})
+	if err != nil {
+		return &ntp.Error{Code: ntp.NTP_ERR_AUTH, Err: err}
+	}
+	return nil
+}
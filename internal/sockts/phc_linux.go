@@ -0,0 +1,70 @@
+//go:build !tinygo
+
+package sockts
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// PHC is the PTP hardware clock of a NIC. Hardware timestamps are
+// taken on this clock, which is generally not synchronized with the
+// system clock, so they have to be mapped across before use.
+type PHC struct {
+	f       *os.File
+	clockid uintptr
+}
+
+// OpenPHC opens the hardware clock behind iface, as listed in sysfs.
+func OpenPHC(iface string) (*PHC, error) {
+	matches, _ := filepath.Glob(filepath.Join("/sys/class/net", iface, "device/ptp/ptp*"))
+	if len(matches) == 0 {
+		return nil, errors.New("ntp: no PTP hardware clock for " + iface)
+	}
+	f, err := os.Open(filepath.Join("/dev", filepath.Base(matches[0])))
+	if err != nil {
+		return nil, err
+	}
+	// FD_TO_CLOCKID from the kernel's posix-clock documentation.
+	return &PHC{f: f, clockid: uintptr((^int(f.Fd()))<<3 | 3)}, nil
+}
+
+func (p *PHC) now() (time.Time, error) {
+	var ts syscall.Timespec
+	_, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, p.clockid, uintptr(unsafe.Pointer(&ts)), 0)
+	if errno != 0 {
+		return time.Time{}, errno
+	}
+	return time.Unix(ts.Unix()), nil
+}
+
+// ToSystem converts a PHC reading to system time. The clock offset is
+// sampled by bracketing a PHC read between two system clock reads; the
+// tightest of a few brackets is used.
+func (p *PHC) ToSystem(t time.Time) (time.Time, error) {
+	var best time.Duration
+	var offset time.Duration
+	for i := 0; i < 3; i++ {
+		before := time.Now()
+		phc, err := p.now()
+		if err != nil {
+			return time.Time{}, err
+		}
+		after := time.Now()
+		width := after.Sub(before)
+		if i == 0 || width < best {
+			best = width
+			offset = before.Add(width / 2).Sub(phc)
+		}
+	}
+	return t.Add(offset), nil
+}
+
+// Close closes the clock device.
+func (p *PHC) Close() error {
+	return p.f.Close()
+}
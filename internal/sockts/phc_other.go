@@ -0,0 +1,20 @@
+//go:build !linux || tinygo
+
+package sockts
+
+import "time"
+
+// PHC is a NIC hardware clock; only Linux exposes one.
+type PHC struct{}
+
+func OpenPHC(iface string) (*PHC, error) {
+	return nil, ErrUnsupported
+}
+
+func (p *PHC) ToSystem(t time.Time) (time.Time, error) {
+	return t, nil
+}
+
+func (p *PHC) Close() error {
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build (linux || windows || darwin || dragonfly || freebsd || netbsd || openbsd || solaris) && !tinygo
+
+package sockts
+
+import (
+	"net"
+	"time"
+)
+
+// ReadRX reads a datagram along with the timestamp control message the
+// kernel attaches once receive timestamps are enabled. A raw hardware
+// timestamp is preferred and converted to system time through clk; if
+// no usable message is present the userspace clock is used instead.
+func ReadRX(conn *net.UDPConn, buf []byte, clk *PHC) (int, time.Time, error) {
+	var oob [128]byte
+	n, oobn, _, _, err := conn.ReadMsgUDP(buf, oob[:])
+	now := time.Now()
+	if err != nil {
+		return n, now, err
+	}
+	if t, ok := parse(oob[:oobn], clk); ok {
+		return n, t, nil
+	}
+	return n, now, nil
+}
@@ -0,0 +1,17 @@
+// Package sockts reads kernel timestamps for UDP sockets.
+//
+// Every platform takes timestamps differently and hands them back in
+// its own control-message layout: SO_TIMESTAMPNS and SO_TIMESTAMPING on
+// Linux, SO_TIMESTAMP with a timeval on the BSDs, Darwin and Solaris,
+// and SIO_TIMESTAMPING with a performance-counter value on Windows.
+// This package hides those differences behind one set of calls. Where a
+// platform lacks a feature the call returns ErrUnsupported and reads
+// fall back to the userspace clock, so callers only need to treat
+// ErrUnsupported as "not available here" rather than as a failure.
+package sockts
+
+import "errors"
+
+// ErrUnsupported is returned when the platform or socket cannot provide
+// the requested kind of timestamp.
+var ErrUnsupported = errors.New("ntp: kernel timestamps not supported")
@@ -0,0 +1,179 @@
+//go:build !tinygo
+
+package sockts
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// SO_TIMESTAMPING flags from <linux/net_tstamp.h>.
+const (
+	sofTimestampingTxHardware  = 1 << 0
+	sofTimestampingTxSoftware  = 1 << 1
+	sofTimestampingRxHardware  = 1 << 2
+	sofTimestampingRxSoftware  = 1 << 3
+	sofTimestampingSoftware    = 1 << 4
+	sofTimestampingRawHardware = 1 << 6
+	sofTimestampingOptTsonly   = 1 << 11
+)
+
+// SIOCSHWTSTAMP and its hwtstamp_config values.
+const (
+	siocSHWTSTAMP     = 0x89b0
+	hwtstampTxOn      = 1
+	hwtstampFilterAll = 1
+)
+
+func setsockopt(conn *net.UDPConn, level, opt, value int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), level, opt, value)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// Enable turns on kernel timestamps for conn. SO_TIMESTAMPING is used
+// when hardware or transmit timestamps are wanted, since SO_TIMESTAMPNS
+// only covers received packets.
+func Enable(conn *net.UDPConn, hw, rx, tx bool) error {
+	if hw || tx {
+		return setTimestamping(conn, hw, rx, tx)
+	}
+	if rx {
+		return setsockopt(conn, syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1)
+	}
+	return nil
+}
+
+// EnableHardware switches the NIC behind iface to timestamp every
+// packet.
+func EnableHardware(conn *net.UDPConn, iface string) error {
+	if len(iface) >= syscall.IFNAMSIZ {
+		return errors.New("ntp: interface name too long: " + iface)
+	}
+	// cfg is heap allocated and kept alive across the ioctl because
+	// the kernel reaches it through a uintptr in ifr.
+	cfg := &struct {
+		flags, txType, rxFilter int32
+	}{0, hwtstampTxOn, hwtstampFilterAll}
+	var ifr struct {
+		name [syscall.IFNAMSIZ]byte
+		data uintptr
+		_    [16]byte
+	}
+	copy(ifr.name[:], iface)
+	ifr.data = uintptr(unsafe.Pointer(cfg))
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, siocSHWTSTAMP, uintptr(unsafe.Pointer(&ifr)))
+		if errno != 0 {
+			serr = errno
+		}
+	})
+	runtime.KeepAlive(cfg)
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// setTimestamping configures SO_TIMESTAMPING. Software timestamps are
+// always requested alongside hardware ones, so packets that miss the
+// hardware path still get a kernel time.
+func setTimestamping(conn *net.UDPConn, hw, rx, tx bool) error {
+	flags := sofTimestampingSoftware
+	if rx {
+		flags |= sofTimestampingRxSoftware
+	}
+	if tx {
+		flags |= sofTimestampingTxSoftware | sofTimestampingOptTsonly
+	}
+	if hw {
+		flags |= sofTimestampingRawHardware
+		if rx {
+			flags |= sofTimestampingRxHardware
+		}
+		if tx {
+			flags |= sofTimestampingTxHardware
+		}
+	}
+	return setsockopt(conn, syscall.SOL_SOCKET, syscall.SO_TIMESTAMPING, flags)
+}
+
+// ReadTX collects the transmit timestamp of the last packet
+// sent on conn from the socket error queue. It does not block: it is
+// called after the reply has arrived, by which time the kernel has long
+// queued the timestamp. Older timestamps (from requests whose replies
+// were lost) are drained and the newest is returned.
+func ReadTX(conn *net.UDPConn, clk *PHC) (time.Time, bool) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return time.Time{}, false
+	}
+	var t time.Time
+	var found bool
+	raw.Control(func(fd uintptr) {
+		var oob [128]byte
+		for {
+			_, oobn, _, _, err := syscall.Recvmsg(int(fd), nil, oob[:], syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+			if err != nil {
+				return
+			}
+			if ts, ok := parse(oob[:oobn], clk); ok {
+				t, found = ts, true
+			}
+		}
+	})
+	return t, found
+}
+
+// parse extracts the timestamp from the control messages of a received
+// packet or of an error-queue entry; both use the same SCM_TIMESTAMPNS /
+// SCM_TIMESTAMPING layout.
+func parse(oob []byte, clk *PHC) (time.Time, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+	const tsSize = int(unsafe.Sizeof(syscall.Timespec{}))
+	for _, m := range msgs {
+		if m.Header.Level != syscall.SOL_SOCKET {
+			continue
+		}
+		switch {
+		case m.Header.Type == syscall.SCM_TIMESTAMPNS && len(m.Data) >= tsSize:
+			ts := (*syscall.Timespec)(unsafe.Pointer(&m.Data[0]))
+			return time.Unix(ts.Unix()), true
+		case m.Header.Type == syscall.SO_TIMESTAMPING && len(m.Data) >= 3*tsSize:
+			// struct scm_timestamping: software, deprecated, raw
+			// hardware.
+			ts := (*[3]syscall.Timespec)(unsafe.Pointer(&m.Data[0]))
+			if hw := ts[2]; (hw.Sec != 0 || hw.Nsec != 0) && clk != nil {
+				if t, err := clk.ToSystem(time.Unix(hw.Unix())); err == nil {
+					return t, true
+				}
+			}
+			if sw := ts[0]; sw.Sec != 0 || sw.Nsec != 0 {
+				return time.Unix(sw.Unix()), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
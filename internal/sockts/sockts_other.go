@@ -0,0 +1,28 @@
+//go:build tinygo || (!linux && !windows && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !solaris)
+
+package sockts
+
+import (
+	"net"
+	"time"
+)
+
+func Enable(conn *net.UDPConn, hw, rx, tx bool) error {
+	if hw || rx || tx {
+		return ErrUnsupported
+	}
+	return nil
+}
+
+func EnableHardware(conn *net.UDPConn, iface string) error {
+	return ErrUnsupported
+}
+
+func ReadTX(conn *net.UDPConn, clk *PHC) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func ReadRX(conn *net.UDPConn, buf []byte, clk *PHC) (int, time.Time, error) {
+	n, err := conn.Read(buf)
+	return n, time.Now(), err
+}
@@ -0,0 +1,62 @@
+//go:build (darwin || dragonfly || freebsd || netbsd || openbsd || solaris) && !tinygo
+
+package sockts
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Enable turns on SO_TIMESTAMP, which stamps received packets with a
+// microsecond timeval. These kernels have no transmit or hardware
+// timestamps through this interface, so asking for either leaves
+// receive timestamps on and reports ErrUnsupported.
+func Enable(conn *net.UDPConn, hw, rx, tx bool) error {
+	if rx {
+		raw, err := conn.SyscallConn()
+		if err != nil {
+			return err
+		}
+		var serr error
+		err = raw.Control(func(fd uintptr) {
+			serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TIMESTAMP, 1)
+		})
+		if err != nil {
+			return err
+		}
+		if serr != nil {
+			return serr
+		}
+	}
+	if hw || tx {
+		return ErrUnsupported
+	}
+	return nil
+}
+
+func EnableHardware(conn *net.UDPConn, iface string) error {
+	return ErrUnsupported
+}
+
+func ReadTX(conn *net.UDPConn, clk *PHC) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// parse extracts the SCM_TIMESTAMP timeval from the control messages of
+// a received packet.
+func parse(oob []byte, clk *PHC) (time.Time, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+	const tvSize = int(unsafe.Sizeof(syscall.Timeval{}))
+	for _, m := range msgs {
+		if m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SCM_TIMESTAMP && len(m.Data) >= tvSize {
+			tv := (*syscall.Timeval)(unsafe.Pointer(&m.Data[0]))
+			return time.Unix(tv.Unix()), true
+		}
+	}
+	return time.Time{}, false
+}
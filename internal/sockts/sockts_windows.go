@@ -0,0 +1,127 @@
+//go:build !tinygo
+
+package sockts
+
+import (
+	"net"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// SIO_TIMESTAMPING and its TIMESTAMPING_CONFIG flags from <mstcpip.h>.
+// Windows 10 1903 and later support it; the timestamp arrives as an
+// SO_TIMESTAMP control message holding a QueryPerformanceCounter value.
+const (
+	sioTimestamping    = 0x980000eb // _WSAIOW(IOC_VENDOR, 235)
+	timestampingFlagRx = 0x1
+	solSocket          = 0xffff
+	soTimestamp        = 0x300a
+
+	wsaeInval      syscall.Errno = 10022
+	wsaeOpNotSupp  syscall.Errno = 10045
+	wsaeNoProtoOpt syscall.Errno = 10042
+)
+
+var (
+	kernel32    = syscall.NewLazyDLL("kernel32.dll")
+	procQPC     = kernel32.NewProc("QueryPerformanceCounter")
+	procQPF     = kernel32.NewProc("QueryPerformanceFrequency")
+	qpcFreqOnce sync.Once
+	qpcFreq     int64
+)
+
+// Enable asks the stack to timestamp received packets. Only receive
+// timestamps are taken this way; transmit and hardware requests leave
+// them on and report ErrUnsupported.
+func Enable(conn *net.UDPConn, hw, rx, tx bool) error {
+	if rx {
+		raw, err := conn.SyscallConn()
+		if err != nil {
+			return err
+		}
+		cfg := struct {
+			flags              uint32
+			txTimestampsBuffer uint16
+			_                  uint16
+		}{flags: timestampingFlagRx}
+		var serr error
+		err = raw.Control(func(fd uintptr) {
+			var n uint32
+			serr = syscall.WSAIoctl(syscall.Handle(fd), sioTimestamping,
+				(*byte)(unsafe.Pointer(&cfg)), uint32(unsafe.Sizeof(cfg)), nil, 0, &n, nil, 0)
+		})
+		if err != nil {
+			return err
+		}
+		switch serr {
+		case nil:
+		case wsaeInval, wsaeOpNotSupp, wsaeNoProtoOpt:
+			// Older Windows releases do not know the ioctl.
+			return ErrUnsupported
+		default:
+			return serr
+		}
+	}
+	if hw || tx {
+		return ErrUnsupported
+	}
+	return nil
+}
+
+func EnableHardware(conn *net.UDPConn, iface string) error {
+	return ErrUnsupported
+}
+
+func ReadTX(conn *net.UDPConn, clk *PHC) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// parse walks the WSACMSGHDR list for the SO_TIMESTAMP message and maps
+// its performance-counter value to system time. Headers and data are
+// both aligned to the pointer size.
+func parse(oob []byte, clk *PHC) (time.Time, bool) {
+	const align = unsafe.Sizeof(uintptr(0))
+	type cmsghdr struct {
+		len   uintptr
+		level int32
+		typ   int32
+	}
+	const hdrLen = int((unsafe.Sizeof(cmsghdr{}) + align - 1) &^ (align - 1))
+	for len(oob) >= hdrLen {
+		h := (*cmsghdr)(unsafe.Pointer(&oob[0]))
+		if int(h.len) < hdrLen || int(h.len) > len(oob) {
+			return time.Time{}, false
+		}
+		if h.level == solSocket && h.typ == soTimestamp && int(h.len) >= hdrLen+8 {
+			ticks := *(*int64)(unsafe.Pointer(&oob[hdrLen]))
+			return qpcToSystem(ticks), true
+		}
+		next := int((h.len + align - 1) &^ (align - 1))
+		if next > len(oob) {
+			break
+		}
+		oob = oob[next:]
+	}
+	return time.Time{}, false
+}
+
+// qpcToSystem converts a performance-counter reading to system time by
+// sampling the counter against the system clock now and working back.
+func qpcToSystem(ticks int64) time.Time {
+	qpcFreqOnce.Do(func() {
+		procQPF.Call(uintptr(unsafe.Pointer(&qpcFreq)))
+	})
+	var now int64
+	before := time.Now()
+	procQPC.Call(uintptr(unsafe.Pointer(&now)))
+	after := time.Now()
+	mid := before.Add(after.Sub(before) / 2)
+	if qpcFreq <= 0 {
+		return mid
+	}
+	d := now - ticks
+	age := time.Duration(d/qpcFreq)*time.Second + time.Duration(d%qpcFreq*int64(time.Second)/qpcFreq)
+	return mid.Add(-age)
+}
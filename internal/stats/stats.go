@@ -0,0 +1,177 @@
+// Package stats provides counters and latency histograms that can be
+// updated from many goroutines at once without a shared lock. Counters
+// are split across cache-line padded shards picked at random per
+// update, so concurrent writers rarely touch the same line; readers sum
+// the shards.
+package stats
+
+import (
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+const shards = 16
+
+type shard struct {
+	v atomic.Uint64
+	_ [56]byte
+}
+
+// Counter is a sharded monotonic counter. The zero value is ready to
+// use.
+type Counter struct {
+	s [shards]shard
+}
+
+func pick() int {
+	return int(rand.Uint32() % shards)
+}
+
+// Add adds n to the counter.
+func (c *Counter) Add(n uint64) {
+	c.s[pick()].v.Add(n)
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Load returns the current total.
+func (c *Counter) Load() uint64 {
+	var sum uint64
+	for i := range c.s {
+		sum += c.s[i].v.Load()
+	}
+	return sum
+}
+
+// Gauge holds the most recently stored float64. The zero value reads
+// as 0.
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Load() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// Sub-buckets per power of two in a Histogram; gives a relative error
+// of about 6%.
+const subBits = 3
+
+const buckets = 64 << subBits
+
+// Histogram records durations into log-linear buckets with atomic
+// counts. Quantiles are exact to within one bucket. The zero value is
+// ready to use.
+type Histogram struct {
+	counts [buckets]atomic.Uint64
+	n      Counter
+	sum    Counter
+	max    atomic.Int64
+	// min1 is the minimum plus one, so that zero can mean "no
+	// observations yet".
+	min1 atomic.Int64
+}
+
+func bucketOf(v uint64) int {
+	if v < 1<<subBits {
+		return int(v)
+	}
+	exp := bits.Len64(v) - 1 - subBits
+	return (exp+1)<<subBits | int(v>>uint(exp))&(1<<subBits-1)
+}
+
+// bucketMid is the midpoint of the values that map to bucket i.
+func bucketMid(i int) uint64 {
+	if i < 1<<subBits {
+		return uint64(i)
+	}
+	exp := uint(i>>subBits - 1)
+	lo := (uint64(1)<<subBits | uint64(i&(1<<subBits-1))) << exp
+	return lo + (uint64(1)<<exp)/2
+}
+
+// Observe records one duration; negative durations count as zero.
+func (h *Histogram) Observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[bucketOf(uint64(d))].Add(1)
+	h.n.Inc()
+	h.sum.Add(uint64(d))
+	for {
+		old := h.max.Load()
+		if int64(d) <= old || h.max.CompareAndSwap(old, int64(d)) {
+			break
+		}
+	}
+	for {
+		old := h.min1.Load()
+		if old != 0 && int64(d)+1 >= old || h.min1.CompareAndSwap(old, int64(d)+1) {
+			break
+		}
+	}
+}
+
+// Count is the number of observations.
+func (h *Histogram) Count() uint64 {
+	return h.n.Load()
+}
+
+// Mean is the average observation, or 0 if there are none.
+func (h *Histogram) Mean() time.Duration {
+	n := h.n.Load()
+	if n == 0 {
+		return 0
+	}
+	return time.Duration(h.sum.Load() / n)
+}
+
+func (h *Histogram) Min() time.Duration {
+	if m := h.min1.Load(); m > 0 {
+		return time.Duration(m - 1)
+	}
+	return 0
+}
+
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(h.max.Load())
+}
+
+// Quantile returns the q-quantile (0 <= q <= 1) of the observations.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	var snap [buckets]uint64
+	var total uint64
+	for i := range h.counts {
+		snap[i] = h.counts[i].Load()
+		total += snap[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(total-1))
+	var seen uint64
+	for i, c := range snap {
+		seen += c
+		if seen > rank {
+			d := time.Duration(bucketMid(i))
+			if max := h.Max(); d > max {
+				d = max
+			}
+			if min := h.Min(); d < min {
+				d = min
+			}
+			return d
+		}
+	}
+	return h.Max()
+}
@@ -0,0 +1,75 @@
+package ntp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// How long a Client leaves a server alone after a kiss-of-death. RFC
+// 5905 has clients stop for good on DENY and RSTR; a day stands in for
+// that in programs that outlive it. RATE holds start at the default
+// minimum poll interval and double with each further RATE, up to the
+// maximum poll interval.
+const (
+	kissDenyHold    = 24 * time.Hour
+	kissRateHold    = 1 << 6 * time.Second
+	kissRateMaxHold = 1 << 17 * time.Second
+)
+
+// kissState is what a Client remembers about a server that sent a
+// kiss-of-death.
+type kissState struct {
+	kiss  *KissOfDeathError
+	code  Code
+	hold  time.Duration
+	until time.Time
+}
+
+// backingOff returns an error if server told c to go away and the hold
+// has not yet expired. The error carries the original kiss's Code and
+// matches ErrKissOfDeath.
+func (c *Client) backingOff(server string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.kisses[server]
+	if !ok || !c.now().Before(st.until) {
+		return nil
+	}
+	return &Error{Code: st.code, Err: fmt.Errorf("ntp: not querying %s until %s after its kiss-of-death: %w",
+		server, st.until.Format(time.RFC3339), st.kiss)}
+}
+
+// noteReply updates the hold on server after a query that ended with
+// err: a DENY, RSTR or RATE kiss starts or extends it, and a usable
+// reply clears it.
+func (c *Client) noteReply(server string, err error) {
+	if err == nil {
+		c.mu.Lock()
+		delete(c.kisses, server)
+		c.mu.Unlock()
+		return
+	}
+	var kiss *KissOfDeathError
+	if !errors.As(err, &kiss) {
+		return
+	}
+	code := CodeOf(err)
+	if code != NTP_ERR_KOD_DENY && code != NTP_ERR_KOD_RATE {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.kisses == nil {
+		c.kisses = make(map[string]kissState)
+	}
+	hold := kissDenyHold
+	if code == NTP_ERR_KOD_RATE {
+		hold = kissRateHold
+		if prev, ok := c.kisses[server]; ok && prev.code == NTP_ERR_KOD_RATE {
+			hold = min(2*prev.hold, kissRateMaxHold)
+		}
+	}
+	c.kisses[server] = kissState{kiss: kiss, code: code, hold: hold, until: c.now().Add(hold)}
+	c.infof("%s sent kiss-of-death %s; not querying it for %v\n", server, kiss.Kiss, hold)
+}
@@ -0,0 +1,162 @@
+// Package leapsec parses and verifies the leap-seconds.list file
+// published by the IERS and redistributed by NIST and the IANA tz
+// project.
+package leapsec
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ntpEpochOffset = 2208988800
+
+var (
+	ErrNoChecksum = errors.New("leapsec: file has no #h checksum line")
+	ErrChecksum   = errors.New("leapsec: checksum mismatch")
+)
+
+// Leap is one entry of the table: from Time on, TAI-UTC is Offset
+// seconds.
+type Leap struct {
+	Time   time.Time
+	Offset int
+}
+
+// Table is a verified leap second file.
+type Table struct {
+	Updated time.Time
+	Expires time.Time
+	Leaps   []Leap
+	Hash    [sha1.Size]byte
+}
+
+// Parse reads a leap-seconds.list file and verifies its SHA-1 checksum.
+// The checksum covers the digits of the #$ (update) and #@ (expiry)
+// values and of the first two fields of every data line, in file
+// order.
+func Parse(r io.Reader) (*Table, error) {
+	t := &Table{}
+	h := sha1.New()
+	var want []byte
+	sc := bufio.NewScanner(r)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "#$"), strings.HasPrefix(line, "#@"):
+			v, err := parseNTP(strings.TrimSpace(line[2:]))
+			if err != nil {
+				return nil, fmt.Errorf("leapsec: line %d: %v", n, err)
+			}
+			h.Write([]byte(strconv.FormatUint(v, 10)))
+			if line[1] == '$' {
+				t.Updated = ntpTime(v)
+			} else {
+				t.Expires = ntpTime(v)
+			}
+		case strings.HasPrefix(line, "#h"):
+			sum, err := parseHash(line[2:])
+			if err != nil {
+				return nil, fmt.Errorf("leapsec: line %d: %v", n, err)
+			}
+			want = sum
+		case strings.HasPrefix(line, "#"), strings.TrimSpace(line) == "":
+		default:
+			if i := strings.IndexByte(line, '#'); i >= 0 {
+				line = line[:i]
+			}
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("leapsec: line %d: expected timestamp and offset", n)
+			}
+			ts, err := parseNTP(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("leapsec: line %d: %v", n, err)
+			}
+			off, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("leapsec: line %d: bad offset %q", n, fields[1])
+			}
+			if k := len(t.Leaps); k > 0 && !ntpTime(ts).After(t.Leaps[k-1].Time) {
+				return nil, fmt.Errorf("leapsec: line %d: entries out of order", n)
+			}
+			h.Write([]byte(fields[0]))
+			h.Write([]byte(fields[1]))
+			t.Leaps = append(t.Leaps, Leap{Time: ntpTime(ts), Offset: off})
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if want == nil {
+		return nil, ErrNoChecksum
+	}
+	copy(t.Hash[:], h.Sum(nil))
+	if !bytes.Equal(t.Hash[:], want) {
+		return nil, ErrChecksum
+	}
+	if t.Expires.IsZero() {
+		return nil, errors.New("leapsec: file has no #@ expiry line")
+	}
+	if len(t.Leaps) == 0 {
+		return nil, errors.New("leapsec: file has no entries")
+	}
+	return t, nil
+}
+
+// Expired reports whether the table is past its expiry date at now.
+func (t *Table) Expired(now time.Time) bool {
+	return !now.Before(t.Expires)
+}
+
+// Offset returns TAI-UTC in seconds at the instant tm, or 0 before the
+// first entry.
+func (t *Table) Offset(tm time.Time) int {
+	off := 0
+	for _, l := range t.Leaps {
+		if tm.Before(l.Time) {
+			break
+		}
+		off = l.Offset
+	}
+	return off
+}
+
+func parseNTP(s string) (uint64, error) {
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad timestamp %q", s)
+	}
+	return v, nil
+}
+
+func ntpTime(secs uint64) time.Time {
+	return time.Unix(int64(secs)-ntpEpochOffset, 0).UTC()
+}
+
+// parseHash reads the five 32-bit hex words of a #h line. Published
+// files sometimes drop leading zeros from a word, so each is parsed as
+// a number rather than as a fixed-width string.
+func parseHash(s string) ([]byte, error) {
+	words := strings.Fields(s)
+	if len(words) != 5 {
+		return nil, fmt.Errorf("checksum has %d words, want 5", len(words))
+	}
+	sum := make([]byte, 0, sha1.Size)
+	for _, w := range words {
+		v, err := strconv.ParseUint(w, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad checksum word %q", w)
+		}
+		sum = append(sum, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	return sum, nil
+}
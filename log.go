@@ -0,0 +1,127 @@
+//go:build !ntp_nolog
+
+package ntp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// logf reports errors through c.Logger, if set; infof reports what the
+// client decided to do about them, such as retrying, and debugf the
+// routine events of an exchange, such as dropping a stray datagram.
+// Only a SlogLogger tells them apart. Build with the ntp_nolog tag to
+// compile logging out entirely, e.g. for TinyGo targets where the
+// formatting is too heavy.
+func (c *Client) logf(format string, args ...interface{}) {
+	c.logAt(slog.LevelWarn, format, args)
+}
+
+func (c *Client) infof(format string, args ...interface{}) {
+	c.logAt(slog.LevelInfo, format, args)
+}
+
+func (c *Client) debugf(format string, args ...interface{}) {
+	c.logAt(slog.LevelDebug, format, args)
+}
+
+func (c *Client) logAt(level slog.Level, format string, args []interface{}) {
+	switch l := c.Logger.(type) {
+	case nil:
+	case *slogLogger:
+		l.log(level, format, args)
+	default:
+		l.Printf(format, args...)
+	}
+}
+
+// SlogLogger returns a Logger that writes to l: failures as Warn
+// records, what the client does about them (retries, kiss-of-death
+// holds) as Info, and dropped datagrams as Debug. When l has Debug
+// enabled, every exchange is also one Debug record carrying the
+// request and the decoded reply as attributes. Lines written through
+// Printf are Info records. It is not available with the ntp_nolog tag.
+func SlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s *slogLogger) Printf(format string, args ...interface{}) {
+	s.log(slog.LevelInfo, format, args)
+}
+
+// log writes one record at level, formatting it only if it is enabled.
+func (s *slogLogger) log(level slog.Level, format string, args []interface{}) {
+	ctx := context.Background()
+	if s.l.Enabled(ctx, level) {
+		s.l.Log(ctx, level, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+	}
+}
+
+// logExchange reports a completed exchange: structured through a
+// SlogLogger, as two lines through any other Logger.
+func (c *Client) logExchange(server string, req *DataPacket, r *Response) {
+	if c.Logger == nil {
+		// Boxing the arguments for logf would allocate even though
+		// nothing is written.
+		return
+	}
+	s, ok := c.Logger.(*slogLogger)
+	if !ok {
+		c.debugf("Sent query to the %s at: %v", server, r.Sent)
+		c.debugf("Received reply from the %s at: %v", server, r.Received)
+		return
+	}
+	ctx := context.Background()
+	if !s.l.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	p := &r.Packet
+	// The server's times are placed in the era the offset was
+	// computed in, so that they agree with it.
+	t2 := p.ReceiveTimeStamp.TimeNear(c.eraPivot(r.Received))
+	s.l.LogAttrs(ctx, slog.LevelDebug, "ntp exchange",
+		slog.String("server", server),
+		slog.Group("request",
+			slog.Int("version", int(req.DecodeVersion())),
+			slog.String("mode", req.DecodeMode()),
+			slog.String("transmit", c.nonceString(req.TransmitTimeStamp)),
+		),
+		slog.Group("reply",
+			slog.Int("version", int(p.DecodeVersion())),
+			slog.String("mode", p.DecodeMode()),
+			slog.String("leap", p.DecodeLeapIndicator()),
+			slog.Int("stratum", int(p.Stratum)),
+			slog.Int("poll", int(p.Poll)),
+			slog.Int("precision", int(p.Precision)),
+			slog.Duration("root_delay", p.RootDelay.Duration()),
+			slog.Duration("root_dispersion", p.RootDispersion.Duration()),
+			slog.String("refid", p.DecodeReferenceIdentifier()),
+			slog.String("reference", p.ReferenceTimeStamp.String()),
+			slog.String("originate", c.nonceString(p.OriginateTimeStamp)),
+			slog.String("receive", p.ReceiveTimeStamp.String()),
+			slog.String("transmit", p.TransmitTimeStamp.String()),
+		),
+		slog.Time("t1", r.Sent),
+		slog.Time("t2", t2),
+		slog.Time("t3", r.Time),
+		slog.Time("t4", r.Received),
+		slog.Duration("offset", r.ClockOffset),
+		slog.Duration("delay", r.RTT),
+	)
+}
+
+// nonceString formats the transmit timestamp of a request, or its echo
+// in the reply: a time only under SendClock, and otherwise the random
+// bits alone, which stand for no time.
+func (c *Client) nonceString(t NTPTime) string {
+	if c.SendClock {
+		return t.String()
+	}
+	return hex8(t.Seconds()) + "." + hex8(t.Fraction())
+}
@@ -0,0 +1,9 @@
+//go:build ntp_nolog
+
+package ntp
+
+func (c *Client) logf(format string, args ...interface{})   {}
+func (c *Client) infof(format string, args ...interface{})  {}
+func (c *Client) debugf(format string, args ...interface{}) {}
+
+func (c *Client) logExchange(server string, req *DataPacket, r *Response) {}
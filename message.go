@@ -0,0 +1,93 @@
+package ntp
+
+import (
+	"encoding/binary"
+)
+
+// Sizes of what may follow the header (RFC 5905 section 7.3 and RFC
+// 7822).
+const (
+	extHeaderSize = 4
+	minExtSize    = 16
+	cryptoNAKSize = 4      // key ID only
+	md5MACSize    = 4 + 16 // key ID and MD5 digest
+	sha1MACSize   = 4 + 20 // key ID and SHA-1 digest
+	maxMACSize    = sha1MACSize
+)
+
+var (
+	errVersion   = newError(NTP_ERR_MALFORMED, "ntp: unsupported version")
+	errExtLength = newError(NTP_ERR_MALFORMED, "ntp: bad extension field length")
+	errTrailing  = newError(NTP_ERR_MALFORMED, "ntp: trailing bytes are neither an extension field nor a MAC")
+)
+
+// ExtensionField is an NTPv4 extension field. Value aliases the buffer
+// the message was decoded from.
+type ExtensionField struct {
+	Type  uint16
+	Value []byte
+}
+
+// Message is a whole NTP packet: the header and any extension fields and
+// MAC after it.
+type Message struct {
+	Header     DataPacket
+	Extensions []ExtensionField
+
+	// HasMAC is set when the packet ends in a MAC. A MAC with a key ID
+	// and no digest is a crypto-NAK.
+	HasMAC bool
+	KeyID  uint32
+	Digest []byte
+}
+
+// DecodeMessage parses a complete NTP packet into msg. Unlike
+// DecodePacket it accounts for every byte: the version must be 1 to 4,
+// each extension field must have a sane length that fits in buf, and
+// what remains must be empty or the size of a MAC. Anything else is an
+// error. DecodeMessage never panics and keeps no state between calls,
+// so it is safe to feed arbitrary input, e.g. from a fuzzer. The
+// Extensions slice of msg is reused, and the values in it and Digest
+// alias buf.
+func DecodeMessage(buf []byte, msg *Message) error {
+	msg.Extensions = msg.Extensions[:0]
+	msg.HasMAC, msg.KeyID, msg.Digest = false, 0, nil
+	if err := msg.Header.decode(buf); err != nil {
+		return err
+	}
+	version := msg.Header.DecodeVersion()
+	if version < 1 || version > 4 {
+		return errVersion
+	}
+
+	rest := buf[PACKET_SIZE:]
+	for len(rest) > 0 {
+		// A MAC is at most 24 bytes and an extension field at least
+		// 16, so short tails are MACs (RFC 7822 section 7.5).
+		if len(rest) <= maxMACSize {
+			switch len(rest) {
+			case cryptoNAKSize, md5MACSize, sha1MACSize:
+				msg.HasMAC = true
+				msg.KeyID = binary.BigEndian.Uint32(rest)
+				msg.Digest = rest[4:]
+				return nil
+			}
+			if len(rest) < minExtSize {
+				return errTrailing
+			}
+		}
+		if version < 4 {
+			return errTrailing
+		}
+		n := int(binary.BigEndian.Uint16(rest[2:]))
+		if n < minExtSize || n%4 != 0 || n > len(rest) {
+			return errExtLength
+		}
+		msg.Extensions = append(msg.Extensions, ExtensionField{
+			Type:  binary.BigEndian.Uint16(rest),
+			Value: rest[extHeaderSize:n],
+		})
+		rest = rest[n:]
+	}
+	return nil
+}
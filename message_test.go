@@ -0,0 +1,109 @@
+package ntp_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/chaitanyav/ntp"
+)
+
+// header returns an encoded version 4 server reply.
+func header() []byte {
+	buf := make([]byte, ntp.PACKET_SIZE)
+	ntp.EncodePacket(buf, &packet)
+	return buf
+}
+
+// extension returns an extension field of the given type with value
+// padded to n bytes in all.
+func extension(typ uint16, n int) []byte {
+	b := make([]byte, n)
+	binary.BigEndian.PutUint16(b, typ)
+	binary.BigEndian.PutUint16(b[2:], uint16(n))
+	return b
+}
+
+func cat(bs ...[]byte) []byte { return bytes.Join(bs, nil) }
+
+func TestDecodeMessage(t *testing.T) {
+	mac := append([]byte{0, 0, 0, 7}, bytes.Repeat([]byte{0xaa}, 16)...)
+	tests := []struct {
+		name    string
+		buf     []byte
+		ok      bool
+		exts    int
+		hasMAC  bool
+		keyID   uint32
+		digestN int
+	}{
+		{"header only", header(), true, 0, false, 0, 0},
+		{"md5 mac", cat(header(), mac), true, 0, true, 7, 16},
+		{"crypto-nak", cat(header(), []byte{0, 0, 0, 0}), true, 0, true, 0, 0},
+		{"extensions and mac", cat(header(), extension(0x0104, 16), extension(0x0204, 40), mac), true, 2, true, 7, 16},
+		{"short", header()[:47], false, 0, false, 0, 0},
+		{"trailing bytes", cat(header(), []byte{1, 2, 3}), false, 0, false, 0, 0},
+		{"extension under 16 bytes", cat(header(), func() []byte { e := extension(1, 28); e[3] = 12; return e }()), false, 0, false, 0, 0},
+		{"extension past the end", cat(header(), func() []byte { e := extension(1, 32); return e[:28] }()), false, 0, false, 0, 0},
+		{"extension not a multiple of 4", cat(header(), func() []byte { e := extension(1, 28); e[3] = 26; return e }()), false, 0, false, 0, 0},
+		{"version 0", func() []byte { b := header(); b[0] &^= 0x38; return b }(), false, 0, false, 0, 0},
+		{"extension in version 3", func() []byte {
+			b := cat(header(), extension(1, 28))
+			b[0] = b[0]&^0x38 | 3<<3
+			return b
+		}(), false, 0, false, 0, 0},
+	}
+	var msg ntp.Message
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ntp.DecodeMessage(tt.buf, &msg)
+			if (err == nil) != tt.ok {
+				t.Fatalf("DecodeMessage = %v, want ok %v", err, tt.ok)
+			}
+			if err != nil {
+				if code := ntp.CodeOf(err); code != ntp.NTP_ERR_MALFORMED {
+					t.Errorf("error %q has code %s, want NTP_ERR_MALFORMED", err, code)
+				}
+				return
+			}
+			if len(msg.Extensions) != tt.exts || msg.HasMAC != tt.hasMAC || msg.KeyID != tt.keyID || len(msg.Digest) != tt.digestN {
+				t.Errorf("got %d extensions, MAC %v, key %d and %d bytes of digest; want %d, %v, %d and %d",
+					len(msg.Extensions), msg.HasMAC, msg.KeyID, len(msg.Digest), tt.exts, tt.hasMAC, tt.keyID, tt.digestN)
+			}
+		})
+	}
+}
+
+// FuzzDecodeMessage checks that DecodeMessage never panics and that
+// what it accepts accounts for every byte of the input.
+func FuzzDecodeMessage(f *testing.F) {
+	mac := append([]byte{0, 0, 0, 7}, bytes.Repeat([]byte{0xaa}, 20)...)
+	f.Add(header())
+	f.Add(cat(header(), mac))
+	f.Add(cat(header(), extension(0x0104, 16), extension(0x0204, 40)))
+	f.Add(cat(header(), extension(0x0104, 16), mac[:20]))
+	f.Add(cat(header(), []byte{0, 1, 0xff, 0xfc}))
+	f.Add(header()[:20])
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		var msg ntp.Message
+		if ntp.DecodeMessage(buf, &msg) != nil {
+			return
+		}
+		n := ntp.PACKET_SIZE
+		for _, e := range msg.Extensions {
+			n += 4 + len(e.Value)
+			if (4+len(e.Value))%4 != 0 || 4+len(e.Value) < 16 {
+				t.Errorf("extension field of %d bytes accepted", 4+len(e.Value))
+			}
+		}
+		if msg.HasMAC {
+			n += 4 + len(msg.Digest)
+		}
+		if n != len(buf) {
+			t.Errorf("decoded %d of %d bytes", n, len(buf))
+		}
+		if v := msg.Header.DecodeVersion(); v < 1 || v > 4 {
+			t.Errorf("version %d accepted", v)
+		}
+	})
+}
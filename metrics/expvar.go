@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"expvar"
+
+	"github.com/chaitanyav/ntp"
+)
+
+// expvarServer is one server's entry in what Publish exports.
+type expvarServer struct {
+	Up       bool    `json:"up"`
+	Queries  uint64  `json:"queries"`
+	Errors   uint64  `json:"errors"`
+	Timeouts uint64  `json:"timeouts"`
+	Offset   float64 `json:"last_offset_seconds"`
+	RTT      float64 `json:"last_rtt_seconds"`
+}
+
+// Publish exports the Collector's counts through package expvar under
+// name, for services that already serve /debug/vars: an object holding,
+// for each server, whether it is up, its queries, failures and timeouts
+// so far, and the offset and round trip of its last reply, e.g.
+//
+//	"ntp": {"time.example.com": {"up": true, "queries": 12, "errors": 1,
+//		"timeouts": 1, "last_offset_seconds": 0.0021, "last_rtt_seconds": 0.018}}
+//
+// Like expvar.Publish, it panics if name is already taken.
+func (c *Collector) Publish(name string) {
+	expvar.Publish(name, expvar.Func(c.expvar))
+}
+
+func (c *Collector) expvar() any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]expvarServer, len(c.servers))
+	for name, s := range c.servers {
+		e := expvarServer{Up: s.up, Queries: s.queries, Timeouts: s.errors[ntp.NTP_ERR_TIMEOUT]}
+		for _, n := range s.errors {
+			e.Errors += n
+		}
+		if s.last != nil {
+			e.Offset, e.RTT = s.last.ClockOffset.Seconds(), s.last.RTT.Seconds()
+		}
+		out[name] = e
+	}
+	return out
+}
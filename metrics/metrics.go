@@ -0,0 +1,201 @@
+// Package metrics keeps per-server metrics of a Client's queries and
+// serves them in the Prometheus text exposition format, so that
+// programs using the client can alert on clock offset without an
+// exporter of their own:
+//
+//	var m metrics.Collector
+//	c := &ntp.Client{OnQuery: m.Observe}
+//	http.Handle("/metrics", &m)
+//
+// Every server queried gets the gauges ntp_up, ntp_offset_seconds,
+// ntp_delay_seconds, ntp_jitter_seconds, ntp_stratum,
+// ntp_root_delay_seconds, ntp_root_dispersion_seconds, ntp_leap,
+// ntp_reach and ntp_last_success_timestamp_seconds, labelled with the server as
+// named, and the counters ntp_queries_total and ntp_query_errors_total,
+// the latter also labelled with the error's code. The names match those
+// of cmd/ntp_exporter. Publish exports the same counts through package
+// expvar instead, and StatsD pushes them to a StatsD server.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+)
+
+// jitterDepth is how many recent replies of a server the jitter is
+// taken over.
+const jitterDepth = 8
+
+// Collector gathers the outcome of each query passed to Observe and
+// writes the metrics out on every scrape. The zero value is ready to
+// use, and it is safe for concurrent use.
+type Collector struct {
+	mu      sync.Mutex
+	servers map[string]*server
+}
+
+// server is what the Collector knows of one server.
+type server struct {
+	up          bool
+	last        *ntp.Response
+	lastSuccess time.Time
+	reach       uint8
+	queries     uint64
+	errors      map[ntp.Code]uint64
+	recent      []ntp.Sample // the latest replies, oldest first
+}
+
+// Observe records one query: the server as named, its reply, and the
+// error if it failed. It fits ntp.Client's OnQuery.
+func (c *Collector) Observe(name string, r *ntp.Response, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.servers == nil {
+		c.servers = make(map[string]*server)
+	}
+	s := c.servers[name]
+	if s == nil {
+		s = &server{errors: make(map[ntp.Code]uint64)}
+		c.servers[name] = s
+	}
+	s.queries++
+	s.reach <<= 1
+	if err != nil {
+		s.up = false
+		s.errors[ntp.CodeOf(err)]++
+		return
+	}
+	s.up = true
+	s.reach |= 1
+	s.last = r
+	s.lastSuccess = r.Received
+	if len(s.recent) == jitterDepth {
+		s.recent = append(s.recent[:0], s.recent[1:]...)
+	}
+	s.recent = append(s.recent, ntp.SampleOf(r))
+}
+
+// ServeHTTP writes the metrics for a Prometheus scrape.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	c.WriteTo(w)
+}
+
+// family is one metric and its samples.
+type family struct {
+	name, kind, help string
+	lines            []string
+}
+
+// WriteTo writes the metrics in the text exposition format, servers in
+// order of name.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	up := &family{"ntp_up", "gauge", "Whether the last query got a usable reply.", nil}
+	offset := &family{"ntp_offset_seconds", "gauge", "Clock offset of the server relative to this host.", nil}
+	delay := &family{"ntp_delay_seconds", "gauge", "Round-trip network delay to the server.", nil}
+	jitter := &family{"ntp_jitter_seconds", "gauge", "RMS difference between successive recent offsets.", nil}
+	stratum := &family{"ntp_stratum", "gauge", "Stratum reported by the server.", nil}
+	rootDelay := &family{"ntp_root_delay_seconds", "gauge", "Root delay reported by the server.", nil}
+	rootDisp := &family{"ntp_root_dispersion_seconds", "gauge", "Root dispersion reported by the server.", nil}
+	leap := &family{"ntp_leap", "gauge", "Leap indicator reported by the server.", nil}
+	reach := &family{"ntp_reach", "gauge", "Reachability register: one bit per recent query, newest lowest.", nil}
+	success := &family{"ntp_last_success_timestamp_seconds", "gauge", "Unix time of the last usable reply.", nil}
+	queries := &family{"ntp_queries_total", "counter", "Queries sent, counting each retry.", nil}
+	errs := &family{"ntp_query_errors_total", "counter", "Queries that failed, by error code.", nil}
+
+	c.mu.Lock()
+	names := make([]string, 0, len(c.servers))
+	for name := range c.servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := c.servers[name]
+		l := `{server=` + quote(name) + `}`
+		up.add(l, boolValue(s.up))
+		reach.add(l, float64(s.reach))
+		queries.add(l, float64(s.queries))
+		codes := make([]string, 0, len(s.errors))
+		for code := range s.errors {
+			codes = append(codes, string(code))
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			errs.add(`{server=`+quote(name)+`,code=`+quote(code)+`}`, float64(s.errors[ntp.Code(code)]))
+		}
+		if s.last == nil {
+			continue
+		}
+		// Only the latest query's reply describes the server now; a
+		// server that stopped answering keeps its history.
+		if s.up {
+			offset.add(l, s.last.ClockOffset.Seconds())
+			delay.add(l, s.last.RTT.Seconds())
+			stratum.add(l, float64(s.last.Stratum))
+			rootDelay.add(l, s.last.RootDelay.Seconds())
+			rootDisp.add(l, s.last.RootDispersion.Seconds())
+			leap.add(l, float64(s.last.Leap))
+		}
+		success.add(l, float64(s.lastSuccess.UnixNano())/1e9)
+		if len(s.recent) > 1 {
+			jitter.add(l, ntp.StatsOf(s.recent).Jitter.Seconds())
+		}
+	}
+	c.mu.Unlock()
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	for _, f := range []*family{up, offset, delay, jitter, stratum, rootDelay, rootDisp, leap, reach, success, queries, errs} {
+		if len(f.lines) == 0 {
+			continue
+		}
+		cw.WriteString("# HELP " + f.name + " " + f.help + "\n# TYPE " + f.name + " " + f.kind + "\n")
+		for _, line := range f.lines {
+			cw.WriteString(f.name + line + "\n")
+		}
+	}
+	if err := cw.w.Flush(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return cw.n, cw.err
+}
+
+func (f *family) add(labels string, v float64) {
+	f.lines = append(f.lines, labels+" "+strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quote(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+// countWriter counts what it writes and keeps the first error.
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) WriteString(s string) {
+	if cw.err != nil {
+		return
+	}
+	n, err := cw.w.WriteString(s)
+	cw.n += int64(n)
+	cw.err = err
+}
@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chaitanyav/ntp"
+)
+
+// StatsD sends every query it observes to a StatsD server, for
+// telemetry pipelines that are pushed to rather than scraped: gauges of
+// the reply's offset and round trip in seconds and of the server's
+// stratum, and counters of queries and of errors by code. Each query
+// goes out as one datagram; failures to send are ignored, as StatsD
+// clients do. Set the fields before the first Observe.
+type StatsD struct {
+	// Addr is the StatsD server's address (default "localhost:8125").
+	Addr string
+	// Prefix starts every metric name (default "ntp").
+	Prefix string
+	// DogStatsD tags the metrics with the server and error code, in
+	// the DogStatsD extension, rather than putting them in the names,
+	// and adds Tags to every metric.
+	DogStatsD bool
+	Tags      []string
+
+	once sync.Once
+	conn net.Conn
+	mu   sync.Mutex
+	buf  []byte
+}
+
+// Observe sends the metrics of one query. It fits ntp.Client's OnQuery.
+func (s *StatsD) Observe(server string, r *ntp.Response, err error) {
+	s.once.Do(s.dial)
+	if s.conn == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf = s.buf[:0]
+	s.count(server, "queries", "")
+	if err != nil {
+		s.count(server, "errors", string(ntp.CodeOf(err)))
+	} else {
+		s.gauge(server, "offset", r.ClockOffset.Seconds())
+		s.gauge(server, "rtt", r.RTT.Seconds())
+		s.gauge(server, "stratum", float64(r.Stratum))
+	}
+	s.conn.Write(s.buf[:len(s.buf)-1])
+}
+
+// Close closes the socket to the StatsD server.
+func (s *StatsD) Close() error {
+	s.once.Do(func() {})
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+func (s *StatsD) dial() {
+	addr := s.Addr
+	if addr == "" {
+		addr = "localhost:8125"
+	}
+	s.conn, _ = net.Dial("udp", addr)
+}
+
+func (s *StatsD) count(server, name, code string) {
+	if code != "" && !s.DogStatsD {
+		name += "." + sanitize(code)
+	}
+	s.line(server, name, "1", "c", code)
+}
+
+func (s *StatsD) gauge(server, name string, v float64) {
+	val := strconv.FormatFloat(v, 'f', -1, 64)
+	if v < 0 && !s.DogStatsD {
+		// Plain StatsD takes a signed gauge value as a change to the
+		// gauge, so a negative value is sent as a reset to zero first.
+		s.line(server, name, "0", "g", "")
+	}
+	s.line(server, name, val, "g", "")
+}
+
+// line appends one metric and its newline to s.buf.
+func (s *StatsD) line(server, name, val, typ, code string) {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "ntp"
+	}
+	s.buf = append(s.buf, prefix...)
+	s.buf = append(s.buf, '.')
+	if !s.DogStatsD {
+		s.buf = append(s.buf, sanitize(server)...)
+		s.buf = append(s.buf, '.')
+	}
+	s.buf = append(s.buf, name...)
+	s.buf = append(s.buf, ':')
+	s.buf = append(s.buf, val...)
+	s.buf = append(s.buf, '|')
+	s.buf = append(s.buf, typ...)
+	if s.DogStatsD {
+		s.buf = append(s.buf, "|#server:"...)
+		s.buf = append(s.buf, server...)
+		if code != "" {
+			s.buf = append(s.buf, ",code:"...)
+			s.buf = append(s.buf, code...)
+		}
+		for _, t := range s.Tags {
+			s.buf = append(s.buf, ',')
+			s.buf = append(s.buf, t...)
+		}
+	}
+	s.buf = append(s.buf, '\n')
+}
+
+// statsdEscaper replaces what would split a plain StatsD name into
+// more levels or end it early.
+var statsdEscaper = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", "\n", "_", " ", "_")
+
+func sanitize(s string) string {
+	return statsdEscaper.Replace(s)
+}
@@ -0,0 +1,120 @@
+// Package mobile is a small facade over the NTP client for apps that
+// embed it with gomobile bind, e.g. to check how far a phone's clock
+// is from trusted servers.
+//
+// Everything here uses only types gomobile can bind: strings, bools,
+// int64 and float64, errors, and pointers to structs of those. Durations
+// are int64 nanoseconds, times are Unix nanoseconds, and server lists
+// are comma-separated strings.
+package mobile
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+)
+
+// Result is the outcome of checking the device clock against one
+// server.
+type Result struct {
+	// Server is the name the check was made against and Addr the
+	// address that answered.
+	Server string
+	Addr   string
+
+	// OffsetNanos is how far the server's clock is ahead of the
+	// device's; a negative value means the device is fast.
+	OffsetNanos int64
+	// DelayNanos is the network round-trip time of the exchange.
+	DelayNanos int64
+
+	Stratum       int64
+	LeapIndicator int64
+	ReferenceID   string
+
+	// CheckedAtUnixNano is the device time at which the reply arrived.
+	CheckedAtUnixNano int64
+}
+
+// OffsetSeconds returns the offset in seconds.
+func (r *Result) OffsetSeconds() float64 {
+	return time.Duration(r.OffsetNanos).Seconds()
+}
+
+// DelaySeconds returns the round-trip delay in seconds.
+func (r *Result) DelaySeconds() float64 {
+	return time.Duration(r.DelayNanos).Seconds()
+}
+
+// WithinMillis reports whether the device clock is within tolerance
+// milliseconds of the server, allowing for half the round trip, which
+// bounds the error of the measurement.
+func (r *Result) WithinMillis(tolerance int64) bool {
+	off := r.OffsetNanos
+	if off < 0 {
+		off = -off
+	}
+	return off+r.DelayNanos/2 <= tolerance*int64(time.Millisecond)
+}
+
+// Check queries server (a host name or address, with an optional port)
+// once, waiting at most timeoutMillis for the reply. Replies from
+// servers that are unsynchronized or too far from their reference
+// clocks fail the check, as they do in package ntp.
+func Check(server string, timeoutMillis int64) (*Result, error) {
+	var c ntp.Client
+	r, err := c.QueryContext(context.Background(), server, ntp.WithTimeout(time.Duration(timeoutMillis)*time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		Server:            server,
+		Addr:              r.Addr,
+		OffsetNanos:       int64(r.ClockOffset),
+		DelayNanos:        int64(r.RTT),
+		Stratum:           int64(r.Stratum),
+		LeapIndicator:     int64(r.Leap),
+		ReferenceID:       r.ReferenceID,
+		CheckedAtUnixNano: r.Received.UnixNano(),
+	}, nil
+}
+
+// CheckBest queries the comma-separated servers at the same time and
+// returns the answer with the lowest delay. It fails only if none of
+// them answers, with the first server's error.
+func CheckBest(servers string, timeoutMillis int64) (*Result, error) {
+	var names []string
+	for _, s := range strings.Split(servers, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			names = append(names, s)
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.New("mobile: no servers")
+	}
+	results := make([]*Result, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i], errs[i] = Check(name, timeoutMillis)
+		}(i, name)
+	}
+	wg.Wait()
+	var best *Result
+	for _, r := range results {
+		if r != nil && (best == nil || r.DelayNanos < best.DelayNanos) {
+			best = r
+		}
+	}
+	if best == nil {
+		return nil, errs[0]
+	}
+	return best, nil
+}
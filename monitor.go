@@ -0,0 +1,336 @@
+package ntp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Poll adaptation, as in ntpd: the poll exponent rises once the
+// offsets have stayed within pollGate times the jitter for long enough
+// and falls once they have strayed outside it, pollLimit being the
+// score at which either happens.
+const (
+	pollGate  = 4
+	pollLimit = 30
+)
+
+// Monitor polls a set of servers until its context is done, filters
+// each server's samples, and keeps a combined estimate of how far off
+// the local clock is. Each server starts at MinPoll; the interval doubles,
+// up to MaxPoll, while successive filtered offsets agree to within a
+// few times the server's jitter, and halves when they stop doing so,
+// so quiet servers are asked rarely and noisy ones often. A RATE
+// kiss-of-death doubles it straight away.
+//
+// Set the fields, then call Run; the methods that report state may be
+// called from any goroutine while it runs.
+type Monitor struct {
+	// Servers are the server names to poll; a name may include a port.
+	// Run fills in those of NTP_SERVERS if it is empty.
+	Servers []string
+	// Client sends the queries; a new Client when nil.
+	Client *Client
+	// MinPoll and MaxPoll bound the poll interval as log2 seconds;
+	// they default to 6 (64s) and 10 (1024s) and are kept within
+	// MINPOLL to MAXPOLL.
+	MinPoll, MaxPoll int
+	// IBurst starts each server with a Burst rather than one query,
+	// so that an estimate is ready within seconds.
+	IBurst bool
+	// Timeout bounds each query (default 2s).
+	Timeout time.Duration
+	// History is how many of each server's filtered samples are kept
+	// for its Stats (default 64).
+	History int
+	// OnUpdate, if set, is called with the new estimate, indexed as
+	// for Estimate, each time a server's filter yields a new sample
+	// and a majority of the servers agree. Calls are serialized, but
+	// each runs on the polling goroutine of the server that prompted
+	// it, so it should not block for long.
+	OnUpdate func(Estimate)
+	// OnPeer, if set, is called with a server's state each time its
+	// filter yields a new sample, after selection has run on it and
+	// before any OnUpdate call it prompted; it runs as OnUpdate does.
+	OnPeer func(PeerStatus)
+
+	mu    sync.Mutex
+	peers []*monitorPeer
+	est   *Estimate
+	cbMu  sync.Mutex // serializes OnUpdate and OnPeer
+}
+
+// PeerStatus is what a Monitor knows about one server.
+type PeerStatus struct {
+	Server string
+	// Poll is the current poll exponent, log2 seconds.
+	Poll int
+	// Reach is the reachability register: bit 0 is the latest poll.
+	Reach uint8
+	// Best is the sample the filter picked, with the filter's
+	// dispersion and jitter; they are zero until the server answers.
+	Best       Sample
+	Dispersion time.Duration
+	Jitter     time.Duration
+	// Stats summarizes the filtered samples kept, up to History of
+	// them: their jitter, wander and Allan deviation over time rather
+	// than across the filter's last few.
+	Stats Stats
+	// Last is the latest reply and Err the error of the latest poll,
+	// if it failed.
+	Last *Response
+	Err  error
+	// Falseticker, Survivor and SystemPeer tell how the server fared in
+	// the last selection; the system peer is the best survivor.
+	Falseticker bool
+	Survivor    bool
+	SystemPeer  bool
+}
+
+type monitorPeer struct {
+	PeerStatus
+	filter  Filter
+	score   int
+	prev    Sample
+	history []Sample // filtered samples, oldest first
+}
+
+// Run polls the servers until ctx is done and returns ctx's error.
+func (m *Monitor) Run(ctx context.Context) error {
+	if len(m.Servers) == 0 {
+		m.Servers = DefaultServers()
+	}
+	if len(m.Servers) == 0 {
+		return errNoServers
+	}
+	c := m.Client
+	if c == nil {
+		c = new(Client)
+	}
+	minPoll, maxPoll := m.pollRange()
+	m.mu.Lock()
+	m.peers = make([]*monitorPeer, len(m.Servers))
+	for i, s := range m.Servers {
+		m.peers[i] = &monitorPeer{PeerStatus: PeerStatus{Server: s, Poll: minPoll}}
+	}
+	m.est = nil
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range m.peers {
+		wg.Add(1)
+		go func(p *monitorPeer) {
+			defer wg.Done()
+			m.poll(ctx, c, p, minPoll, maxPoll)
+		}(p)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (m *Monitor) pollRange() (int, int) {
+	lo, hi := m.MinPoll, m.MaxPoll
+	if lo == 0 {
+		lo = 6
+	}
+	if hi == 0 {
+		hi = 10
+	}
+	lo = min(max(lo, MINPOLL), MAXPOLL)
+	hi = min(max(hi, lo), MAXPOLL)
+	return lo, hi
+}
+
+// poll runs the polling loop of one server.
+func (m *Monitor) poll(ctx context.Context, c *Client, p *monitorPeer, minPoll, maxPoll int) {
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	first := true
+	for {
+		var rs []*Response
+		var err error
+		if first && m.IBurst {
+			rs, err = c.Burst(ctx, p.Server, 0, 0, WithTimeout(timeout))
+		} else {
+			var r *Response
+			if r, err = c.QueryContext(ctx, p.Server, WithTimeout(timeout)); err == nil {
+				rs = []*Response{r}
+			}
+		}
+		first = false
+		if ctx.Err() != nil {
+			return
+		}
+		interval := m.update(p, rs, err, minPoll, maxPoll)
+		if c.sleep(ctx, interval) != nil {
+			return
+		}
+	}
+}
+
+// update feeds the outcome of one poll of p into its filter and poll
+// exponent, refreshes the estimate, and returns the time until p's next
+// poll.
+func (m *Monitor) update(p *monitorPeer, rs []*Response, err error, minPoll, maxPoll int) time.Duration {
+	m.mu.Lock()
+	p.Reach <<= 1
+	p.Err = err
+	fresh := false
+	for _, r := range rs {
+		p.Reach |= 1
+		p.Last = r
+		if best, ok := p.filter.Add(SampleOf(r)); ok {
+			fresh = true
+			if !p.prev.Time.IsZero() {
+				p.adapt(best.Offset-p.prev.Offset, minPoll, maxPoll)
+			}
+			p.prev = best
+			p.record(best, m.History)
+		}
+	}
+	p.Best, p.Dispersion, p.Jitter = p.filter.Best(), p.filter.Dispersion(), p.filter.Jitter()
+	if CodeOf(err) == NTP_ERR_KOD_RATE {
+		p.Poll = min(p.Poll+1, maxPoll)
+		p.score = 0
+	}
+	interval := time.Second << p.Poll
+	var est *Estimate
+	var st PeerStatus
+	if fresh {
+		est = m.selectLocked()
+		st = p.PeerStatus
+	}
+	m.mu.Unlock()
+	if fresh && (m.OnPeer != nil || est != nil && m.OnUpdate != nil) {
+		m.cbMu.Lock()
+		if m.OnPeer != nil {
+			m.OnPeer(st)
+		}
+		if est != nil && m.OnUpdate != nil {
+			m.OnUpdate(*est)
+		}
+		m.cbMu.Unlock()
+	}
+	return interval
+}
+
+// record adds best to p's history, dropping the oldest beyond depth,
+// and updates p's Stats.
+func (p *monitorPeer) record(best Sample, depth int) {
+	if depth <= 0 {
+		depth = 64
+	}
+	if len(p.history) >= depth {
+		n := copy(p.history, p.history[len(p.history)-depth+1:])
+		p.history = p.history[:n]
+	}
+	p.history = append(p.history, best)
+	p.Stats = StatsOf(p.history)
+}
+
+// adapt scores the change in p's filtered offset against its jitter and
+// moves the poll exponent once the score reaches pollLimit either way.
+func (p *monitorPeer) adapt(change time.Duration, minPoll, maxPoll int) {
+	if change < 0 {
+		change = -change
+	}
+	if change < pollGate*p.filter.Jitter() {
+		p.score += p.Poll
+		if p.score > pollLimit {
+			p.score = pollLimit
+			if p.Poll < maxPoll {
+				p.score = 0
+				p.Poll++
+			}
+		}
+	} else {
+		p.score -= 2 * p.Poll
+		if p.score < -pollLimit {
+			p.score = -pollLimit
+			if p.Poll > minPoll {
+				p.score = 0
+				p.Poll--
+			}
+		}
+	}
+}
+
+// selectLocked runs selection, clustering and combining over the
+// servers with a filtered sample, and stores and returns the estimate,
+// or nil if there is none. The caller holds m.mu.
+func (m *Monitor) selectLocked() *Estimate {
+	var rs []*Response
+	var jitter []time.Duration
+	var idx []int
+	for i, p := range m.peers {
+		p.Falseticker, p.Survivor, p.SystemPeer = false, false, false
+		if p.Last == nil || p.filter.Len() == 0 {
+			continue
+		}
+		// Stand in for the peer with its last reply, corrected to the
+		// filter's pick and carrying the filter's dispersion.
+		r := *p.Last
+		r.ClockOffset, r.RTT = p.Best.Offset, p.Best.Delay
+		r.RootDispersion += p.Dispersion
+		rs = append(rs, &r)
+		jitter = append(jitter, max(p.Jitter, r.Precision))
+		idx = append(idx, i)
+	}
+	x, err := Intersect(rs)
+	if err != nil {
+		m.est = nil
+		return nil
+	}
+	for _, i := range x.Falsetickers {
+		m.peers[idx[i]].Falseticker = true
+	}
+	survivors := Cluster(rs, x, jitter)
+	e := Combine(rs, x, survivors, jitter)
+	if e == nil {
+		m.est = nil
+		return nil
+	}
+	// Report the survivors by their place in Servers.
+	e.Peer = idx[e.Peer]
+	m.peers[e.Peer].SystemPeer = true
+	for k, i := range e.Survivors {
+		e.Survivors[k] = idx[i]
+		m.peers[idx[i]].Survivor = true
+	}
+	m.est = e
+	return e
+}
+
+// Estimate returns the latest combined estimate, or false if no
+// majority of the servers has agreed yet. Its Peer and Survivors index
+// Servers.
+func (m *Monitor) Estimate() (Estimate, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.est == nil {
+		return Estimate{}, false
+	}
+	return *m.est, true
+}
+
+// Offset returns how far the servers' time is estimated to be ahead of
+// the local clock, and the bound on that estimate's error, or false if
+// there is no estimate yet.
+func (m *Monitor) Offset() (offset, uncertainty time.Duration, ok bool) {
+	e, ok := m.Estimate()
+	return e.Offset, e.Uncertainty, ok
+}
+
+// Peers returns a snapshot of every server's state, in the order of
+// Servers; it is empty before Run.
+func (m *Monitor) Peers() []PeerStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]PeerStatus, len(m.peers))
+	for i, p := range m.peers {
+		out[i] = p.PeerStatus
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+package ntp
+
+import (
+	"context"
+	"sync"
+)
+
+// ServerResult is the outcome of one server's query in QueryMulti:
+// Response if it answered, Err if not.
+type ServerResult struct {
+	Server   string
+	Response *Response
+	Err      error
+	// Falseticker is set if the server answered but its offset lies
+	// outside the range the majority agrees on.
+	Falseticker bool
+}
+
+// MultiResult holds what QueryMulti learned from each server, in the
+// order the servers were given.
+type MultiResult struct {
+	Servers []ServerResult
+	// Intersection is the selection over the servers that answered,
+	// or nil if no majority of them agrees on the time. Its indexes,
+	// and Estimate's, are into Responses.
+	Intersection *Intersection
+	// Estimate combines the truechimers that survive Cluster into one
+	// offset with an error bound; it is nil whenever Intersection is.
+	Estimate *Estimate
+}
+
+// Responses returns the replies of the servers that answered.
+func (m *MultiResult) Responses() []*Response {
+	var rs []*Response
+	for _, s := range m.Servers {
+		if s.Response != nil {
+			rs = append(rs, s.Response)
+		}
+	}
+	return rs
+}
+
+var errNoServers = newError(NTP_ERR_CONFIG, "ntp: no servers")
+
+// QueryMulti queries every server at once, as QueryContext would each
+// one, waits for them all, and runs Intersect over the replies to pick
+// out falsetickers, then Cluster and Combine for a single offset. It
+// fails only if no server answers, with the first server's error; the
+// MultiResult then still reports every server's error.
+func (c *Client) QueryMulti(ctx context.Context, servers []string, opts ...Option) (*MultiResult, error) {
+	if len(servers) == 0 {
+		return nil, errNoServers
+	}
+	o, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	m := &MultiResult{Servers: make([]ServerResult, len(servers))}
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(sr *ServerResult, server string) {
+			defer wg.Done()
+			sr.Server = server
+			sr.Response, sr.Err = c.query(ctx, server, o)
+		}(&m.Servers[i], server)
+	}
+	wg.Wait()
+	var answered []int
+	for i, sr := range m.Servers {
+		if sr.Err == nil {
+			answered = append(answered, i)
+		}
+	}
+	if len(answered) == 0 {
+		return m, m.Servers[0].Err
+	}
+	rs := m.Responses()
+	if x, err := Intersect(rs); err == nil {
+		m.Intersection = x
+		for _, i := range x.Falsetickers {
+			m.Servers[answered[i]].Falseticker = true
+		}
+		m.Estimate = Combine(rs, x, Cluster(rs, x, nil), nil)
+	}
+	return m, nil
+}
@@ -6,9 +6,15 @@ package ntp
 //
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
 	"encoding/binary"
-	"log"
+	"math"
+	"math/rand/v2"
 	"net"
+	"net/netip"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -18,10 +24,103 @@ const NANO_SEC = float64(1e-9)
 const MICRO_SEC = float64(1e-6)
 const GIGA_SEC = float64(1e9)
 
-var leapIndicator map[byte]string
-var mode map[byte]string
-var version byte
+// MINPOLL and MAXPOLL bound the poll exponent, log2 seconds between
+// requests, as RFC 5905 defines it: 16s to about 36h.
+const (
+	MINPOLL = 4
+	MAXPOLL = 17
+)
+
+// MAXDIST is the largest root distance RFC 5905 lets a client
+// synchronize to, and MINDISP the least a round trip may add to it.
+// PHI is the frequency tolerance it assumes of every clock, 15ppm: the
+// rate at which an unsynchronized clock's error bound grows.
+const (
+	MAXDIST = time.Second
+	MINDISP = 5 * time.Millisecond
+	PHI     = 15e-6
+)
+
+// PACKET_SIZE is the length of an NTP header without extension fields
+// or MAC.
+const PACKET_SIZE = 48
+
+// LeapIndicator is the two-bit leap second warning in the first byte
+// of a header.
+type LeapIndicator uint8
+
+const (
+	LeapNoWarning LeapIndicator = iota // no leap second pending
+	LeapInsert                         // the last minute of the day has 61 seconds
+	LeapDelete                         // the last minute of the day has 59 seconds
+	LeapAlarm                          // the clock is not synchronized
+)
+
+func (l LeapIndicator) String() string {
+	if int(l) < len(leapIndicator) {
+		return leapIndicator[l]
+	}
+	return "LeapIndicator(" + strconv.Itoa(int(l)) + ")"
+}
+
+// Mode is the three-bit association mode in the first byte of a
+// header.
+type Mode uint8
+
+const (
+	ModeReserved Mode = iota
+	ModeSymmetricActive
+	ModeSymmetricPassive
+	ModeClient
+	ModeServer
+	ModeBroadcast
+	ModeControl // NTP control messages (ntpq)
+	ModePrivate // private use (ntpdc)
+)
+
+func (m Mode) String() string {
+	if int(m) < len(mode) {
+		return mode[m]
+	}
+	return "Mode(" + strconv.Itoa(int(m)) + ")"
+}
+
+// leapIndicator and mode are indexed by the two- and three-bit fields
+// of Byte1. They are arrays rather than maps built in init so that the
+// package has no start-up work, which matters on small targets.
+var leapIndicator = [4]string{
+	"no warning",
+	"last minute has 61 seconds",
+	"last minute has 59 seconds",
+	"alarm condition(clock not synchronized)",
+}
+
+var mode = [8]string{
+	"reserved",
+	"symmetric active",
+	"symmetric passive",
+	"client",
+	"server",
+	"broadcast",
+	"reserved for ntp control message",
+	"reserved for private use",
+}
+
+// ClientReceiveTimeStamp and ClientTransmitTimeStamp are the receive
+// and send times of the latest Query or QueryInto by any Client. They
+// are shared by every goroutine, so concurrent queries race on them.
+//
+// Deprecated: Use the Received and Sent fields of the Response from
+// Get, QueryContext or GetInto.
 var ClientReceiveTimeStamp time.Time
+
+// Deprecated: See ClientReceiveTimeStamp.
+var ClientTransmitTimeStamp time.Time
+
+// Offset was never set by the package.
+//
+// Deprecated: Use Response.ClockOffset, or OnWire for timestamps taken
+// elsewhere.
 var Offset uint64
 
 type NTP interface {
@@ -40,31 +139,100 @@ type DataPacket struct {
 	Stratum             byte
 	Poll                int8
 	Precision           int8
-	RootDelay           uint32
-	RootDispersion      uint32
+	RootDelay           NTPShort
+	RootDispersion      NTPShort
 	ReferenceIdentifier uint32
-	ReferenceTimeStamp  uint64
-	OriginateTimeStamp  uint64
-	ReceiveTimeStamp    uint64
-	TransmitTimeStamp   uint64
-}
-
-func init() {
-	leapIndicator = make(map[byte]string)
-	leapIndicator[0] = "no warning"
-	leapIndicator[1] = "last minute has 61 seconds"
-	leapIndicator[2] = "last minute has 59 seconds"
-	leapIndicator[3] = "alarm condition(clock not synchronized)"
-
-	mode = make(map[byte]string)
-	mode[0] = "reserved"
-	mode[1] = "symmetric active"
-	mode[2] = "symmetric passive"
-	mode[3] = "client"
-	mode[4] = "server"
-	mode[5] = "broadcast"
-	mode[6] = "reserved for ntp control message"
-	mode[7] = "reserved for private use"
+	ReferenceTimeStamp  NTPTime
+	OriginateTimeStamp  NTPTime
+	ReceiveTimeStamp    NTPTime
+	TransmitTimeStamp   NTPTime
+}
+
+// bufPool holds packet buffers for Query so that monitoring loops
+// polling at a high rate do not allocate on every exchange.
+var bufPool = sync.Pool{
+	New: func() interface{} { return new([PACKET_SIZE]byte) },
+}
+
+var errShortPacket = newError(NTP_ERR_MALFORMED, "ntp: packet shorter than 48 bytes")
+var errShortBuffer = newError(NTP_ERR_CONFIG, "ntp: buffer shorter than 48 bytes")
+
+// EncodePacket writes pkt in wire format into the first PACKET_SIZE
+// bytes of buf.
+func EncodePacket(buf []byte, pkt *DataPacket) error {
+	if len(buf) < PACKET_SIZE {
+		return errShortBuffer
+	}
+	pkt.encode(buf)
+	return nil
+}
+
+// DecodePacket parses the NTP header at the start of buf into pkt. It
+// does not allocate, so a caller can reuse one DataPacket for every
+// reply.
+func DecodePacket(buf []byte, pkt *DataPacket) error {
+	return pkt.decode(buf)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the
+// PACKET_SIZE bytes of the header in wire format.
+func (packet *DataPacket) MarshalBinary() ([]byte, error) {
+	return packet.AppendBinary(make([]byte, 0, PACKET_SIZE))
+}
+
+// AppendBinary appends the header in wire format to b.
+func (packet *DataPacket) AppendBinary(b []byte) ([]byte, error) {
+	b = append(b, make([]byte, PACKET_SIZE)...)
+	packet.encode(b[len(b)-PACKET_SIZE:])
+	return b, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. data must be
+// exactly one header; use DecodePacket to read the header of a longer
+// datagram, or DecodeMessage to read its extension fields and MAC too.
+func (packet *DataPacket) UnmarshalBinary(data []byte) error {
+	if len(data) != PACKET_SIZE {
+		return errPacketSize
+	}
+	return packet.decode(data)
+}
+
+var errPacketSize = newError(NTP_ERR_MALFORMED, "ntp: packet is not 48 bytes long")
+
+// encode writes the packet in wire order into the first PACKET_SIZE
+// bytes of buf.
+func (packet *DataPacket) encode(buf []byte) {
+	_ = buf[PACKET_SIZE-1]
+	buf[0] = packet.Byte1
+	buf[1] = packet.Stratum
+	buf[2] = byte(packet.Poll)
+	buf[3] = byte(packet.Precision)
+	binary.BigEndian.PutUint32(buf[4:], uint32(packet.RootDelay))
+	binary.BigEndian.PutUint32(buf[8:], uint32(packet.RootDispersion))
+	binary.BigEndian.PutUint32(buf[12:], packet.ReferenceIdentifier)
+	binary.BigEndian.PutUint64(buf[16:], uint64(packet.ReferenceTimeStamp))
+	binary.BigEndian.PutUint64(buf[24:], uint64(packet.OriginateTimeStamp))
+	binary.BigEndian.PutUint64(buf[32:], uint64(packet.ReceiveTimeStamp))
+	binary.BigEndian.PutUint64(buf[40:], uint64(packet.TransmitTimeStamp))
+}
+
+// decode fills the packet from the first PACKET_SIZE bytes of buf.
+func (packet *DataPacket) decode(buf []byte) error {
+	if len(buf) < PACKET_SIZE {
+		return errShortPacket
+	}
+	packet.Byte1 = buf[0]
+	packet.Stratum = buf[1]
+	packet.Poll = int8(buf[2])
+	packet.Precision = int8(buf[3])
+	packet.RootDelay = NTPShort(binary.BigEndian.Uint32(buf[4:]))
+	packet.RootDispersion = NTPShort(binary.BigEndian.Uint32(buf[8:]))
+	packet.ReferenceIdentifier = binary.BigEndian.Uint32(buf[12:])
+	packet.ReferenceTimeStamp = NTPTime(binary.BigEndian.Uint64(buf[16:]))
+	packet.OriginateTimeStamp = NTPTime(binary.BigEndian.Uint64(buf[24:]))
+	packet.ReceiveTimeStamp = NTPTime(binary.BigEndian.Uint64(buf[32:]))
+	packet.TransmitTimeStamp = NTPTime(binary.BigEndian.Uint64(buf[40:]))
+	return nil
 }
 
 func (packet *DataPacket) DecodeStratum() string {
@@ -80,8 +248,17 @@ func (packet *DataPacket) DecodeStratum() string {
 }
 
 func (packet *DataPacket) DecodeLeapIndicator() string {
-	b := (packet.Byte1 >> 6) & 3
-	return leapIndicator[b]
+	return packet.Leap().String()
+}
+
+// Leap returns the leap indicator of packet.
+func (packet *DataPacket) Leap() LeapIndicator {
+	return LeapIndicator(packet.Byte1 >> 6)
+}
+
+// SetLeap sets the leap indicator of packet.
+func (packet *DataPacket) SetLeap(l LeapIndicator) {
+	packet.Byte1 = packet.Byte1&0x3f | byte(l&3)<<6
 }
 
 func (packet *DataPacket) DecodeVersion() byte {
@@ -89,89 +266,325 @@ func (packet *DataPacket) DecodeVersion() byte {
 }
 
 func (packet *DataPacket) DecodeMode() string {
-	b := packet.Byte1 & 7
-	return mode[b]
+	return packet.Mode().String()
 }
 
-func (packet *DataPacket) DecodeReferenceIdentifier() string {
-	return ""
+// Mode returns the mode of packet.
+func (packet *DataPacket) Mode() Mode {
+	return Mode(packet.Byte1 & 7)
+}
+
+// SetMode sets the mode of packet.
+func (packet *DataPacket) SetMode(m Mode) {
+	packet.Byte1 = packet.Byte1&^7 | byte(m&7)
 }
 
-func decodeTimeStamp(timestamp uint64) time.Time {
-	ts := timestamp >> 32
-	ts = ts - NTP_EPOCH_OFFSET
-	nanosec := timestamp & 0xffffffff
-	nanosec = uint64((float64(nanosec) * (GIGA_SEC) / float64(TWO_32)))
-	return time.Unix(int64(ts), int64(nanosec))
+// DecodeRootDelay returns the root delay, the server's round trip to
+// the primary reference, as a duration.
+func (packet *DataPacket) DecodeRootDelay() time.Duration {
+	return packet.RootDelay.Duration()
 }
 
-func encodeTimeStamp() uint64 {
-	seconds := uint64(time.Now().Unix())
-	ts := seconds + NTP_EPOCH_OFFSET
-	timestamp := (ts << 32)
-	timestamp += uint64(float64(seconds % uint64(GIGA_SEC)) * float64(TWO_32) / float64(GIGA_SEC))
-	return timestamp
+// DecodeRootDispersion returns the root dispersion, the server's error
+// bound against the primary reference, as a duration.
+func (packet *DataPacket) DecodeRootDispersion() time.Duration {
+	return packet.RootDispersion.Duration()
+}
+
+// SetRootDelay stores d as the root delay, rounded to the short
+// format's 1/65536 s and clamped to its range; see ToNTPShort.
+func (packet *DataPacket) SetRootDelay(d time.Duration) {
+	packet.RootDelay = NewNTPShort(d)
+}
+
+// SetRootDispersion stores d as the root dispersion like SetRootDelay.
+func (packet *DataPacket) SetRootDispersion(d time.Duration) {
+	packet.RootDispersion = NewNTPShort(d)
+}
+
+// DecodePoll returns the poll interval, sent as a power of two
+// seconds, as a duration. In a reply it is the interval the server
+// suggests between requests. Exponents outside MINPOLL to MAXPOLL are
+// clamped to that range.
+func (packet *DataPacket) DecodePoll() time.Duration {
+	p := min(max(int(packet.Poll), MINPOLL), MAXPOLL)
+	return time.Second << p
+}
+
+// SetPoll stores d as the poll exponent: the largest power of two
+// seconds not above d, clamped to MINPOLL to MAXPOLL.
+func (packet *DataPacket) SetPoll(d time.Duration) {
+	p := MINPOLL
+	for p < MAXPOLL && time.Second<<(p+1) <= d {
+		p++
+	}
+	packet.Poll = int8(p)
+}
+
+// DecodePrecision returns the precision of the server's clock, sent as
+// a signed power of two seconds, as a duration: -20 is about 1µs and
+// -6 is 15.625ms. Durations under a nanosecond round to 0, and ones
+// too long for a Duration give the largest Duration.
+func (packet *DataPacket) DecodePrecision() time.Duration {
+	if packet.Precision > 33 {
+		return maxDuration
+	}
+	return time.Duration(math.Ldexp(float64(time.Second), int(packet.Precision)))
+}
+
+// DecodeReferenceIdentifier returns the reference ID as ntpq shows it.
+// For stratum 0 (a kiss-of-death) and 1 it is an ASCII code: the kiss
+// code, or the kind of reference clock, e.g. "GPS", "PPS" or "LOCL".
+// Above that it identifies the server's own source in dotted-quad
+// form: the source's IPv4 address, or for an IPv6 source the first
+// four bytes of the MD5 hash of its address (see ReferenceIDOf), which
+// cannot be turned back into the address.
+func (packet *DataPacket) DecodeReferenceIdentifier() string {
+	id := packet.ReferenceIdentifier
+	b := [4]byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	if packet.Stratum >= 2 {
+		return netip.AddrFrom4(b).String()
+	}
+	code := bytes.TrimRight(b[:], "\x00")
+	for i, c := range code {
+		if c < ' ' || c > '~' {
+			code[i] = '?'
+		}
+	}
+	return string(code)
+}
+
+// ReferenceIDOf returns the reference ID that a server synchronized to
+// addr sends: the address itself for IPv4, and the first four bytes of
+// the MD5 hash of the address for IPv6. Compare it with a reply's
+// ReferenceIdentifier to tell whether the server is synchronized to
+// addr, e.g. to detect a loop back to the local host.
+func ReferenceIDOf(addr netip.Addr) uint32 {
+	addr = addr.Unmap()
+	if addr.Is4() {
+		b := addr.As4()
+		return binary.BigEndian.Uint32(b[:])
+	}
+	b := addr.As16()
+	sum := md5.Sum(b[:])
+	return binary.BigEndian.Uint32(sum[:])
 }
 
 func (packet *DataPacket) DecodeOriginateTimeStamp() time.Time {
-	return decodeTimeStamp(packet.OriginateTimeStamp)
+	return packet.OriginateTimeStamp.Time()
 }
 
 func (packet *DataPacket) DecodeReceiveTimeStamp() time.Time {
-	return decodeTimeStamp(packet.ReceiveTimeStamp)
+	return packet.ReceiveTimeStamp.Time()
 }
 
 func (packet *DataPacket) DecodeTransmitTimeStamp() time.Time {
-	return decodeTimeStamp(packet.TransmitTimeStamp)
+	return packet.TransmitTimeStamp.Time()
 }
 
-func setReferenceTimeStamp(packet *DataPacket) {
-	packet.ReferenceTimeStamp = encodeTimeStamp()
+func setReferenceTimeStamp(packet *DataPacket, now time.Time) {
+	packet.ReferenceTimeStamp = NewNTPTime(now)
 }
 
-func setOriginateTimeStamp(packet *DataPacket) {
-	packet.OriginateTimeStamp = encodeTimeStamp()
+func setTransmitTimeStamp(packet *DataPacket, now time.Time) {
+	packet.TransmitTimeStamp = NewNTPTime(now)
 }
 
+// Query sends packet to server and returns the decoded reply.
+//
+// Deprecated: Use Get, which builds the request itself and also reports
+// when it was sent and the reply received. Query remains so existing
+// callers keep working.
 func Query(packet DataPacket, server string) (*DataPacket, error) {
-	conn, err := net.Dial("udp", server+":123")
+	return new(Client).Query(packet, server)
+}
+
+// Get queries server like Client.Get, using a new Client.
+func Get(server string, opts ...Option) (*Response, error) {
+	return new(Client).Get(server, opts...)
+}
+
+// Time returns the current time according to server, using a new
+// Client; see Client.Time.
+func Time(server string, opts ...Option) (time.Time, error) {
+	return new(Client).Time(server, opts...)
+}
+
+// QueryContext queries server like Get, until ctx is done; see
+// Client.QueryContext.
+func QueryContext(ctx context.Context, server string, opts ...Option) (*Response, error) {
+	return new(Client).QueryContext(ctx, server, opts...)
+}
+
+// QueryMulti queries every server at once, using a new Client; see
+// Client.QueryMulti.
+func QueryMulti(ctx context.Context, servers []string, opts ...Option) (*MultiResult, error) {
+	return new(Client).QueryMulti(ctx, servers, opts...)
+}
+
+// exchange sends packet on conn, which must be connected to server, and
+// reads the reply, timing the stages for qt.
+func (c *Client) exchange(conn net.Conn, packet DataPacket, server string, qt *queryTrace) (*Response, error) {
+	buf := bufPool.Get().(*[PACKET_SIZE]byte)
+	defer bufPool.Put(buf)
+
+	r := &Response{}
+	var step string
+	var err error
+	var start time.Time
+	if qt != nil {
+		start = time.Now()
+	}
+	r.Sent, r.Received, step, err = c.roundTrip(conn, &packet, &r.Packet, buf[:], qt)
+	if qt != nil {
+		qt.roundTrip(start, err)
+		start = time.Now()
+	}
+	if err == nil {
+		step, err = "checking the reply", checkReply(packet.Byte1, &r.Packet)
+		if err != nil {
+			qt.stage("ntp.decode", start, time.Now(), err)
+		}
+	}
 	if err != nil {
-		log.Printf("error on connecting to NTP Server: %v\n", err)
+		c.logf("error on %s: %v\n", step, err)
 		return nil, err
 	}
+	r.annotate(c.eraPivot(r.Received))
+	r.ReferenceID = r.Packet.DecodeReferenceIdentifier()
+	qt.stage("ntp.decode", start, time.Now(), nil)
+	c.logExchange(server, &packet, r)
+	return r, nil
+}
 
-	setReferenceTimeStamp(&packet)
-	setOriginateTimeStamp(&packet)
-	//log.Print("originate timestamp is: ", time.Unix(int64((packet.OriginateTimeStamp>>32)-NTP_EPOCH_OFFSET), 0), " seconds is: ", packet.OriginateTimeStamp>>32, " fraction is: ", packet.OriginateTimeStamp&0xffffffff)
-	tmpBuf := new(bytes.Buffer)
-	err = binary.Write(tmpBuf, binary.BigEndian, packet)
-	if err != nil {
-		log.Printf("error on converting the packet to bytes: %v\n", err)
-		return nil, err
+const maxDuration = time.Duration(1<<63 - 1)
+
+// annotate fills in the fields of r derived from its packet, except
+// ReferenceID, which would cost GetInto an allocation. The server's
+// timestamps are placed in the era nearest pivot.
+func (r *Response) annotate(pivot time.Time) {
+	p := &r.Packet
+	t2, t3 := p.ReceiveTimeStamp.TimeNear(pivot), p.TransmitTimeStamp.TimeNear(pivot)
+	r.Time = t3
+	r.ClockOffset, r.RTT = OnWire(r.Sent, t2, t3, r.Received)
+	r.Stratum = p.Stratum
+	r.RootDelay = p.RootDelay.Duration()
+	r.RootDispersion = p.RootDispersion.Duration()
+	r.Leap = p.Leap()
+	r.Precision = p.DecodePrecision()
+	r.Poll = p.DecodePoll()
+	r.ReferenceAge = referenceAge(p)
+}
+
+var (
+	errNoTransmit   = newError(NTP_ERR_MALFORMED, "ntp: reply has no transmit timestamp")
+	errUnsynced     = newError(NTP_ERR_UNSYNCED, "ntp: server clock is not synchronized")
+	errWrongVersion = newError(NTP_ERR_MALFORMED, "ntp: reply version differs from the request")
+)
+
+// checkReply rejects replies that cannot be used to set a clock. The
+// mode and version are only checked against client requests (request
+// is their Byte1); other modes are passed through for the caller to
+// interpret.
+func checkReply(request byte, p *DataPacket) error {
+	if Mode(request&7) == ModeClient {
+		if m := p.Mode(); m != ModeServer && m != ModeBroadcast {
+			return errNotServer
+		}
+		if p.Byte1>>3&7 != request>>3&7 {
+			return errWrongVersion
+		}
+	}
+	switch {
+	case p.Stratum == 0:
+		return KissOfDeath(p.ReferenceIdentifier)
+	case p.Leap() == LeapAlarm:
+		return errUnsynced
+	case p.TransmitTimeStamp == 0:
+		return errNoTransmit
 	}
+	return nil
+}
 
-	_, err = conn.Write(tmpBuf.Bytes())
-	if err != nil {
-		log.Printf("error on writing to UDP socket: %v\n", err)
-		return nil, err
+// referenceAge returns the time between p's reference and transmit
+// timestamps, or maxDuration if the reference timestamp is unset.
+func referenceAge(p *DataPacket) time.Duration {
+	if p.ReferenceTimeStamp == 0 {
+		return maxDuration
 	}
-	log.Printf("Sent query to the %s at: %v", server, packet.DecodeOriginateTimeStamp())
+	return p.TransmitTimeStamp.Sub(p.ReferenceTimeStamp)
+}
 
-	data := make([]byte, 48)
-	_, err = conn.Read(data)
-	if err != nil {
-		log.Printf("error on reading from UDP socket: %v\n", err)
-		return nil, err
+// roundTrip is the allocation-free core of an exchange: it encodes req
+// into buf, sends it, and decodes the reply from buf into resp. The
+// send and receive times are returned. On failure
+// it also names the step that failed. qt, if not nil, is given the
+// time the request was written.
+func (c *Client) roundTrip(conn net.Conn, req, resp *DataPacket, buf []byte, qt *queryTrace) (time.Time, time.Time, string, error) {
+	if c.PinnedIO {
+		// Declared here so that only pinned calls pay for the
+		// closure's variables escaping.
+		var sent, received time.Time
+		var step string
+		var err error
+		if perr := c.runPinned(func() { sent, received, step, err = c.roundTripHere(conn, req, resp, buf, qt) }); perr != nil {
+			return sent, received, "pinning the I/O thread", perr
+		}
+		return sent, received, step, err
 	}
+	return c.roundTripHere(conn, req, resp, buf, qt)
+}
 
-	ClientReceiveTimeStamp = time.Now()
-	log.Printf("Received reply from the %s at: %v", server, ClientReceiveTimeStamp)
-	outBuf := bytes.NewReader(data)
-	resPacket := DataPacket{}
-	err = binary.Read(outBuf, binary.BigEndian, &resPacket)
-	if err != nil {
-		log.Printf("error converting the response to packet: %v\n", err)
-		return nil, err
+func (c *Client) roundTripHere(conn net.Conn, req, resp *DataPacket, buf []byte, qt *queryTrace) (sent, received time.Time, step string, err error) {
+	if c.SendClock {
+		now := c.now()
+		setReferenceTimeStamp(req, now)
+		setTransmitTimeStamp(req, now)
+	} else {
+		req.ReferenceTimeStamp = 0
+		req.TransmitTimeStamp = NTPTime(rand.Uint64())
+	}
+	// req and resp may be the same packet, so keep what the reply has
+	// to echo.
+	xmit := req.TransmitTimeStamp
+	req.encode(buf)
+
+	sent = c.now()
+	if _, err := conn.Write(buf[:PACKET_SIZE]); err != nil {
+		return sent, received, "writing to UDP socket", err
+	}
+	if qt != nil {
+		qt.written = time.Now()
+	}
+
+	for {
+		var n int
+		n, received, err = c.read(conn, buf)
+		if err != nil {
+			return sent, received, "reading from UDP socket", err
+		}
+		// A server copies our transmit timestamp into its originate
+		// timestamp. Anything else, including a datagram too short to
+		// tell, is a late reply to an earlier request or a forgery
+		// from someone who did not see ours, so wait for the real one
+		// until the deadline. A transport carries exactly one reply
+		// per request, so there it is an error instead.
+		_, transport := conn.(*transportConn)
+		if err := resp.decode(buf[:n]); err != nil {
+			if transport {
+				return sent, received, "converting the response to packet", err
+			}
+			c.debugf("dropping a %d-byte datagram: %v\n", n, err)
+			continue
+		}
+		if resp.OriginateTimeStamp == xmit {
+			break
+		}
+		if transport {
+			return sent, received, "matching the reply to the request", errOrigin
+		}
+		c.debugf("dropping a reply with originate timestamp %v, expected %v\n", resp.OriginateTimeStamp, xmit)
 	}
-	return &resPacket, nil
+	sent = c.transmitTime(conn, sent)
+	return sent, received, "", nil
 }
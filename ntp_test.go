@@ -0,0 +1,96 @@
+package ntp_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+	"github.com/chaitanyav/ntp/ntptest"
+)
+
+// query asks a fresh client for the time from a server configured as
+// cfg, which is closed when the test ends.
+func query(t *testing.T, cfg ntptest.Config) (*ntp.Response, error) {
+	t.Helper()
+	srv := ntptest.NewServer(cfg)
+	t.Cleanup(func() { srv.Close() })
+	var c ntp.Client
+	return c.QueryContext(context.Background(), srv.Addr, ntp.WithTimeout(time.Second))
+}
+
+func TestQuery(t *testing.T) {
+	r, err := query(t, ntptest.Config{Stratum: 3, Offset: 250 * time.Millisecond, RefID: 0xc0000201})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := r.ClockOffset - 250*time.Millisecond; d < -10*time.Millisecond || d > 10*time.Millisecond {
+		t.Errorf("ClockOffset = %v, want about 250ms", r.ClockOffset)
+	}
+	if r.Stratum != 3 {
+		t.Errorf("Stratum = %d, want 3", r.Stratum)
+	}
+	if r.ReferenceID != "192.0.2.1" {
+		t.Errorf("ReferenceID = %q, want 192.0.2.1", r.ReferenceID)
+	}
+	if r.Leap != ntp.LeapNoWarning {
+		t.Errorf("Leap = %v, want %v", r.Leap, ntp.LeapNoWarning)
+	}
+	if r.Addr == "" {
+		t.Error("Addr is empty")
+	}
+}
+
+// mangle returns a Mangle function that applies f to each reply.
+func mangle(f func(b []byte)) func(req, resp []byte) []byte {
+	return func(req, resp []byte) []byte {
+		f(resp)
+		return resp
+	}
+}
+
+func TestQueryRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  ntptest.Config
+		code ntp.Code
+	}{
+		{"unsynchronized", ntptest.Config{Leap: 3}, ntp.NTP_ERR_UNSYNCED},
+		{"kiss of death", ntptest.Config{KoD: "DENY"}, ntp.NTP_ERR_KOD_DENY},
+		{"rate kiss", ntptest.Config{KoD: "RATE"}, ntp.NTP_ERR_KOD_RATE},
+		{"root distance", ntptest.Config{RootDispersion: 2 * time.Second}, ntp.NTP_ERR_DISTANCE},
+		{"version", ntptest.Config{Mangle: mangle(func(b []byte) { b[0] = b[0]&^0x38 | 3<<3 })}, ntp.NTP_ERR_MALFORMED},
+		{"mode", ntptest.Config{Mangle: mangle(func(b []byte) { b[0] = b[0]&^7 | 1 })}, ntp.NTP_ERR_MODE},
+		{"no transmit", ntptest.Config{Mangle: mangle(func(b []byte) { clear(b[40:48]) })}, ntp.NTP_ERR_MALFORMED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := query(t, tt.cfg)
+			if err == nil {
+				t.Fatalf("got a reply with offset %v, want an error", r.ClockOffset)
+			}
+			if code := ntp.CodeOf(err); code != tt.code {
+				t.Errorf("error %q has code %s, want %s", err, code, tt.code)
+			}
+		})
+	}
+}
+
+func TestGetIntoTimeout(t *testing.T) {
+	srv := ntptest.NewServer(ntptest.Config{DropRate: 1})
+	defer srv.Close()
+	for _, reuse := range []bool{false, true} {
+		c := &ntp.Client{ReuseConn: reuse, IntoTimeout: 50 * time.Millisecond}
+		defer c.Close()
+		var r ntp.Response
+		var buf [ntp.PACKET_SIZE]byte
+		if err := c.GetInto(&r, srv.Addr, buf[:]); ntp.CodeOf(err) != ntp.NTP_ERR_TIMEOUT {
+			t.Errorf("GetInto with ReuseConn %v and the reply dropped = %v, want NTP_ERR_TIMEOUT", reuse, err)
+		}
+		var req, resp ntp.DataPacket
+		req.Byte1 = 4<<3 | 3
+		if err := c.QueryInto(&req, srv.Addr, &resp, buf[:]); ntp.CodeOf(err) != ntp.NTP_ERR_TIMEOUT {
+			t.Errorf("QueryInto with ReuseConn %v and the reply dropped = %v, want NTP_ERR_TIMEOUT", reuse, err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package ntptest
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// pipeQueue is how many datagrams each direction of a pipe buffers;
+// further writes are dropped, as UDP would.
+const pipeQueue = 64
+
+// Pipe returns the two ends of an in-memory datagram link: a connected
+// client end and a server end suitable for NewServerConn. Every Write
+// is delivered as one datagram, and both ends honour deadlines.
+func Pipe() (net.Conn, net.PacketConn) {
+	toServer := make(chan []byte, pipeQueue)
+	toClient := make(chan []byte, pipeQueue)
+	done := make(chan struct{})
+	once := new(sync.Once)
+	client := &pipeConn{
+		in: toClient, out: toServer, done: done, once: once,
+		local: pipeAddr("client"), remote: pipeAddr("server"),
+		rd: newDeadline(), wd: newDeadline(),
+	}
+	server := &pipeConn{
+		in: toServer, out: toClient, done: done, once: once,
+		local: pipeAddr("server"), remote: pipeAddr("client"),
+		rd: newDeadline(), wd: newDeadline(),
+	}
+	return client, server
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeConn is one end of a Pipe. Closing either end closes both.
+type pipeConn struct {
+	in, out       chan []byte
+	done          chan struct{}
+	once          *sync.Once
+	local, remote pipeAddr
+	rd, wd        *deadline
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	case <-c.rd.wait():
+		return 0, os.ErrDeadlineExceeded
+	case p := <-c.in:
+		return copy(b, p), nil
+	}
+}
+
+func (c *pipeConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, c.remote, nil
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	case <-c.wd.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	select {
+	case c.out <- append([]byte(nil), b...):
+	default:
+	}
+	return len(b), nil
+}
+
+func (c *pipeConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.Write(b)
+}
+
+func (c *pipeConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.rd.set(t)
+	c.wd.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.rd.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.wd.set(t)
+	return nil
+}
+
+// deadline is a channel that closes when the deadline passes, so that a
+// blocked Read wakes up when the deadline is moved into the past.
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // the timer fired; wait for it to close cancel
+	}
+	d.timer = nil
+
+	closed := isClosed(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,305 @@
+// Package ntptest provides a scriptable NTP server for tests.
+//
+// A Server answers client-mode requests with a configurable stratum,
+// clock offset and network delay, and can be told to drop requests,
+// send kiss-of-death replies or rewrite its replies byte by byte. It
+// serves on loopback UDP, or on any net.PacketConn such as the
+// in-memory one returned by Pipe, so tests need no network and no
+// sleeps beyond the delays they ask for.
+package ntptest
+
+import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+)
+
+// Config describes how a Server answers. The zero value is a healthy
+// stratum 2 server with an accurate clock.
+type Config struct {
+	// Stratum is sent in replies; 0 means 2. Use KoD to send
+	// stratum 0 replies.
+	Stratum byte
+	// Leap is the leap indicator, 0 to 3.
+	Leap byte
+	// RefID is the reference identifier; 0 means 127.0.0.1.
+	RefID uint32
+	Poll  int8
+	// Precision is the clock's resolution as log2 seconds; 0 means
+	// -20, about 1µs.
+	Precision int8
+
+	RootDelay      time.Duration
+	RootDispersion time.Duration
+
+	// Offset is added to the server's clock, so a client should measure
+	// an offset of about this much.
+	Offset time.Duration
+	// Delay holds each reply back after it is timestamped. Clients see
+	// it as round-trip delay and, since only the return path is slowed,
+	// as half as much offset error.
+	Delay time.Duration
+
+	// DropRate is the probability, from 0 to 1, that a request goes
+	// unanswered.
+	DropRate float64
+	// KoD, when set, makes every reply a kiss-of-death with this
+	// four-letter code, e.g. "RATE" or "DENY".
+	KoD string
+
+	// Mangle, when set, is given each request and the encoded reply
+	// and returns the bytes to send instead. Returning nil drops the
+	// reply. Both slices are copies that Mangle may keep or modify.
+	Mangle func(req, resp []byte) []byte
+
+	// Clock, if set, replaces the system clock as the server's clock.
+	Clock ntp.Clock
+
+	// Tracer, if set, records each request answered as an "ntp.serve"
+	// span, with the attributes ntp.client and ntp.stratum and the
+	// children "ntp.decode" and "ntp.send", so that tests see both
+	// sides of a traced exchange.
+	Tracer ntp.Tracer
+}
+
+// Server is a fake NTP server.
+type Server struct {
+	// Addr is the host:port the server listens on.
+	Addr string
+
+	conn net.PacketConn
+	wg   sync.WaitGroup
+
+	mu       sync.Mutex
+	cfg      Config
+	requests int
+}
+
+// NewServer starts a server on a loopback UDP port. It panics if no
+// port can be had, as a test cannot go on without one.
+func NewServer(cfg Config) *Server {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic("ntptest: failed to listen: " + err.Error())
+	}
+	return NewServerConn(conn, cfg)
+}
+
+// NewServerConn starts a server answering requests that arrive on conn.
+// The server owns conn and closes it in Close.
+func NewServerConn(conn net.PacketConn, cfg Config) *Server {
+	s := &Server{Addr: conn.LocalAddr().String(), conn: conn, cfg: cfg}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// SetConfig changes how the server answers from the next request on.
+func (s *Server) SetConfig(cfg Config) {
+	s.mu.Lock()
+	s.cfg = cfg
+	s.mu.Unlock()
+}
+
+// Requests returns the number of client requests received, including
+// those that were dropped.
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Close stops the server and waits for pending replies to finish.
+func (s *Server) Close() error {
+	err := s.conn.Close()
+	s.wg.Wait()
+	return err
+}
+
+// replyPool holds reply buffers, so that a Server under load from a
+// benchmark does not allocate one per request. A buffer goes back
+// once its reply has been written, which for delayed replies is after
+// serve has moved on.
+var replyPool = sync.Pool{
+	New: func() interface{} { return new([ntp.PACKET_SIZE]byte) },
+}
+
+// putReply returns a reply buffer to replyPool, unless it has already
+// gone back.
+func putReply(b *[ntp.PACKET_SIZE]byte) {
+	if b != nil {
+		replyPool.Put(b)
+	}
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	buf := make([]byte, 1024)
+	for {
+		n, addr, ap, err := s.readFrom(buf)
+		if err != nil {
+			return
+		}
+		read := time.Now()
+		var req ntp.DataPacket
+		if ntp.DecodePacket(buf[:n], &req) != nil || req.Mode() != ntp.ModeClient {
+			continue
+		}
+		s.mu.Lock()
+		s.requests++
+		cfg := s.cfg
+		s.mu.Unlock()
+
+		if cfg.DropRate > 0 && rand.Float64() < cfg.DropRate {
+			continue
+		}
+		tr := cfg.startServe(read, addr, ap)
+		clk := cfg.Clock
+		if clk == nil {
+			clk = ntp.SystemClock
+		}
+		rx := clk.Now().Add(cfg.Offset)
+		pooled := replyPool.Get().(*[ntp.PACKET_SIZE]byte)
+		reply := pooled[:]
+		resp := cfg.reply(&req, rx)
+		resp.TransmitTimeStamp = ntp.NewNTPTime(clk.Now().Add(cfg.Offset))
+		ntp.EncodePacket(reply, &resp)
+		if cfg.Mangle != nil {
+			// The pooled buffer is reused, so Mangle gets a copy it
+			// can hold on to.
+			reply = cfg.Mangle(append([]byte(nil), buf[:n]...), append([]byte(nil), reply...))
+			replyPool.Put(pooled)
+			pooled = nil
+			if reply == nil {
+				tr.end(nil)
+				continue
+			}
+		}
+		tr.decoded(resp.Stratum)
+		if cfg.Delay <= 0 {
+			err := s.writeTo(reply, addr, ap)
+			putReply(pooled)
+			tr.end(err)
+			continue
+		}
+		s.wg.Add(1)
+		time.AfterFunc(cfg.Delay, func() {
+			defer s.wg.Done()
+			err := s.writeTo(reply, addr, ap)
+			putReply(pooled)
+			tr.end(err)
+		})
+	}
+}
+
+// readFrom reads a request into buf. On a *net.UDPConn the sender comes
+// back as ap, with addr nil, which spares an allocation per request;
+// on other connections it comes back as addr.
+func (s *Server) readFrom(buf []byte) (n int, addr net.Addr, ap netip.AddrPort, err error) {
+	if uc, ok := s.conn.(*net.UDPConn); ok {
+		n, ap, err = uc.ReadFromUDPAddrPort(buf)
+		return n, nil, ap, err
+	}
+	n, addr, err = s.conn.ReadFrom(buf)
+	return n, addr, ap, err
+}
+
+// writeTo sends b to the sender readFrom returned.
+func (s *Server) writeTo(b []byte, addr net.Addr, ap netip.AddrPort) error {
+	var err error
+	if addr == nil {
+		_, err = s.conn.(*net.UDPConn).WriteToUDPAddrPort(b, ap)
+	} else {
+		_, err = s.conn.WriteTo(b, addr)
+	}
+	return err
+}
+
+// serveTrace is the span of one request being answered; a nil
+// *serveTrace traces nothing.
+type serveTrace struct {
+	tracer ntp.Tracer
+	ctx    context.Context
+	span   ntp.Span
+	read   time.Time
+	sent   time.Time // when the reply was ready to send
+}
+
+// startServe starts the span of a request from addr, or ap if addr is
+// nil, read at read, if cfg has a Tracer.
+func (cfg *Config) startServe(read time.Time, addr net.Addr, ap netip.AddrPort) *serveTrace {
+	if cfg.Tracer == nil {
+		return nil
+	}
+	ctx, span := cfg.Tracer.Start(context.Background(), "ntp.serve", read)
+	if addr != nil {
+		span.SetAttribute("ntp.client", addr.String())
+	} else {
+		span.SetAttribute("ntp.client", ap.String())
+	}
+	return &serveTrace{tracer: cfg.Tracer, ctx: ctx, span: span, read: read}
+}
+
+// decoded ends the decode stage, which covers building the reply.
+func (t *serveTrace) decoded(stratum uint8) {
+	if t == nil {
+		return
+	}
+	t.sent = time.Now()
+	t.span.SetAttribute("ntp.stratum", int64(stratum))
+	_, s := t.tracer.Start(t.ctx, "ntp.decode", t.read)
+	s.End(t.sent, nil)
+}
+
+// end ends the span once the reply has been written, or dropped by
+// Mangle if the send stage never began.
+func (t *serveTrace) end(err error) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	if !t.sent.IsZero() {
+		_, s := t.tracer.Start(t.ctx, "ntp.send", t.sent)
+		s.End(now, err)
+	}
+	t.span.End(now, err)
+}
+
+// reply builds the answer to req received at rx, apart from the
+// transmit timestamp.
+func (cfg *Config) reply(req *ntp.DataPacket, rx time.Time) ntp.DataPacket {
+	resp := ntp.DataPacket{
+		Byte1:               cfg.Leap&3<<6 | req.Byte1&0x38 | 4,
+		Stratum:             cfg.Stratum,
+		Poll:                cfg.Poll,
+		Precision:           cfg.Precision,
+		RootDelay:           ntp.NewNTPShort(cfg.RootDelay),
+		RootDispersion:      ntp.NewNTPShort(cfg.RootDispersion),
+		ReferenceIdentifier: cfg.RefID,
+		ReferenceTimeStamp:  ntp.NewNTPTime(rx.Add(-time.Second)),
+		OriginateTimeStamp:  req.TransmitTimeStamp,
+		ReceiveTimeStamp:    ntp.NewNTPTime(rx),
+	}
+	if resp.Stratum == 0 {
+		resp.Stratum = 2
+	}
+	if resp.ReferenceIdentifier == 0 {
+		resp.ReferenceIdentifier = 0x7f000001
+	}
+	if resp.Precision == 0 {
+		resp.Precision = -20
+	}
+	if cfg.KoD != "" {
+		var code [4]byte
+		copy(code[:], cfg.KoD)
+		resp.Byte1 = 3<<6 | resp.Byte1&0x3f
+		resp.Stratum = 0
+		resp.ReferenceIdentifier = uint32(code[0])<<24 | uint32(code[1])<<16 | uint32(code[2])<<8 | uint32(code[3])
+	}
+	return resp
+}
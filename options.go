@@ -0,0 +1,125 @@
+package ntp
+
+import (
+	"net"
+	"strconv"
+	"time"
+)
+
+// Option adjusts a single Get or QueryContext call, so that callers
+// need not build a DataPacket or know the layout of Byte1.
+type Option func(*options)
+
+// options is the result of applying Options. The zero value is the
+// default query: version 4 to port 123 with no timeout beyond the
+// context's.
+type options struct {
+	version   int
+	port      int
+	timeout   time.Duration
+	ttl       int
+	localAddr *net.UDPAddr
+	network   string
+}
+
+// WithVersion sets the NTP version of the request (1-4, default 4).
+func WithVersion(v int) Option {
+	return func(o *options) { o.version = v }
+}
+
+// WithPort sends the request to port instead of 123 or the port given
+// with the server name.
+func WithPort(port int) Option {
+	return func(o *options) { o.port = port }
+}
+
+// WithTimeout bounds the query, including the dial, to d.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) { o.timeout = d }
+}
+
+// WithTTL sets the IP TTL (IPv6 hop limit) of the request, 1-255.
+// LocalOnly takes precedence.
+func WithTTL(ttl int) Option {
+	return func(o *options) { o.ttl = ttl }
+}
+
+// WithLocalAddr sends the request from addr, e.g. to pick the source
+// address on a multihomed host or a fixed source port.
+func WithLocalAddr(addr *net.UDPAddr) Option {
+	return func(o *options) { o.localAddr = addr }
+}
+
+// WithNetwork restricts the query to one address family: "udp4" or
+// "udp6". The default, "udp", takes the first address the server name
+// resolves to, whatever its family.
+func WithNetwork(network string) Option {
+	return func(o *options) { o.network = network }
+}
+
+var (
+	errOptNetwork = newError(NTP_ERR_CONFIG, `ntp: network must be "udp", "udp4" or "udp6"`)
+	errOptVersion = newError(NTP_ERR_CONFIG, "ntp: version must be between 1 and 4")
+	errOptPort    = newError(NTP_ERR_CONFIG, "ntp: port must be between 1 and 65535")
+	errOptTTL     = newError(NTP_ERR_CONFIG, "ntp: TTL must be between 1 and 255")
+)
+
+func applyOptions(opts []Option) (*options, error) {
+	o := &options{version: 4}
+	if err := envOptions(o); err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	switch {
+	case o.version < 1 || o.version > 4:
+		return nil, errOptVersion
+	case o.port < 0 || o.port > 65535:
+		return nil, errOptPort
+	case o.ttl < 0 || o.ttl > 255:
+		return nil, errOptTTL
+	case o.network != "" && o.network != "udp" && o.network != "udp4" && o.network != "udp6":
+		return nil, errOptNetwork
+	}
+	return o, nil
+}
+
+// address returns where to send queries for server: a name or an IP
+// address (IPv6 addresses bracketed or not), optionally with a port as
+// in "host:1123" or "[::1]:1123". WithPort overrides that port; 123
+// is the default.
+func (o *options) address(server string) string {
+	port := "123"
+	if h, p, err := net.SplitHostPort(server); err == nil {
+		server, port = h, p
+	}
+	if o != nil && o.port != 0 {
+		port = strconv.Itoa(o.port)
+	}
+	if len(server) > 1 && server[0] == '[' && server[len(server)-1] == ']' {
+		server = server[1 : len(server)-1]
+	}
+	return net.JoinHostPort(server, port)
+}
+
+// udp returns the network to dial.
+func (o *options) udp() string {
+	if o == nil || o.network == "" {
+		return "udp"
+	}
+	return o.network
+}
+
+// key names the kept socket for server under ReuseConn; queries whose
+// options change how the socket is dialed get a socket of their own.
+func (o *options) key(server string) string {
+	if o == nil || (o.port == 0 && o.ttl == 0 && o.localAddr == nil && o.network == "") {
+		return server
+	}
+	k := o.address(server) + " " + o.udp() + " ttl " + strconv.Itoa(o.ttl)
+	if o.localAddr != nil {
+		k += " from " + o.localAddr.String()
+	}
+	return k
+}
@@ -0,0 +1,69 @@
+package ntp
+
+import (
+	"net"
+	"net/netip"
+	"time"
+)
+
+// sharedConn presents one server's traffic on the Client's PacketConn
+// as a connected socket. The Client holds pcMu from dial until Close,
+// so only one exchange uses the PacketConn at a time and no other
+// reader can take its reply.
+type sharedConn struct {
+	c    *Client
+	pc   net.PacketConn
+	addr net.Addr
+	peer netip.AddrPort
+}
+
+// dialShared resolves addr and locks the Client's PacketConn for one
+// exchange with it.
+func (c *Client) dialShared(addr, network string) (net.Conn, error) {
+	ua, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	c.pcMu.Lock()
+	return &sharedConn{c: c, pc: c.PacketConn, addr: ua, peer: ua.AddrPort()}, nil
+}
+
+func (s *sharedConn) Write(b []byte) (int, error) {
+	return s.pc.WriteTo(b, s.addr)
+}
+
+// Read returns the next datagram from the server, dropping any from
+// other addresses.
+func (s *sharedConn) Read(b []byte) (int, error) {
+	for {
+		n, from, err := s.pc.ReadFrom(b)
+		if err != nil {
+			return n, err
+		}
+		if ua, ok := from.(*net.UDPAddr); !ok || sameAddr(ua.AddrPort(), s.peer) {
+			return n, nil
+		}
+	}
+}
+
+func sameAddr(a, b netip.AddrPort) bool {
+	return a.Addr().Unmap() == b.Addr().Unmap() && a.Port() == b.Port()
+}
+
+// Close releases the PacketConn for the next exchange; the PacketConn
+// itself stays open.
+func (s *sharedConn) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	s.pc.SetDeadline(time.Time{})
+	s.c.pcMu.Unlock()
+	s.c = nil
+	return nil
+}
+
+func (s *sharedConn) LocalAddr() net.Addr                { return s.pc.LocalAddr() }
+func (s *sharedConn) RemoteAddr() net.Addr               { return s.addr }
+func (s *sharedConn) SetDeadline(t time.Time) error      { return s.pc.SetDeadline(t) }
+func (s *sharedConn) SetReadDeadline(t time.Time) error  { return s.pc.SetReadDeadline(t) }
+func (s *sharedConn) SetWriteDeadline(t time.Time) error { return s.pc.SetWriteDeadline(t) }
@@ -0,0 +1,65 @@
+package ntp
+
+// PeerStat is one entry in a server's history as kept by a Client with
+// PeerStatsDepth set: the sample a reply gave and the stratum the
+// server gave with it.
+type PeerStat struct {
+	Sample
+	Stratum uint8
+}
+
+// peerRing holds the latest entries of one server's history, next being
+// where the next one goes once it is full.
+type peerRing struct {
+	buf  []PeerStat
+	next int
+}
+
+// notePeerStat adds r to server's history if c keeps one.
+func (c *Client) notePeerStat(server string, r *Response) {
+	depth := c.PeerStatsDepth
+	if depth <= 0 {
+		return
+	}
+	st := PeerStat{Sample: SampleOf(r), Stratum: r.Stratum}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.peers == nil {
+		c.peers = make(map[string]*peerRing)
+	}
+	ring := c.peers[server]
+	if ring == nil {
+		ring = &peerRing{}
+		c.peers[server] = ring
+	}
+	if len(ring.buf) != depth && ring.next != 0 {
+		// PeerStatsDepth changed since the ring filled; put it back in
+		// order before growing or trimming it.
+		ring.buf = append(ring.buf[ring.next:], ring.buf[:ring.next]...)
+		ring.next = 0
+	}
+	if len(ring.buf) < depth {
+		ring.buf = append(ring.buf, st)
+		return
+	}
+	if len(ring.buf) > depth {
+		ring.buf = append(ring.buf[:0], ring.buf[len(ring.buf)-depth:]...)
+	}
+	ring.buf[ring.next] = st
+	ring.next = (ring.next + 1) % depth
+}
+
+// PeerStats returns the history kept of server's replies, oldest first,
+// up to PeerStatsDepth entries; server is the name as given to the
+// queries. It is empty unless PeerStatsDepth is set.
+func (c *Client) PeerStats(server string) []PeerStat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ring := c.peers[server]
+	if ring == nil {
+		return nil
+	}
+	out := make([]PeerStat, 0, len(ring.buf))
+	out = append(out, ring.buf[ring.next:]...)
+	return append(out, ring.buf[:ring.next]...)
+}
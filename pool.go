@@ -0,0 +1,193 @@
+package ntp
+
+import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// DefaultPoolTTL is how long a Pool uses one resolution of its name.
+// The standard library does not expose DNS record TTLs; pool.ntp.org
+// publishes its records with TTLs of a few minutes.
+const DefaultPoolTTL = 5 * time.Minute
+
+// poolRetry is how soon a Pool tries again after failing to re-resolve
+// its name, meanwhile carrying on with the members it had.
+const poolRetry = 30 * time.Second
+
+// Pool spreads queries across the members of a pool name such as
+// pool.ntp.org, which resolves to a different handful of servers every
+// few minutes. It resolves the name itself, hands out its addresses
+// in turn, re-resolves once TTL has passed, and sets aside members
+// that failed to answer until the next resolution, so that one dead or
+// rate-limiting member is not asked again and again. Dialing the name
+// directly would instead pick one address and keep it for as long as
+// the socket lives. The zero value is not usable; set Name.
+type Pool struct {
+	// Name is the pool's host name, optionally with a port.
+	Name string
+	// Client sends the queries; a new Client when nil. Its LookupHost,
+	// if set, resolves Name.
+	Client *Client
+	// TTL is how long one resolution is used (default DefaultPoolTTL).
+	TTL time.Duration
+
+	once    sync.Once
+	client  *Client
+	mu      sync.Mutex
+	members []netip.AddrPort
+	next    int
+	expires time.Time
+	failed  map[netip.AddrPort]bool
+}
+
+var errNoMembers = newError(NTP_ERR_RESOLVE, "ntp: pool name has no addresses")
+
+// Members returns up to n distinct members of the pool (all of them if
+// n <= 0), taking turns with Get and QueryMulti, as "address:port"
+// strings for a Client. Members that failed come last. It resolves the
+// name if it has not yet or if TTL has passed.
+func (p *Pool) Members(ctx context.Context, n int) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.refresh(ctx); err != nil {
+		return nil, err
+	}
+	var out []string
+	last := -1
+	// Healthy members first, then those that failed, each in turn; the
+	// next call starts after the last healthy one handed out.
+	for _, wantFailed := range []bool{false, true} {
+		for i := 0; i < len(p.members); i++ {
+			j := (p.next + i) % len(p.members)
+			if p.failed[p.members[j]] == wantFailed && (n <= 0 || len(out) < n) {
+				out = append(out, p.members[j].String())
+				if !wantFailed || last < 0 {
+					last = j
+				}
+			}
+		}
+	}
+	p.next = (last + 1) % len(p.members)
+	return out, nil
+}
+
+// Get queries the next healthy member of the pool, as Client.Get would
+// query a single server.
+func (p *Pool) Get(ctx context.Context, opts ...Option) (*Response, error) {
+	ms, err := p.Members(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	r, err := p.c().QueryContext(ctx, ms[0], opts...)
+	p.note(ms[0], err)
+	return r, err
+}
+
+// QueryMulti queries n distinct members of the pool at once, as
+// Client.QueryMulti does.
+func (p *Pool) QueryMulti(ctx context.Context, n int, opts ...Option) (*MultiResult, error) {
+	ms, err := p.Members(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	m, err := p.c().QueryMulti(ctx, ms, opts...)
+	if m != nil {
+		for _, sr := range m.Servers {
+			p.note(sr.Server, sr.Err)
+		}
+	}
+	return m, err
+}
+
+func (p *Pool) c() *Client {
+	p.once.Do(func() {
+		p.client = p.Client
+		if p.client == nil {
+			p.client = new(Client)
+		}
+	})
+	return p.client
+}
+
+// note sets member aside if its query failed for a reason other than
+// the caller giving up.
+func (p *Pool) note(member string, err error) {
+	if err == nil || CodeOf(err) == NTP_ERR_CANCELED {
+		return
+	}
+	ap, perr := netip.ParseAddrPort(member)
+	if perr != nil {
+		return
+	}
+	p.mu.Lock()
+	if p.failed == nil {
+		p.failed = make(map[netip.AddrPort]bool)
+	}
+	p.failed[ap] = true
+	p.mu.Unlock()
+	p.c().infof("pool %s: setting %s aside until the name is resolved again: %v\n", p.Name, member, err)
+}
+
+// refresh resolves the pool's name if needed. The caller holds p.mu.
+func (p *Pool) refresh(ctx context.Context) error {
+	now := p.c().now()
+	if len(p.members) > 0 && now.Before(p.expires) {
+		return nil
+	}
+	host, port, err := net.SplitHostPort((*options)(nil).address(p.Name))
+	if err != nil {
+		return &Error{Code: NTP_ERR_CONFIG, Err: err}
+	}
+	pn, err := net.LookupPort("udp", port)
+	if err != nil {
+		return &Error{Code: NTP_ERR_CONFIG, Err: err}
+	}
+	var ips []netip.Addr
+	if c := p.c(); c.LookupHost != nil {
+		ips, err = c.LookupHost(ctx, host)
+	} else {
+		ips, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	}
+	if err == nil && len(ips) == 0 {
+		err = errNoMembers
+	}
+	if err != nil {
+		if len(p.members) > 0 {
+			// Keep the members we have rather than fail every query
+			// while DNS is down.
+			p.expires = now.Add(poolRetry)
+			p.c().logf("pool %s: keeping %d members after failing to resolve: %v\n", p.Name, len(p.members), err)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == errNoMembers {
+			return err
+		}
+		return &Error{Code: NTP_ERR_RESOLVE, Err: err}
+	}
+	p.members = p.members[:0]
+	seen := make(map[netip.Addr]bool, len(ips))
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		if !seen[ip] {
+			seen[ip] = true
+			p.members = append(p.members, netip.AddrPortFrom(ip, uint16(pn)))
+		}
+	}
+	// Start somewhere random, so that clients sharing a resolver do
+	// not all begin with the same member.
+	p.next = rand.IntN(len(p.members))
+	p.failed = nil
+	ttl := p.TTL
+	if ttl <= 0 {
+		ttl = DefaultPoolTTL
+	}
+	p.expires = now.Add(ttl)
+	return nil
+}
@@ -0,0 +1,222 @@
+package ptp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Message types (the low nibble of the first header byte).
+const (
+	typeSync      = 0x0
+	typeDelayReq  = 0x1
+	typeFollowUp  = 0x8
+	typeDelayResp = 0x9
+	typeAnnounce  = 0xb
+)
+
+// Message lengths, header included.
+const (
+	headerLen    = 34
+	syncLen      = headerLen + 10
+	delayRespLen = headerLen + 10 + 10
+	announceLen  = headerLen + 30
+)
+
+const ptpVersion = 2
+
+// Flag bits, as a big-endian uint16 of the flagField.
+const (
+	flagTwoStep        = 0x0200
+	flagUTCOffsetValid = 0x0004
+	flagPTPTimescale   = 0x0008
+)
+
+var errShort = errors.New("ptp: message too short")
+
+// ClockIdentity is the EUI-64 naming a PTP clock.
+type ClockIdentity [8]byte
+
+func (c ClockIdentity) String() string {
+	return fmt.Sprintf("%02x%02x%02x.%02x%02x.%02x%02x%02x", c[0], c[1], c[2], c[3], c[4], c[5], c[6], c[7])
+}
+
+// PortIdentity names one port of a PTP clock.
+type PortIdentity struct {
+	Clock ClockIdentity
+	Port  uint16
+}
+
+func (p PortIdentity) String() string {
+	return fmt.Sprintf("%v-%d", p.Clock, p.Port)
+}
+
+// header is the common header of every PTP message.
+type header struct {
+	msgType     uint8
+	length      uint16
+	domain      uint8
+	flags       uint16
+	correction  int64 // nanoseconds * 2^16
+	source      PortIdentity
+	sequence    uint16
+	logInterval int8
+}
+
+func (h *header) decode(b []byte) error {
+	if len(b) < headerLen {
+		return errShort
+	}
+	if b[1]&0x0f != ptpVersion {
+		return fmt.Errorf("ptp: unsupported version %d", b[1]&0x0f)
+	}
+	h.msgType = b[0] & 0x0f
+	h.length = binary.BigEndian.Uint16(b[2:])
+	h.domain = b[4]
+	h.flags = binary.BigEndian.Uint16(b[6:])
+	h.correction = int64(binary.BigEndian.Uint64(b[8:]))
+	copy(h.source.Clock[:], b[20:28])
+	h.source.Port = binary.BigEndian.Uint16(b[28:])
+	h.sequence = binary.BigEndian.Uint16(b[30:])
+	h.logInterval = int8(b[33])
+	if int(h.length) > len(b) {
+		return errShort
+	}
+	return nil
+}
+
+func (h *header) encode(b []byte, control byte) {
+	b[0] = h.msgType
+	b[1] = ptpVersion
+	binary.BigEndian.PutUint16(b[2:], h.length)
+	b[4] = h.domain
+	b[5] = 0
+	binary.BigEndian.PutUint16(b[6:], h.flags)
+	binary.BigEndian.PutUint64(b[8:], uint64(h.correction))
+	clear(b[16:20])
+	copy(b[20:28], h.source.Clock[:])
+	binary.BigEndian.PutUint16(b[28:], h.source.Port)
+	binary.BigEndian.PutUint16(b[30:], h.sequence)
+	b[32] = control
+	b[33] = byte(h.logInterval)
+}
+
+// correctionDuration returns the correction field in nanoseconds,
+// dropping the sub-nanosecond part.
+func (h *header) correctionDuration() time.Duration {
+	return time.Duration(h.correction >> 16)
+}
+
+// Timestamps are 48 bits of seconds and 32 bits of nanoseconds since
+// the PTP epoch, 1970-01-01 00:00:00 TAI.
+func decodeTimestamp(b []byte) time.Time {
+	sec := int64(binary.BigEndian.Uint16(b))<<32 | int64(binary.BigEndian.Uint32(b[2:]))
+	return time.Unix(sec, int64(binary.BigEndian.Uint32(b[6:]))).UTC()
+}
+
+func encodeTimestamp(b []byte, t time.Time) {
+	sec := t.Unix()
+	binary.BigEndian.PutUint16(b, uint16(sec>>32))
+	binary.BigEndian.PutUint32(b[2:], uint32(sec))
+	binary.BigEndian.PutUint32(b[6:], uint32(t.Nanosecond()))
+}
+
+// ClockQuality is a grandmaster's advertised quality.
+type ClockQuality struct {
+	Class    uint8
+	Accuracy uint8
+	// Variance is the offsetScaledLogVariance.
+	Variance uint16
+}
+
+// Announce is what a master advertises about its grandmaster.
+type Announce struct {
+	Source PortIdentity
+	// Addr is the IP address the announcement came from.
+	Addr        string
+	Priority1   uint8
+	Quality     ClockQuality
+	Priority2   uint8
+	Grandmaster ClockIdentity
+	// StepsRemoved is the number of boundary clocks between the
+	// grandmaster and the sender.
+	StepsRemoved uint16
+	TimeSource   uint8
+	// UTCOffset is TAI-UTC in seconds, valid when UTCOffsetValid is
+	// set.
+	UTCOffset      int
+	UTCOffsetValid bool
+	PTPTimescale   bool
+	// Interval is the announced time between Announce messages.
+	Interval time.Duration
+}
+
+func decodeAnnounce(h *header, b []byte) (*Announce, error) {
+	if len(b) < announceLen {
+		return nil, errShort
+	}
+	return &Announce{
+		Source:         h.source,
+		UTCOffset:      int(int16(binary.BigEndian.Uint16(b[44:]))),
+		Priority1:      b[47],
+		Quality:        ClockQuality{Class: b[48], Accuracy: b[49], Variance: binary.BigEndian.Uint16(b[50:])},
+		Priority2:      b[52],
+		Grandmaster:    ClockIdentity(b[53:61]),
+		StepsRemoved:   binary.BigEndian.Uint16(b[61:]),
+		TimeSource:     b[63],
+		UTCOffsetValid: h.flags&flagUTCOffsetValid != 0,
+		PTPTimescale:   h.flags&flagPTPTimescale != 0,
+		Interval:       logInterval(h.logInterval),
+	}, nil
+}
+
+// decodeDelayResp decodes a Delay_Resp of at least delayRespLen bytes
+// with header h.
+func decodeDelayResp(h *header, b []byte) delayRespMsg {
+	m := delayRespMsg{h: *h, receive: decodeTimestamp(b[headerLen:])}
+	copy(m.requesting.Clock[:], b[headerLen+10:])
+	m.requesting.Port = binary.BigEndian.Uint16(b[headerLen+18:])
+	return m
+}
+
+// logInterval converts a log2-seconds message interval.
+func logInterval(l int8) time.Duration {
+	if l >= 0 {
+		return time.Second << l
+	}
+	return time.Second >> -l
+}
+
+// better reports whether a describes a better master than b, by the
+// dataset comparison of IEEE 1588-2008 clause 9.3.4: priority1, clock
+// class, accuracy, variance, priority2 and grandmaster identity, and
+// for the same grandmaster the shorter path.
+func better(a, b *Announce) bool {
+	if a.Grandmaster == b.Grandmaster {
+		if a.StepsRemoved != b.StepsRemoved {
+			return a.StepsRemoved < b.StepsRemoved
+		}
+		return lessIdentity(a.Source, b.Source)
+	}
+	switch {
+	case a.Priority1 != b.Priority1:
+		return a.Priority1 < b.Priority1
+	case a.Quality.Class != b.Quality.Class:
+		return a.Quality.Class < b.Quality.Class
+	case a.Quality.Accuracy != b.Quality.Accuracy:
+		return a.Quality.Accuracy < b.Quality.Accuracy
+	case a.Quality.Variance != b.Quality.Variance:
+		return a.Quality.Variance < b.Quality.Variance
+	case a.Priority2 != b.Priority2:
+		return a.Priority2 < b.Priority2
+	}
+	return string(a.Grandmaster[:]) < string(b.Grandmaster[:])
+}
+
+func lessIdentity(a, b PortIdentity) bool {
+	if a.Clock != b.Clock {
+		return string(a.Clock[:]) < string(b.Clock[:])
+	}
+	return a.Port < b.Port
+}
@@ -0,0 +1,500 @@
+// Package ptp is a minimal IEEE 1588-2008 (PTPv2) ordinary clock in
+// the slave-only role, over UDP/IPv4 multicast. It listens for Announce
+// messages, picks a master with the best master clock algorithm, and
+// measures the offset to it with the Sync, Follow_Up, Delay_Req and
+// Delay_Resp exchange.
+//
+// Measurements follow the conventions of the NTP client: T1 to T4 are
+// the four exchange timestamps in UTC, Offset is positive when the
+// master is ahead of the local clock and Delay is the round trip, so
+// the same filtering and clock discipline code can consume either.
+//
+// Binding the PTP ports (319 and 320) needs privileges on most systems.
+// Receive and transmit timestamps come from the kernel where package
+// ntp supports it, otherwise from the system clock; either way the
+// accuracy is that of software timestamping, not of a PTP hardware
+// clock.
+package ptp
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+	"github.com/chaitanyav/ntp/internal/sockts"
+	"github.com/chaitanyav/ntp/timescale"
+)
+
+// The PTP UDP ports: event messages, which are timestamped, and
+// general messages, which are not.
+const (
+	EventPort   = 319
+	GeneralPort = 320
+)
+
+// Group is the multicast group for all PTP messages except peer delay.
+var Group = net.IPv4(224, 0, 1, 129)
+
+// announceTimeout is how many announce intervals a master may stay
+// silent before it is dropped, the default announceReceiptTimeout.
+const announceTimeout = 3
+
+var (
+	errNoMaster = &ntp.Error{Code: ntp.NTP_ERR_TIMEOUT, Err: errors.New("ptp: no master announced")}
+	errClosed   = &ntp.Error{Code: ntp.NTP_ERR_CLOSED, Err: errors.New("ptp: client closed")}
+)
+
+// Config controls a Client.
+type Config struct {
+	// Interface is the network interface to listen on; empty lets the
+	// system choose. Delay_Req messages leave by the route to the
+	// multicast group, which should lead out of the same interface.
+	Interface string
+	// Domain is the PTP domain number (default 0).
+	Domain uint8
+}
+
+// Measurement is one completed exchange with the selected master.
+type Measurement struct {
+	Master      PortIdentity
+	Grandmaster ClockIdentity
+	Addr        string
+
+	// T1 is when the master sent Sync and T2 when it arrived; T3 is
+	// when Delay_Req was sent and T4 when the master received it. T1
+	// and T4 include the correction fields and are converted from the
+	// PTP timescale to UTC.
+	T1, T2, T3, T4 time.Time
+
+	Offset time.Duration
+	Delay  time.Duration
+	// UTCOffset is the TAI-UTC offset used for the conversion.
+	UTCOffset int
+}
+
+// Sample returns the measurement as an ntp.Sample for an ntp.Filter:
+// its offset and delay, a dispersion of PHI times the delay, taken at
+// T2.
+func (m *Measurement) Sample() ntp.Sample {
+	return ntp.Sample{
+		Offset:     m.Offset,
+		Delay:      m.Delay,
+		Dispersion: time.Duration(ntp.PHI * float64(m.Delay)),
+		Time:       m.T2,
+	}
+}
+
+// Client is a PTP slave listening on the PTP ports until Close.
+type Client struct {
+	cfg     Config
+	id      PortIdentity
+	event   *net.UDPConn
+	general *net.UDPConn
+	// out sends Delay_Req: the listening sockets are bound to the
+	// group address, which cannot be the source of a datagram.
+	out *net.UDPConn
+
+	syncs      chan syncMsg
+	followUps  chan followUpMsg
+	delayResps chan delayRespMsg
+	done       chan struct{}
+	wg         sync.WaitGroup
+
+	mu      sync.Mutex
+	masters map[PortIdentity]*foreign
+	seq     uint16
+	buf     [syncLen]byte
+}
+
+type foreign struct {
+	ann  Announce
+	seen time.Time
+}
+
+type syncMsg struct {
+	h      header
+	origin time.Time
+	rx     time.Time
+}
+
+type followUpMsg struct {
+	h       header
+	precise time.Time
+}
+
+type delayRespMsg struct {
+	h          header
+	receive    time.Time
+	requesting PortIdentity
+}
+
+// Listen joins the PTP multicast group on both ports and starts
+// collecting announcements.
+func Listen(cfg Config) (*Client, error) {
+	var ifi *net.Interface
+	if cfg.Interface != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(cfg.Interface); err != nil {
+			return nil, &ntp.Error{Code: ntp.NTP_ERR_CONFIG, Err: err}
+		}
+	}
+	event, err := net.ListenMulticastUDP("udp4", ifi, &net.UDPAddr{IP: Group, Port: EventPort})
+	if err != nil {
+		return nil, &ntp.Error{Code: ntp.CodeOf(err), Err: err}
+	}
+	general, err := net.ListenMulticastUDP("udp4", ifi, &net.UDPAddr{IP: Group, Port: GeneralPort})
+	if err != nil {
+		event.Close()
+		return nil, &ntp.Error{Code: ntp.CodeOf(err), Err: err}
+	}
+	out, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		event.Close()
+		general.Close()
+		return nil, &ntp.Error{Code: ntp.CodeOf(err), Err: err}
+	}
+	// Kernel timestamps are an improvement, not a requirement.
+	sockts.Enable(event, false, true, false)
+	sockts.Enable(out, false, false, true)
+
+	c := &Client{
+		cfg:        cfg,
+		id:         PortIdentity{Clock: clockIdentity(ifi), Port: 1},
+		event:      event,
+		general:    general,
+		out:        out,
+		syncs:      make(chan syncMsg, 4),
+		followUps:  make(chan followUpMsg, 4),
+		delayResps: make(chan delayRespMsg, 4),
+		done:       make(chan struct{}),
+		masters:    map[PortIdentity]*foreign{},
+	}
+	c.wg.Add(2)
+	go c.readEvent()
+	go c.readGeneral()
+	return c, nil
+}
+
+// clockIdentity derives an EUI-64 from the interface's MAC address, or
+// makes up a random one if there is none.
+func clockIdentity(ifi *net.Interface) ClockIdentity {
+	var id ClockIdentity
+	if ifi == nil {
+		if ifs, err := net.Interfaces(); err == nil {
+			for i := range ifs {
+				if ifs[i].Flags&net.FlagLoopback == 0 && len(ifs[i].HardwareAddr) == 6 {
+					ifi = &ifs[i]
+					break
+				}
+			}
+		}
+	}
+	if ifi != nil && len(ifi.HardwareAddr) == 6 {
+		mac := ifi.HardwareAddr
+		return ClockIdentity{mac[0], mac[1], mac[2], 0xff, 0xfe, mac[3], mac[4], mac[5]}
+	}
+	rand.Read(id[:])
+	return id
+}
+
+// Identity returns the port identity the client sends Delay_Req with.
+func (c *Client) Identity() PortIdentity {
+	return c.id
+}
+
+// Close leaves the group and stops the client.
+func (c *Client) Close() error {
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	close(c.done)
+	err := c.event.Close()
+	c.general.Close()
+	c.out.Close()
+	c.wg.Wait()
+	return err
+}
+
+func (c *Client) readEvent() {
+	defer c.wg.Done()
+	var buf [1500]byte
+	for {
+		n, rx, err := sockts.ReadRX(c.event, buf[:], nil)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		var h header
+		if h.decode(buf[:n]) != nil || h.domain != c.cfg.Domain || h.msgType != typeSync || n < syncLen {
+			continue
+		}
+		m := syncMsg{h: h, origin: decodeTimestamp(buf[headerLen:]), rx: rx}
+		select {
+		case c.syncs <- m:
+		default:
+		}
+	}
+}
+
+func (c *Client) readGeneral() {
+	defer c.wg.Done()
+	var buf [1500]byte
+	for {
+		n, addr, err := c.general.ReadFromUDP(buf[:])
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		b := buf[:n]
+		var h header
+		if h.decode(b) != nil || h.domain != c.cfg.Domain {
+			continue
+		}
+		switch h.msgType {
+		case typeAnnounce:
+			if a, err := decodeAnnounce(&h, b); err == nil && a.StepsRemoved < 255 {
+				c.mu.Lock()
+				a.Addr = addr.IP.String()
+				c.masters[a.Source] = &foreign{ann: *a, seen: time.Now()}
+				c.mu.Unlock()
+			}
+		case typeFollowUp:
+			if n < syncLen {
+				continue
+			}
+			select {
+			case c.followUps <- followUpMsg{h: h, precise: decodeTimestamp(b[headerLen:])}:
+			default:
+			}
+		case typeDelayResp:
+			if n < delayRespLen {
+				continue
+			}
+			select {
+			case c.delayResps <- decodeDelayResp(&h, b):
+			default:
+			}
+		}
+	}
+}
+
+// Masters returns the masters heard from recently, best first.
+func (c *Client) Masters() []Announce {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(time.Now())
+	out := make([]Announce, 0, len(c.masters))
+	for _, f := range c.masters {
+		out = append(out, f.ann)
+	}
+	for i := 1; i < len(out); i++ {
+		for j := i; j > 0 && better(&out[j], &out[j-1]); j-- {
+			out[j], out[j-1] = out[j-1], out[j]
+		}
+	}
+	return out
+}
+
+// best returns the master chosen by the best master clock algorithm.
+func (c *Client) best() (Announce, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(time.Now())
+	var b *Announce
+	for _, f := range c.masters {
+		if b == nil || better(&f.ann, b) {
+			b = &f.ann
+		}
+	}
+	if b == nil {
+		return Announce{}, false
+	}
+	return *b, true
+}
+
+// expire drops masters that have stopped announcing. The caller holds
+// c.mu.
+func (c *Client) expire(now time.Time) {
+	for id, f := range c.masters {
+		if now.Sub(f.seen) > announceTimeout*f.ann.Interval {
+			delete(c.masters, id)
+		}
+	}
+}
+
+// Measure waits for the next Sync from the selected master, then
+// measures the path delay to it with a Delay_Req. It fails if ctx is
+// done first; bound it with a deadline of a few sync intervals.
+func (c *Client) Measure(ctx context.Context) (*Measurement, error) {
+	for {
+		m, err := c.measure(ctx)
+		if err != errRetry {
+			return m, err
+		}
+	}
+}
+
+var errRetry = errors.New("retry")
+
+func (c *Client) measure(ctx context.Context) (*Measurement, error) {
+	s, err := c.nextSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	master, ok := c.best()
+	if !ok || s.h.source != master.Source {
+		return nil, errRetry
+	}
+	t1 := s.origin
+	corr := s.h.correctionDuration()
+	if s.h.flags&flagTwoStep != 0 {
+		fu, err := c.followUp(ctx, &s.h)
+		if err != nil {
+			return nil, err
+		}
+		if fu == nil {
+			return nil, errRetry
+		}
+		t1 = fu.precise
+		corr += fu.h.correctionDuration()
+	}
+	t1 = t1.Add(corr)
+
+	seq, t3, err := c.sendDelayReq()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.delayResp(ctx, seq, master.Source)
+	if err != nil {
+		return nil, err
+	}
+	t4 := resp.receive.Add(-resp.h.correctionDuration())
+	if t, ok := sockts.ReadTX(c.out, nil); ok {
+		t3 = t
+	}
+
+	// An ARB timescale master sends UTC already. A PTP timescale one
+	// sends TAI, with TAI-UTC if it knows it; otherwise the leap second
+	// table has to do.
+	utcOffset := 0
+	if master.PTPTimescale {
+		utcOffset = master.UTCOffset
+		if !master.UTCOffsetValid {
+			utcOffset = int(t1.Sub(timescale.FromTAI(t1)) / time.Second)
+		}
+		shift := -time.Duration(utcOffset) * time.Second
+		t1, t4 = t1.Add(shift), t4.Add(shift)
+	}
+	offset, delay := offsetDelay(t1, s.rx, t3, t4)
+	return &Measurement{
+		Master:      master.Source,
+		Grandmaster: master.Grandmaster,
+		Addr:        master.Addr,
+		T1:          t1,
+		T2:          s.rx,
+		T3:          t3,
+		T4:          t4,
+		Offset:      offset,
+		Delay:       delay,
+		UTCOffset:   utcOffset,
+	}, nil
+}
+
+// offsetDelay returns the master's offset from the local clock and the
+// round trip of an exchange, assuming a symmetric path.
+func offsetDelay(t1, t2, t3, t4 time.Time) (offset, delay time.Duration) {
+	return (t1.Sub(t2) + t4.Sub(t3)) / 2, t2.Sub(t1) + t4.Sub(t3)
+}
+
+// wait returns a coded error for the end of ctx or of the client.
+func (c *Client) wait(ctx context.Context) error {
+	select {
+	case <-c.done:
+		return errClosed
+	default:
+	}
+	if _, ok := c.best(); !ok && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errNoMaster
+	}
+	return &ntp.Error{Code: ntp.CodeOf(ctx.Err()), Err: ctx.Err()}
+}
+
+func (c *Client) nextSync(ctx context.Context) (syncMsg, error) {
+	select {
+	case s := <-c.syncs:
+		return s, nil
+	case <-ctx.Done():
+		return syncMsg{}, c.wait(ctx)
+	case <-c.done:
+		return syncMsg{}, errClosed
+	}
+}
+
+// followUp waits for the Follow_Up to the Sync with header h. It returns
+// nil if a later Sync shows that the Follow_Up was lost.
+func (c *Client) followUp(ctx context.Context, h *header) (*followUpMsg, error) {
+	for {
+		select {
+		case fu := <-c.followUps:
+			if fu.h.source == h.source && fu.h.sequence == h.sequence {
+				return &fu, nil
+			}
+		case s := <-c.syncs:
+			if s.h.source == h.source {
+				return nil, nil
+			}
+		case <-ctx.Done():
+			return nil, c.wait(ctx)
+		case <-c.done:
+			return nil, errClosed
+		}
+	}
+}
+
+func (c *Client) sendDelayReq() (uint16, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	h := header{
+		msgType:     typeDelayReq,
+		length:      syncLen,
+		domain:      c.cfg.Domain,
+		source:      c.id,
+		sequence:    c.seq,
+		logInterval: 0x7f,
+	}
+	h.encode(c.buf[:], 1)
+	clear(c.buf[headerLen:])
+	// Drop any transmit timestamp left from an earlier request.
+	sockts.ReadTX(c.out, nil)
+	t3 := time.Now()
+	if _, err := c.out.WriteToUDP(c.buf[:], &net.UDPAddr{IP: Group, Port: EventPort}); err != nil {
+		return 0, time.Time{}, &ntp.Error{Code: ntp.CodeOf(err), Err: err}
+	}
+	return c.seq, t3, nil
+}
+
+func (c *Client) delayResp(ctx context.Context, seq uint16, master PortIdentity) (*delayRespMsg, error) {
+	for {
+		select {
+		case r := <-c.delayResps:
+			if r.requesting == c.id && r.h.sequence == seq && r.h.source == master {
+				return &r, nil
+			}
+		case <-ctx.Done():
+			return nil, c.wait(ctx)
+		case <-c.done:
+			return nil, errClosed
+		}
+	}
+}
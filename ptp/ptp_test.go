@@ -0,0 +1,129 @@
+package ptp
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+)
+
+var (
+	master = PortIdentity{Clock: ClockIdentity{0x00, 0x1b, 0x21, 0xff, 0xfe, 0x0a, 0x0b, 0x0c}, Port: 1}
+	slave  = PortIdentity{Clock: ClockIdentity{0x02, 0x00, 0x00, 0xff, 0xfe, 0x00, 0x00, 0x01}, Port: 1}
+)
+
+// message returns a message of type typ and length n from master,
+// with its header encoded and the body zeroed.
+func message(typ uint8, n int, flags uint16, logInterval int8) []byte {
+	b := make([]byte, n)
+	h := header{msgType: typ, length: uint16(n), flags: flags, correction: 3 << 16, source: master, sequence: 7, logInterval: logInterval}
+	h.encode(b, 5)
+	return b
+}
+
+func TestDecodeAnnounce(t *testing.T) {
+	gm := ClockIdentity{0x00, 0x1b, 0x21, 0xff, 0xfe, 0xaa, 0xbb, 0xcc}
+	b := message(typeAnnounce, announceLen, flagPTPTimescale|flagUTCOffsetValid, 1)
+	binary.BigEndian.PutUint16(b[44:], 37)
+	b[47] = 128
+	b[48], b[49] = 6, 0x21
+	binary.BigEndian.PutUint16(b[50:], 0x4e5d)
+	b[52] = 127
+	copy(b[53:], gm[:])
+	binary.BigEndian.PutUint16(b[61:], 2)
+	b[63] = 0x20
+
+	var h header
+	if err := h.decode(b); err != nil {
+		t.Fatal(err)
+	}
+	if h.msgType != typeAnnounce || h.source != master || h.sequence != 7 || h.correctionDuration() != 3 {
+		t.Errorf("header %+v, want an Announce from %v, sequence 7 and a 3ns correction", h, master)
+	}
+	a, err := decodeAnnounce(&h, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Announce{
+		Source:         master,
+		Priority1:      128,
+		Quality:        ClockQuality{Class: 6, Accuracy: 0x21, Variance: 0x4e5d},
+		Priority2:      127,
+		Grandmaster:    gm,
+		StepsRemoved:   2,
+		TimeSource:     0x20,
+		UTCOffset:      37,
+		UTCOffsetValid: true,
+		PTPTimescale:   true,
+		Interval:       2 * time.Second,
+	}
+	if *a != want {
+		t.Errorf("decoded %+v, want %+v", *a, want)
+	}
+
+	if _, err := decodeAnnounce(&h, b[:announceLen-1]); err != errShort {
+		t.Errorf("short Announce gave %v, want %v", err, errShort)
+	}
+	b[1] = 1
+	if err := h.decode(b); err == nil {
+		t.Error("PTPv1 header accepted")
+	}
+}
+
+func TestDecodeDelayResp(t *testing.T) {
+	rx := time.Date(2026, 1, 1, 0, 0, 37, 123456789, time.UTC)
+	b := message(typeDelayResp, delayRespLen, 0, -4)
+	encodeTimestamp(b[headerLen:], rx)
+	copy(b[headerLen+10:], slave.Clock[:])
+	binary.BigEndian.PutUint16(b[headerLen+18:], slave.Port)
+
+	var h header
+	if err := h.decode(b); err != nil {
+		t.Fatal(err)
+	}
+	m := decodeDelayResp(&h, b)
+	if !m.receive.Equal(rx) {
+		t.Errorf("receive timestamp %v, want %v", m.receive, rx)
+	}
+	if m.requesting != slave {
+		t.Errorf("requesting port %v, want %v", m.requesting, slave)
+	}
+	if m.h.source != master || m.h.sequence != 7 {
+		t.Errorf("from %v with sequence %d, want %v and 7", m.h.source, m.h.sequence, master)
+	}
+	if got := logInterval(m.h.logInterval); got != time.Second/16 {
+		t.Errorf("interval %v, want 1/16s", got)
+	}
+}
+
+func TestOffsetSigns(t *testing.T) {
+	const oneWay = 100 * time.Microsecond
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, ahead := range []time.Duration{time.Millisecond, -time.Millisecond, 0} {
+		// The master's clock reads ahead more than the local one.
+		t1 := start.Add(ahead)
+		t2 := start.Add(oneWay)
+		t3 := t2.Add(10 * time.Millisecond)
+		t4 := t3.Add(oneWay + ahead)
+		offset, delay := offsetDelay(t1, t2, t3, t4)
+		if offset != ahead {
+			t.Errorf("master %v ahead: offset %v, want %v", ahead, offset, ahead)
+		}
+		if delay != 2*oneWay {
+			t.Errorf("master %v ahead: delay %v, want %v", ahead, delay, 2*oneWay)
+		}
+	}
+}
+
+func TestMeasurementSample(t *testing.T) {
+	m := Measurement{
+		T2:     time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		Offset: -time.Millisecond,
+		Delay:  200 * time.Microsecond,
+	}
+	want := ntp.Sample{Offset: m.Offset, Delay: m.Delay, Dispersion: 3 * time.Nanosecond, Time: m.T2}
+	if s := m.Sample(); s != want {
+		t.Errorf("Sample = %+v, want %+v", s, want)
+	}
+}
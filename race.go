@@ -0,0 +1,117 @@
+package ntp
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// raceAddrs resolves the host of addr and returns its first IPv6 and
+// first IPv4 address with the port of addr. ok is false, with no error,
+// when there is nothing to race: addr holds an IP address, or the name
+// has addresses of one family only.
+func (c *Client) raceAddrs(ctx context.Context, addr string) (v6, v4 netip.AddrPort, ok bool, err error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return v6, v4, false, err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return v6, v4, false, nil
+	}
+	pn, err := net.LookupPort("udp", port)
+	if err != nil {
+		return v6, v4, false, &Error{Code: NTP_ERR_CONFIG, Err: err}
+	}
+	var ips []netip.Addr
+	if c.LookupHost != nil {
+		ips, err = c.LookupHost(ctx, host)
+	} else {
+		ips, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return v6, v4, false, ctx.Err()
+		}
+		return v6, v4, false, &Error{Code: NTP_ERR_RESOLVE, Err: err}
+	}
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		if ip.Is4() && !v4.IsValid() {
+			v4 = netip.AddrPortFrom(ip, uint16(pn))
+		} else if !ip.Is4() && !v6.IsValid() {
+			v6 = netip.AddrPortFrom(ip, uint16(pn))
+		}
+	}
+	if !v4.IsValid() && !v6.IsValid() {
+		return v6, v4, false, errNoAddress
+	}
+	return v6, v4, v4.IsValid() && v6.IsValid(), nil
+}
+
+// raceConn sends each request from one unconnected socket to both an
+// IPv6 and an IPv4 address of a server, and reads whichever reply comes
+// first. From then on it talks to the winner only, so a socket kept by
+// ReuseConn races once.
+type raceConn struct {
+	uc    *net.UDPConn
+	peers []netip.AddrPort
+}
+
+// dialRace opens the socket for a raceConn on v6 and v4.
+func dialRace(v6, v4 netip.AddrPort) (net.Conn, error) {
+	uc, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &raceConn{uc: uc, peers: []netip.AddrPort{v6, v4}}, nil
+}
+
+// Write sends b to every remaining peer. It fails only if every send
+// does, since a host without an IPv6 route refuses those outright.
+func (r *raceConn) Write(b []byte) (int, error) {
+	var n int
+	var err error
+	sent := false
+	for _, p := range r.peers {
+		if n, err = r.uc.WriteToUDPAddrPort(b, p); err == nil {
+			sent = true
+		}
+	}
+	if sent {
+		return len(b), nil
+	}
+	return n, err
+}
+
+// Read returns the next datagram from a peer, dropping any from other
+// addresses, and settles on the peer it came from.
+func (r *raceConn) Read(b []byte) (int, error) {
+	for {
+		n, from, err := r.uc.ReadFromUDPAddrPort(b)
+		if err != nil {
+			return n, err
+		}
+		for _, p := range r.peers {
+			if sameAddr(from, p) {
+				if len(r.peers) > 1 {
+					r.peers = append(r.peers[:0], p)
+				}
+				return n, nil
+			}
+		}
+	}
+}
+
+func (r *raceConn) Close() error        { return r.uc.Close() }
+func (r *raceConn) LocalAddr() net.Addr { return r.uc.LocalAddr() }
+
+// RemoteAddr returns the peer that won the race, or the IPv6 one until
+// a reply has arrived.
+func (r *raceConn) RemoteAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(r.peers[0])
+}
+
+func (r *raceConn) SetDeadline(t time.Time) error      { return r.uc.SetDeadline(t) }
+func (r *raceConn) SetReadDeadline(t time.Time) error  { return r.uc.SetReadDeadline(t) }
+func (r *raceConn) SetWriteDeadline(t time.Time) error { return r.uc.SetWriteDeadline(t) }
@@ -0,0 +1,200 @@
+// Package resolve looks up large numbers of server names without
+// overwhelming DNS. A Resolver bounds the lookups in flight, paces them
+// to a maximum rate, merges concurrent lookups of the same name and
+// caches answers, including failures, for a while.
+package resolve
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Resolver is a bounded, caching, deduplicating host resolver. The zero
+// value is ready to use with the defaults noted on each field.
+type Resolver struct {
+	// Resolver does the lookups; net.DefaultResolver when nil.
+	Resolver *net.Resolver
+	// Concurrency bounds the lookups in flight (default 16).
+	Concurrency int
+	// Rate caps lookups started per second; zero means no cap.
+	Rate float64
+	// TTL is how long answers are cached (default 5 minutes). The
+	// system resolver does not expose record TTLs, so a fixed one is
+	// used.
+	TTL time.Duration
+	// NegativeTTL is how long failures are cached (default 30
+	// seconds).
+	NegativeTTL time.Duration
+
+	once  sync.Once
+	sem   chan struct{}
+	mu    sync.Mutex
+	cache map[string]*entry
+	next  time.Time
+}
+
+type entry struct {
+	done    chan struct{}
+	addrs   []netip.Addr
+	err     error
+	expires time.Time
+}
+
+// Result is one answer from Stream.
+type Result struct {
+	Host  string
+	Addrs []netip.Addr
+	Err   error
+}
+
+func (r *Resolver) init() {
+	r.once.Do(func() {
+		n := r.Concurrency
+		if n <= 0 {
+			n = 16
+		}
+		r.sem = make(chan struct{}, n)
+		r.cache = make(map[string]*entry)
+	})
+}
+
+// LookupHost returns the addresses of host. IP literals are returned
+// as they are, without a lookup.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{ip}, nil
+	}
+	r.init()
+	now := time.Now()
+	r.mu.Lock()
+	e, ok := r.cache[host]
+	if ok && (e.expires.IsZero() || now.Before(e.expires)) {
+		// Either cached or already being looked up by someone else.
+		r.mu.Unlock()
+		select {
+		case <-e.done:
+			return e.addrs, e.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	e = &entry{done: make(chan struct{})}
+	r.cache[host] = e
+	r.mu.Unlock()
+
+	e.addrs, e.err = r.lookup(ctx, host)
+	ttl := r.TTL
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	if e.err != nil {
+		ttl = r.NegativeTTL
+		if ttl <= 0 {
+			ttl = 30 * time.Second
+		}
+		if ctx.Err() != nil {
+			// Our caller gave up; that says nothing about the name.
+			ttl = 0
+		}
+	}
+	r.mu.Lock()
+	e.expires = time.Now().Add(ttl)
+	if ttl == 0 {
+		delete(r.cache, host)
+	}
+	r.mu.Unlock()
+	close(e.done)
+	return e.addrs, e.err
+}
+
+func (r *Resolver) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	select {
+	case r.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-r.sem }()
+	if err := r.pace(ctx); err != nil {
+		return nil, err
+	}
+	res := r.Resolver
+	if res == nil {
+		res = net.DefaultResolver
+	}
+	addrs, err := res.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for i, a := range addrs {
+		addrs[i] = a.Unmap()
+	}
+	return addrs, nil
+}
+
+// pace waits for the next lookup slot allowed by Rate.
+func (r *Resolver) pace(ctx context.Context) error {
+	if r.Rate <= 0 {
+		return nil
+	}
+	now := time.Now()
+	r.mu.Lock()
+	at := r.next
+	if at.Before(now) {
+		at = now
+	}
+	r.next = at.Add(time.Duration(float64(time.Second) / r.Rate))
+	r.mu.Unlock()
+	if d := at.Sub(now); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Stream resolves every host received from hosts and sends the answers
+// as they arrive, in no particular order. The output is closed once
+// hosts is closed and drained, or ctx is done.
+func (r *Resolver) Stream(ctx context.Context, hosts <-chan string) <-chan Result {
+	r.init()
+	out := make(chan Result)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(r.sem); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range hosts {
+				addrs, err := r.LookupHost(ctx, host)
+				select {
+				case out <- Result{host, addrs, err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// Flush empties the cache.
+func (r *Resolver) Flush() {
+	r.init()
+	r.mu.Lock()
+	for host, e := range r.cache {
+		if !e.expires.IsZero() {
+			delete(r.cache, host)
+		}
+	}
+	r.mu.Unlock()
+}
@@ -0,0 +1,48 @@
+package ntp
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+const defaultRetryTimeout = time.Second
+
+func (c *Client) retryTimeout() time.Duration {
+	if c.RetryTimeout > 0 {
+		return c.RetryTimeout
+	}
+	return defaultRetryTimeout
+}
+
+// retryWait returns the pause before retry n+1: RetryBackoff doubled n
+// times, spread by RetryJitter.
+func (c *Client) retryWait(n int) time.Duration {
+	if c.RetryBackoff <= 0 {
+		return 0
+	}
+	d := c.RetryBackoff
+	for i := 0; i < n && d < maxDuration/2; i++ {
+		d *= 2
+	}
+	if j := min(max(c.RetryJitter, 0), 1); j > 0 {
+		d = time.Duration(float64(d) * (1 + j*(2*rand.Float64()-1)))
+	}
+	return d
+}
+
+// sleep waits for d by c's Clock or until ctx is done, whichever is
+// first.
+func (c *Client) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := c.clock().NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,373 @@
+// Package scan probes large numbers of NTP servers concurrently. It is
+// intended for pool operators and measurement studies: a bounded set of
+// workers shares a small number of unconnected UDP sockets, probes to
+// any one target are rate limited, and results stream back over a
+// channel as they complete.
+package scan
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+	"github.com/chaitanyav/ntp/internal/stats"
+	"github.com/chaitanyav/ntp/resolve"
+)
+
+// Config controls a Scanner. Zero fields take the defaults noted.
+type Config struct {
+	// Workers is the number of probes in flight at once (default
+	// 256).
+	Workers int
+	// Sockets is the number of UDP sockets the workers share (default
+	// 4).
+	Sockets int
+	// Timeout bounds the wait for each reply (default 2s).
+	Timeout time.Duration
+	// Count is the number of probes sent to each target (default 1).
+	Count int
+	// TargetInterval is the minimum spacing between probes to the
+	// same target, across the whole scan (default 2s, the smallest
+	// poll interval servers are expected to tolerate).
+	TargetInterval time.Duration
+	// Rate caps the total probes per second; zero means no cap.
+	Rate float64
+	// Version is the NTP version sent (default 4).
+	Version byte
+	// Resolver looks up target names. When nil the Scanner uses its
+	// own resolve.Resolver with default limits, so large name lists
+	// are resolved with bounded concurrency and each name only once.
+	Resolver *resolve.Resolver
+}
+
+// Result is the outcome of one probe.
+type Result struct {
+	Target string
+	Addr   *net.UDPAddr
+	Seq    int
+	Packet ntp.DataPacket
+
+	T1, T4 time.Time
+	Offset time.Duration
+	Delay  time.Duration
+
+	Err error
+}
+
+// ErrTimeout is the Err of a probe that got no reply in time.
+var ErrTimeout = errors.New("scan: timeout")
+
+// Stats are running totals for a Scanner.
+type Stats struct {
+	Sent      uint64
+	Replies   uint64
+	Timeouts  uint64
+	Unmatched uint64 // replies that no probe was waiting for
+}
+
+type key struct {
+	addr  string
+	nonce ntp.NTPTime
+}
+
+type reply struct {
+	packet ntp.DataPacket
+	at     time.Time
+}
+
+// Scanner holds the shared sockets and the per-target state of a scan.
+// A Scanner may run several scans, concurrently or in turn.
+type Scanner struct {
+	cfg   Config
+	conns []*net.UDPConn
+
+	sent, replies, timeouts, unmatched stats.Counter
+
+	mu      sync.Mutex
+	pending map[key]chan reply
+	last    map[string]time.Time
+	pruned  time.Time // when last was last swept
+	next    time.Time
+}
+
+// New opens the shared sockets.
+func New(cfg Config) (*Scanner, error) {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 256
+	}
+	if cfg.Sockets <= 0 {
+		cfg.Sockets = 4
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.Count <= 0 {
+		cfg.Count = 1
+	}
+	if cfg.TargetInterval <= 0 {
+		cfg.TargetInterval = 2 * time.Second
+	}
+	if cfg.Version == 0 {
+		cfg.Version = 4
+	}
+	if cfg.Resolver == nil {
+		cfg.Resolver = &resolve.Resolver{}
+	}
+	s := &Scanner{
+		cfg:     cfg,
+		pending: make(map[key]chan reply),
+		last:    make(map[string]time.Time),
+	}
+	for i := 0; i < cfg.Sockets; i++ {
+		c, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.conns = append(s.conns, c)
+		go s.receive(c)
+	}
+	return s, nil
+}
+
+// Stats returns the totals so far. It is cheap enough to call from a
+// progress display while the scan runs.
+func (s *Scanner) Stats() Stats {
+	return Stats{
+		Sent:      s.sent.Load(),
+		Replies:   s.replies.Load(),
+		Timeouts:  s.timeouts.Load(),
+		Unmatched: s.unmatched.Load(),
+	}
+}
+
+// Close closes the sockets; scans still running fail with errors.
+func (s *Scanner) Close() error {
+	var first error
+	for _, c := range s.conns {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Run probes every target received from targets and streams the
+// results. The returned channel is closed once targets is closed and
+// all probes have finished, or once ctx is cancelled.
+func (s *Scanner) Run(ctx context.Context, targets <-chan string) <-chan Result {
+	out := make(chan Result, s.cfg.Workers)
+	var wg sync.WaitGroup
+	for i := 0; i < s.cfg.Workers; i++ {
+		wg.Add(1)
+		go func(conn *net.UDPConn) {
+			defer wg.Done()
+			for {
+				var target string
+				var ok bool
+				select {
+				case <-ctx.Done():
+					return
+				case target, ok = <-targets:
+					if !ok {
+						return
+					}
+				}
+				s.scanTarget(ctx, conn, target, out)
+			}
+		}(s.conns[i%len(s.conns)])
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+func (s *Scanner) scanTarget(ctx context.Context, conn *net.UDPConn, target string, out chan<- Result) {
+	addr, err := s.resolve(ctx, target)
+	if err != nil {
+		send(ctx, out, Result{Target: target, Err: err})
+		return
+	}
+	for seq := 0; seq < s.cfg.Count; seq++ {
+		if err := s.wait(ctx, target); err != nil {
+			return
+		}
+		r := s.probe(ctx, conn, addr)
+		r.Target, r.Seq = target, seq
+		if !send(ctx, out, r) {
+			return
+		}
+	}
+}
+
+func send(ctx context.Context, out chan<- Result, r Result) bool {
+	select {
+	case out <- r:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// wait blocks until both the per-target interval and the global rate
+// allow another probe to target, and reserves that slot.
+func (s *Scanner) wait(ctx context.Context, target string) error {
+	now := time.Now()
+	s.mu.Lock()
+	at := now
+	if last, ok := s.last[target]; ok && last.Add(s.cfg.TargetInterval).After(at) {
+		at = last.Add(s.cfg.TargetInterval)
+	}
+	if s.cfg.Rate > 0 {
+		if s.next.After(at) {
+			at = s.next
+		}
+		s.next = at.Add(time.Duration(float64(time.Second) / s.cfg.Rate))
+	}
+	s.last[target] = at
+	// Targets probed more than TargetInterval ago are no longer held
+	// back; sweep them out once per interval so a long scan of many
+	// targets does not keep them all.
+	if now.Sub(s.pruned) >= s.cfg.TargetInterval {
+		for t, last := range s.last {
+			if !last.Add(s.cfg.TargetInterval).After(now) {
+				delete(s.last, t)
+			}
+		}
+		s.pruned = now
+	}
+	s.mu.Unlock()
+
+	if d := at.Sub(now); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) probe(ctx context.Context, conn *net.UDPConn, addr *net.UDPAddr) Result {
+	r := Result{Addr: addr}
+	var nonce [8]byte
+	rand.Read(nonce[:])
+	req := ntp.DataPacket{
+		Byte1:             s.cfg.Version<<3 | 3,
+		TransmitTimeStamp: ntp.NTPTime(binary.BigEndian.Uint64(nonce[:])),
+	}
+	var buf [ntp.PACKET_SIZE]byte
+	ntp.EncodePacket(buf[:], &req)
+
+	k := key{addr.String(), req.TransmitTimeStamp}
+	ch := make(chan reply, 1)
+	s.mu.Lock()
+	s.pending[k] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, k)
+		s.mu.Unlock()
+	}()
+
+	r.T1 = time.Now()
+	if _, err := conn.WriteToUDP(buf[:], addr); err != nil {
+		r.Err = err
+		return r
+	}
+	s.sent.Inc()
+	t := time.NewTimer(s.cfg.Timeout)
+	defer t.Stop()
+	select {
+	case rep := <-ch:
+		r.Packet, r.T4 = rep.packet, rep.at
+	case <-t.C:
+		s.timeouts.Inc()
+		r.Err = ErrTimeout
+		return r
+	case <-ctx.Done():
+		r.Err = ctx.Err()
+		return r
+	}
+	if r.Packet.Mode() != ntp.ModeServer {
+		r.Err = fmt.Errorf("scan: reply has mode %q", r.Packet.DecodeMode())
+		return r
+	}
+	t2 := r.Packet.ReceiveTimeStamp.TimeNear(r.T4)
+	t3 := r.Packet.TransmitTimeStamp.TimeNear(r.T4)
+	r.Offset, r.Delay = ntp.OnWire(r.T1, t2, t3, r.T4)
+	return r
+}
+
+// receive reads replies from a shared socket and hands each to the
+// probe waiting for it. Replies nobody is waiting for (late, duplicated
+// or spoofed) are dropped.
+func (s *Scanner) receive(conn *net.UDPConn) {
+	buf := make([]byte, 512)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		at := time.Now()
+		var p ntp.DataPacket
+		if ntp.DecodePacket(buf[:n], &p) != nil {
+			continue
+		}
+		s.mu.Lock()
+		ch, ok := s.pending[key{from.String(), p.OriginateTimeStamp}]
+		if ok {
+			delete(s.pending, key{from.String(), p.OriginateTimeStamp})
+		}
+		s.mu.Unlock()
+		if !ok {
+			s.unmatched.Inc()
+			continue
+		}
+		s.replies.Inc()
+		ch <- reply{p, at}
+	}
+}
+
+// resolve maps a host or host:port target to the address to probe,
+// the first one the resolver returns.
+func (s *Scanner) resolve(ctx context.Context, target string) (*net.UDPAddr, error) {
+	host, port := target, uint16(123)
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("scan: bad port in %q", target)
+		}
+		host, port = h, uint16(n)
+	} else if len(host) > 1 && host[0] == '[' && host[len(host)-1] == ']' {
+		// A bracketed IPv6 literal without a port.
+		host = host[1 : len(host)-1]
+	}
+	addrs, err := s.cfg.Resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("scan: no addresses for %s", host)
+	}
+	return net.UDPAddrFromAddrPort(netip.AddrPortFrom(addrs[0], port)), nil
+}
@@ -0,0 +1,100 @@
+package ntp
+
+import (
+	"sort"
+	"time"
+)
+
+// Intersection is the outcome of the RFC 5905 selection algorithm, a
+// variant of Marzullo's: the range of offsets that a majority of
+// servers' correctness intervals share, and which servers fall on
+// either side of it. Each server's correctness interval is its offset
+// plus or minus its root distance; a server whose clock is right has
+// the true offset somewhere in it.
+type Intersection struct {
+	// Low and High bound the offsets the majority agrees on.
+	Low, High time.Duration
+	// Truechimers and Falsetickers index the Responses given to
+	// Intersect: those whose offset lies between Low and High, and
+	// those whose offset does not.
+	Truechimers  []int
+	Falsetickers []int
+}
+
+var errNoMajority = newError(NTP_ERR_NO_MAJORITY, "ntp: no majority of servers agrees on the time")
+
+// endpoint is an edge of a correctness interval (typ -1 for the lower,
+// +1 for the upper) or its midpoint (typ 0).
+type endpoint struct {
+	edge time.Duration
+	typ  int
+}
+
+// Intersect finds the smallest range of offsets that the correctness
+// intervals of more than half of rs share, allowing for as few
+// falsetickers as possible. It fails with code NTP_ERR_NO_MAJORITY if
+// there is no such range, when the servers disagree so widely that no
+// majority can be trusted.
+func Intersect(rs []*Response) (*Intersection, error) {
+	n := len(rs)
+	eps := make([]endpoint, 0, 3*n)
+	for _, r := range rs {
+		d := r.RootDistance()
+		eps = append(eps,
+			endpoint{r.ClockOffset - d, -1},
+			endpoint{r.ClockOffset, 0},
+			endpoint{r.ClockOffset + d, +1})
+	}
+	// Lower edges sort before midpoints and upper edges at the same
+	// offset, so that intervals that only touch still intersect.
+	sort.Slice(eps, func(i, j int) bool {
+		if eps[i].edge != eps[j].edge {
+			return eps[i].edge < eps[j].edge
+		}
+		return eps[i].typ < eps[j].typ
+	})
+
+	// Assume allow falsetickers, starting with none, and look for an
+	// interval shared by the other n-allow; a midpoint outside it
+	// that is not one of the allowed falsetickers means allow is too
+	// small.
+	for allow := 0; 2*allow < n; allow++ {
+		low, high := maxDuration, -maxDuration
+		found, chime := 0, 0
+		for _, e := range eps {
+			chime -= e.typ
+			if chime >= n-allow {
+				low = e.edge
+				break
+			}
+			if e.typ == 0 {
+				found++
+			}
+		}
+		chime = 0
+		for i := len(eps) - 1; i >= 0; i-- {
+			e := eps[i]
+			chime += e.typ
+			if chime >= n-allow {
+				high = e.edge
+				break
+			}
+			if e.typ == 0 {
+				found++
+			}
+		}
+		if found > allow || low > high {
+			continue
+		}
+		x := &Intersection{Low: low, High: high}
+		for i, r := range rs {
+			if r.ClockOffset < low || r.ClockOffset > high {
+				x.Falsetickers = append(x.Falsetickers, i)
+			} else {
+				x.Truechimers = append(x.Truechimers, i)
+			}
+		}
+		return x, nil
+	}
+	return nil, errNoMajority
+}
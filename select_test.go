@@ -0,0 +1,74 @@
+package ntp_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+	"github.com/chaitanyav/ntp/ntptest"
+)
+
+// queryAll queries one server per config in turn and returns the
+// replies in the same order.
+func queryAll(t *testing.T, cfgs ...ntptest.Config) []*ntp.Response {
+	t.Helper()
+	rs := make([]*ntp.Response, len(cfgs))
+	for i, cfg := range cfgs {
+		var err error
+		if rs[i], err = query(t, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rs
+}
+
+func TestIntersectFalseticker(t *testing.T) {
+	rs := queryAll(t,
+		ntptest.Config{Offset: 0, RootDispersion: 10 * time.Millisecond},
+		ntptest.Config{Offset: time.Millisecond, RootDispersion: 10 * time.Millisecond},
+		ntptest.Config{Offset: 2 * time.Millisecond, RootDispersion: 10 * time.Millisecond},
+		ntptest.Config{Offset: 5 * time.Second, RootDispersion: 10 * time.Millisecond},
+	)
+	x, err := ntp.Intersect(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(x.Truechimers, []int{0, 1, 2}) || !slices.Equal(x.Falsetickers, []int{3}) {
+		t.Errorf("truechimers %v and falsetickers %v, want [0 1 2] and [3]", x.Truechimers, x.Falsetickers)
+	}
+	for _, i := range x.Truechimers {
+		if off := rs[i].ClockOffset; off < x.Low || off > x.High {
+			t.Errorf("truechimer %d has offset %v outside [%v, %v]", i, off, x.Low, x.High)
+		}
+	}
+	if x.High-x.Low > 40*time.Millisecond {
+		t.Errorf("intersection [%v, %v] is wider than two root distances", x.Low, x.High)
+	}
+}
+
+func TestIntersectSingle(t *testing.T) {
+	rs := queryAll(t, ntptest.Config{Offset: 3 * time.Millisecond})
+	x, err := ntp.Intersect(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(x.Truechimers, []int{0}) || len(x.Falsetickers) != 0 {
+		t.Errorf("truechimers %v and falsetickers %v, want [0] and none", x.Truechimers, x.Falsetickers)
+	}
+}
+
+func TestIntersectNoMajority(t *testing.T) {
+	rs := queryAll(t,
+		ntptest.Config{Offset: 0},
+		ntptest.Config{Offset: 10 * time.Second},
+	)
+	if x, err := ntp.Intersect(rs); err == nil {
+		t.Fatalf("two servers 10s apart intersect in [%v, %v]", x.Low, x.High)
+	} else if code := ntp.CodeOf(err); code != ntp.NTP_ERR_NO_MAJORITY {
+		t.Errorf("error %q has code %s, want NTP_ERR_NO_MAJORITY", err, code)
+	}
+	if _, err := ntp.Intersect(nil); ntp.CodeOf(err) != ntp.NTP_ERR_NO_MAJORITY {
+		t.Errorf("Intersect(nil) = %v, want NTP_ERR_NO_MAJORITY", err)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !tinygo
+
+package ntp
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// SO_BUSY_POLL from <asm-generic/socket.h>; not in package syscall.
+const soBusyPoll = 46
+
+// setLowLatency applies the BusyPoll and Priority options.
+func setLowLatency(conn *net.UDPConn, busyPoll time.Duration, priority int) error {
+	if busyPoll > 0 {
+		if err := setsockopt(conn, syscall.SOL_SOCKET, soBusyPoll, int(busyPoll/time.Microsecond)); err != nil {
+			return err
+		}
+	}
+	if priority > 0 {
+		if err := setsockopt(conn, syscall.SOL_SOCKET, syscall.SO_PRIORITY, priority); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build tinygo || !(linux || darwin || dragonfly || freebsd || netbsd || openbsd || solaris)
+
+package ntp
+
+import "net"
+
+func setDSCP(conn *net.UDPConn, dscp int) error {
+	if dscp != 0 {
+		return newError(NTP_ERR_UNSUPPORTED, "ntp: DSCP marking is not supported on this platform")
+	}
+	return nil
+}
+
+func setHopLimit(conn *net.UDPConn, hops int) error {
+	return newError(NTP_ERR_UNSUPPORTED, "ntp: setting the hop limit is not supported on this platform")
+}
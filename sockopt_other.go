@@ -0,0 +1,15 @@
+//go:build !linux || tinygo
+
+package ntp
+
+import (
+	"net"
+	"time"
+)
+
+func setLowLatency(conn *net.UDPConn, busyPoll time.Duration, priority int) error {
+	if busyPoll > 0 || priority > 0 {
+		return newError(NTP_ERR_UNSUPPORTED, "ntp: BusyPoll and Priority are only supported on Linux")
+	}
+	return nil
+}
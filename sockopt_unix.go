@@ -0,0 +1,54 @@
+//go:build (linux || darwin || dragonfly || freebsd || netbsd || openbsd || solaris) && !tinygo
+
+package ntp
+
+import (
+	"net"
+	"syscall"
+)
+
+// setDSCP marks outgoing packets with the given DSCP code point: the
+// top six bits of the IPv4 TOS byte or of the IPv6 traffic class.
+func setDSCP(conn *net.UDPConn, dscp int) error {
+	if dscp == 0 {
+		return nil
+	}
+	if dscp < 0 || dscp > 63 {
+		return newError(NTP_ERR_CONFIG, "ntp: DSCP must be between 0 and 63")
+	}
+	if isIPv4(conn) {
+		return setsockopt(conn, syscall.IPPROTO_IP, syscall.IP_TOS, dscp<<2)
+	}
+	return setsockopt(conn, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, dscp<<2)
+}
+
+// setHopLimit sets the IPv4 TTL or IPv6 unicast hop limit of outgoing
+// packets.
+func setHopLimit(conn *net.UDPConn, hops int) error {
+	if isIPv4(conn) {
+		return setsockopt(conn, syscall.IPPROTO_IP, syscall.IP_TTL, hops)
+	}
+	return setsockopt(conn, syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, hops)
+}
+
+// isIPv4 reports whether conn is an IPv4 socket, and so takes IP-level
+// rather than IPv6-level options.
+func isIPv4(conn *net.UDPConn) bool {
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	return ok && addr.IP.To4() != nil
+}
+
+func setsockopt(conn *net.UDPConn, level, opt, value int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), level, opt, value)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
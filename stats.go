@@ -0,0 +1,103 @@
+package ntp
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Stats summarizes a run of samples from one server, as a Monitor
+// keeps for each, to judge the server and the path to it and to choose
+// a poll interval. Jitter is the short-term noise on the offsets;
+// Wander and ADEV describe how steadily the offset drifts, the local
+// oscillator's frequency noise and the server's together.
+type Stats struct {
+	// N is the number of samples and Span the time from the first to
+	// the last.
+	N    int
+	Span time.Duration
+	// Jitter is the RMS of the differences between successive
+	// offsets.
+	Jitter time.Duration
+	// Wander is the RMS of the differences between the frequencies of
+	// successive pairs of samples, the rate at which the offset changes
+	// between them, in parts per million.
+	Wander float64
+	// ADEV is the Allan deviation, as a fraction, at Tau, the mean
+	// interval between samples; see AllanDeviation.
+	ADEV float64
+	Tau  time.Duration
+}
+
+// StatsOf computes the Stats of ss, which may be in any order. Jitter
+// needs two samples, and Wander and ADEV three; until then they are
+// zero.
+func StatsOf(ss []Sample) Stats {
+	ss = byTime(ss)
+	st := Stats{N: len(ss)}
+	if len(ss) < 2 {
+		return st
+	}
+	st.Span = ss[len(ss)-1].Time.Sub(ss[0].Time)
+	var sum float64
+	for i := 1; i < len(ss); i++ {
+		d := float64(ss[i].Offset - ss[i-1].Offset)
+		sum += d * d
+	}
+	st.Jitter = time.Duration(math.Sqrt(sum / float64(len(ss)-1)))
+	if adev, tau, ok := allanDev(ss, 1); ok {
+		// Wander's differences are those whose halved mean square is
+		// the Allan variance at one interval.
+		st.ADEV, st.Tau = adev, tau
+		st.Wander = adev * math.Sqrt2 * 1e6
+	}
+	return st
+}
+
+// AllanDeviation returns the overlapping Allan deviation of ss, which
+// may be in any order, at an averaging time of n sample intervals, and
+// that time, the mean of the spans it averaged over. Its square is half
+// the mean square difference between the frequencies averaged over
+// successive spans of n intervals. The samples should be about evenly
+// spaced, as at a fixed poll interval; ok is false if there are fewer
+// than 2n+1 of them.
+func AllanDeviation(ss []Sample, n int) (adev float64, tau time.Duration, ok bool) {
+	return allanDev(byTime(ss), n)
+}
+
+// allanDev is AllanDeviation over samples sorted by time.
+func allanDev(ss []Sample, n int) (float64, time.Duration, bool) {
+	if n < 1 || len(ss) < 2*n+1 {
+		return 0, 0, false
+	}
+	// y[i] is the frequency over the span from sample i to i+n.
+	y := make([]float64, len(ss)-n)
+	var span time.Duration
+	for i := range y {
+		dt := ss[i+n].Time.Sub(ss[i].Time)
+		if dt <= 0 {
+			return 0, 0, false
+		}
+		y[i] = float64(ss[i+n].Offset-ss[i].Offset) / float64(dt)
+		span += dt
+	}
+	var sum float64
+	m := len(y) - n
+	for i := 0; i < m; i++ {
+		d := y[i+n] - y[i]
+		sum += d * d
+	}
+	return math.Sqrt(sum / (2 * float64(m))), span / time.Duration(len(y)), true
+}
+
+// byTime returns ss ordered oldest first, copying it if it is not
+// already.
+func byTime(ss []Sample) []Sample {
+	less := func(i, j int) bool { return ss[i].Time.Before(ss[j].Time) }
+	if sort.SliceIsSorted(ss, less) {
+		return ss
+	}
+	ss = append([]Sample(nil), ss...)
+	sort.SliceStable(ss, func(i, j int) bool { return ss[i].Time.Before(ss[j].Time) })
+	return ss
+}
@@ -0,0 +1,162 @@
+// Package statsfile writes the loopstats and peerstats files of ntpd,
+// line for line in its format, so that tools written for them, such as
+// ntpviz, read the statistics of a Monitor and Discipline unchanged.
+//
+// Each line starts with the Modified Julian Day and the seconds past
+// UTC midnight. A loopstats line then has the offset in seconds, the
+// frequency in ppm, the jitter in seconds, the wander in ppm and the
+// poll exponent:
+//
+//	61327 26290.618 0.000285069 -12.546 0.000145628 0.011741 6
+//
+// and a peerstats line the server's address, the peer status word in
+// hex, and the offset, delay, dispersion and jitter in seconds:
+//
+//	61327 26290.618 192.0.2.1 9600 0.000285069 0.024517678 0.001972656 0.000314512
+package statsfile
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+)
+
+// mjdUnix is the Modified Julian Day of the Unix epoch.
+const mjdUnix = 40587
+
+// Peer status word bits, from ntpd's ntp_control.h: the high byte holds
+// flags and the selection status, the low byte an event counter and
+// code, which are not kept here.
+const (
+	pstConfig = 0x80
+	pstReach  = 0x10
+
+	selReject    = 0 // no usable sample
+	selFalsetick = 1 // x
+	selOutlier   = 3 // -
+	selCandidate = 4 // +
+	selSysPeer   = 6 // *
+)
+
+// FormatLoop returns the loopstats line, without its newline, for the
+// update at t that measured offset, with d's state after it.
+func FormatLoop(t time.Time, offset time.Duration, d *ntp.Discipline) string {
+	return fmt.Sprintf("%s %.9f %.3f %.9f %.6f %d", stamp(t),
+		offset.Seconds(), d.FrequencyPPM(), d.Jitter().Seconds(), d.Wander(), d.Poll())
+}
+
+// FormatPeer returns the peerstats line, without its newline, for the
+// state of a server at t, such as a Monitor's OnPeer is given. The
+// server's name stands in for its address, less any port, so the
+// servers should be named by address for tools that expect one.
+func FormatPeer(t time.Time, p ntp.PeerStatus) string {
+	addr := p.Server
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return fmt.Sprintf("%s %s %4x %.9f %.9f %.9f %.9f", stamp(t), addr, Status(p),
+		p.Best.Offset.Seconds(), p.Best.Delay.Seconds(), p.Dispersion.Seconds(), p.Jitter.Seconds())
+}
+
+// Status returns the peer status word ntpd would report for p: the
+// server is configured, reachable if any of the last eight polls was
+// answered, and marked by how it fared in selection.
+func Status(p ntp.PeerStatus) uint16 {
+	flags := pstConfig
+	if p.Reach != 0 {
+		flags |= pstReach
+	}
+	sel := selReject
+	switch {
+	case p.SystemPeer:
+		sel = selSysPeer
+	case p.Survivor:
+		sel = selCandidate
+	case p.Falseticker:
+		sel = selFalsetick
+	case p.Last != nil:
+		sel = selOutlier
+	}
+	return uint16(flags|sel) << 8
+}
+
+// stamp returns the MJD and seconds past midnight that start a line.
+func stamp(t time.Time) string {
+	t = t.UTC()
+	day := t.Unix()/86400 + mjdUnix
+	secs := t.Sub(t.Truncate(24 * time.Hour)).Seconds()
+	return strconv.FormatInt(day, 10) + " " + strconv.FormatFloat(secs, 'f', 3, 64)
+}
+
+// Logger appends lines to loopstats and peerstats files in Dir, as
+// ntpd does with statsdir and "filegen ... type day": a new file each
+// UTC day, named with the date, e.g. loopstats.20261014. It is safe
+// for concurrent use; its methods fit a Monitor's OnPeer and the
+// updates of a Discipline.
+type Logger struct {
+	// Dir is the directory the files go in; it must exist.
+	Dir string
+
+	mu    sync.Mutex
+	files map[string]*dayFile
+}
+
+// dayFile is the open file of one kind, and the day it is for.
+type dayFile struct {
+	f   *os.File
+	day string
+}
+
+// Loop appends FormatLoop's line to loopstats.
+func (l *Logger) Loop(t time.Time, offset time.Duration, d *ntp.Discipline) error {
+	return l.write("loopstats", t, FormatLoop(t, offset, d))
+}
+
+// Peer appends FormatPeer's line to peerstats.
+func (l *Logger) Peer(t time.Time, p ntp.PeerStatus) error {
+	return l.write("peerstats", t, FormatPeer(t, p))
+}
+
+func (l *Logger) write(name string, t time.Time, line string) error {
+	day := t.UTC().Format("20060102")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	df := l.files[name]
+	if df == nil || df.day != day {
+		f, err := os.OpenFile(filepath.Join(l.Dir, name+"."+day), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		if df != nil {
+			df.f.Close()
+		}
+		if l.files == nil {
+			l.files = make(map[string]*dayFile)
+		}
+		df = &dayFile{f: f, day: day}
+		l.files[name] = df
+	}
+	_, err := df.f.WriteString(line + "\n")
+	return err
+}
+
+// Close closes the open files. The Logger opens them again if written
+// to afterwards.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var first error
+	for name, df := range l.files {
+		if err := df.f.Close(); err != nil && first == nil {
+			first = err
+		}
+		delete(l.files, name)
+	}
+	return first
+}
@@ -0,0 +1,150 @@
+// Package timescale converts between UTC, TAI and GPS time.
+//
+// Go's time.Time has no notion of a timescale, so a TAI or GPS time is
+// represented here as a time.Time whose calendar fields read in that
+// scale: TAI(t).Format(...) prints the TAI time of the UTC instant t.
+// Such values must not be compared with, or subtracted from, ordinary
+// UTC times.
+//
+// Conversions use a leap second table. The built-in one ends with the
+// leap second at the end of 2016; call SetTable with a current
+// leap-seconds.list (see package leapsec and "ntp leapfile") to pick up
+// any announced since.
+package timescale
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+	"github.com/chaitanyav/ntp/leapsec"
+)
+
+// GPSOffset is TAI-GPS, fixed since the GPS epoch.
+const GPSOffset = 19 * time.Second
+
+// GPSEpoch is the start of GPS week 0, in UTC (and in GPS time).
+var GPSEpoch = time.Date(1980, time.January, 6, 0, 0, 0, 0, time.UTC)
+
+const week = 7 * 24 * time.Hour
+
+// builtin is TAI-UTC from each leap second of the IERS table on.
+var builtin = []leapsec.Leap{
+	{Time: date(1972, 1), Offset: 10},
+	{Time: date(1972, 7), Offset: 11},
+	{Time: date(1973, 1), Offset: 12},
+	{Time: date(1974, 1), Offset: 13},
+	{Time: date(1975, 1), Offset: 14},
+	{Time: date(1976, 1), Offset: 15},
+	{Time: date(1977, 1), Offset: 16},
+	{Time: date(1978, 1), Offset: 17},
+	{Time: date(1979, 1), Offset: 18},
+	{Time: date(1980, 1), Offset: 19},
+	{Time: date(1981, 7), Offset: 20},
+	{Time: date(1982, 7), Offset: 21},
+	{Time: date(1983, 7), Offset: 22},
+	{Time: date(1985, 7), Offset: 23},
+	{Time: date(1988, 1), Offset: 24},
+	{Time: date(1990, 1), Offset: 25},
+	{Time: date(1991, 1), Offset: 26},
+	{Time: date(1992, 7), Offset: 27},
+	{Time: date(1993, 7), Offset: 28},
+	{Time: date(1994, 7), Offset: 29},
+	{Time: date(1996, 1), Offset: 30},
+	{Time: date(1997, 7), Offset: 31},
+	{Time: date(1999, 1), Offset: 32},
+	{Time: date(2006, 1), Offset: 33},
+	{Time: date(2009, 1), Offset: 34},
+	{Time: date(2012, 7), Offset: 35},
+	{Time: date(2015, 7), Offset: 36},
+	{Time: date(2017, 1), Offset: 37},
+}
+
+func date(year int, month time.Month) time.Time {
+	return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+}
+
+var table atomic.Pointer[[]leapsec.Leap]
+
+func init() {
+	table.Store(&builtin)
+}
+
+// SetTable replaces the leap second table used by every conversion. It
+// is safe to call while conversions are running; nil restores the
+// built-in table.
+func SetTable(t *leapsec.Table) {
+	if t == nil {
+		table.Store(&builtin)
+		return
+	}
+	leaps := append([]leapsec.Leap(nil), t.Leaps...)
+	table.Store(&leaps)
+}
+
+// Offset returns TAI-UTC in seconds at the UTC instant t, or 0 before
+// the first leap second entry (1972).
+func Offset(t time.Time) int {
+	leaps := *table.Load()
+	for i := len(leaps) - 1; i >= 0; i-- {
+		if !t.Before(leaps[i].Time) {
+			return leaps[i].Offset
+		}
+	}
+	return 0
+}
+
+// ResponseOffset returns TAI-UTC in seconds at the server's transmit
+// time in r; add it to r.Time for TAI. It lives here rather than as a
+// field of ntp.Response so that the core package does not carry the
+// leap second table.
+func ResponseOffset(r *ntp.Response) int {
+	return Offset(r.Time)
+}
+
+// TAI returns the TAI time of the UTC instant t.
+func TAI(t time.Time) time.Time {
+	return t.UTC().Add(time.Duration(Offset(t)) * time.Second)
+}
+
+// FromTAI returns the UTC instant of the TAI time tai. An inserted leap
+// second has no representation in time.Time, so every TAI time within
+// one maps to the end of it, the midnight that follows.
+func FromTAI(tai time.Time) time.Time {
+	tai = tai.UTC()
+	leaps := *table.Load()
+	for i := len(leaps) - 1; i >= 0; i-- {
+		l := leaps[i]
+		if !tai.Before(l.Time.Add(time.Duration(l.Offset) * time.Second)) {
+			return tai.Add(-time.Duration(l.Offset) * time.Second)
+		}
+		if i > 0 && !tai.Before(l.Time.Add(time.Duration(leaps[i-1].Offset)*time.Second)) {
+			return l.Time
+		}
+	}
+	return tai
+}
+
+// GPS returns the GPS time of the UTC instant t.
+func GPS(t time.Time) time.Time {
+	return TAI(t).Add(-GPSOffset)
+}
+
+// FromGPS returns the UTC instant of the GPS time gps.
+func FromGPS(gps time.Time) time.Time {
+	return FromTAI(gps.Add(GPSOffset))
+}
+
+// GPSWeek splits the GPS time gps into the week number since GPSEpoch
+// (not reduced modulo 1024, as receivers broadcast it) and the time of
+// week.
+func GPSWeek(gps time.Time) (int, time.Duration) {
+	d := gps.Sub(GPSEpoch)
+	n := d / week
+	tow := d % week
+	if tow < 0 {
+		n--
+		tow += week
+	}
+	return int(n), tow
+}
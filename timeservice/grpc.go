@@ -0,0 +1,262 @@
+package timeservice
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chaitanyav/ntp"
+)
+
+// servicePath prefixes the methods of TimeService.
+const servicePath = "/ntp.timeservice.v1.TimeService/"
+
+// maxRequest bounds request messages, all of which are tiny.
+const maxRequest = 4096
+
+// gRPC status codes used here.
+const (
+	codeOK            = 0
+	codeInvalidArg    = 3
+	codeUnimplemented = 12
+)
+
+// Handler serves the TimeService gRPC methods. Requests must arrive
+// over HTTP/2.
+func (s *Service) Handler() http.Handler {
+	return http.HandlerFunc(s.serveGRPC)
+}
+
+func (s *Service) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	req, err := readMessage(r.Body)
+	if err != nil {
+		finish(w, codeInvalidArg, err.Error())
+		return
+	}
+	switch strings.TrimPrefix(r.URL.Path, servicePath) {
+	case "GetTime":
+		now, maxErr, st := s.Now()
+		var m pb
+		m.int64(1, now.UnixNano())
+		m.int64(2, int64(st.Offset))
+		m.int64(3, int64(maxErr))
+		m.bool(4, st.Synchronized)
+		m.string(5, st.Source)
+		writeMessage(w, m)
+	case "GetStatus":
+		writeMessage(w, encodeStatus(s.Status()))
+	case "ListSources":
+		var m pb
+		for _, src := range s.Sources() {
+			m.message(1, encodeSource(&src))
+		}
+		writeMessage(w, m)
+	case "Measurements":
+		server, err := decodeMeasurementsRequest(req)
+		if err != nil {
+			finish(w, codeInvalidArg, err.Error())
+			return
+		}
+		// Send the headers now so the client sees the stream open
+		// before the first poll completes.
+		w.WriteHeader(http.StatusOK)
+		flush(w)
+		for m := range s.Subscribe(r.Context(), server) {
+			if err := writeMessage(w, encodeMeasurement(&m)); err != nil {
+				return
+			}
+			flush(w)
+		}
+	default:
+		finish(w, codeUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+	finish(w, codeOK, "")
+}
+
+// finish sets the trailers that end every gRPC response.
+func finish(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", msg)
+	}
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Messages are framed as a compression flag, a 4-byte big-endian
+// length and the protobuf bytes.
+
+func readMessage(r io.Reader) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, errors.New("missing request message")
+	}
+	if hdr[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > maxRequest {
+		return nil, errors.New("request message too large")
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, errors.New("truncated request message")
+	}
+	return buf, nil
+}
+
+func writeMessage(w io.Writer, m pb) error {
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(m)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(m)
+	return err
+}
+
+func encodeStatus(st Status) pb {
+	var m pb
+	m.bool(1, st.Synchronized)
+	m.string(2, st.Source)
+	m.uint(3, uint64(st.Stratum))
+	m.uint(4, uint64(st.Leap))
+	m.int64(5, int64(st.Offset))
+	m.int64(6, int64(st.Delay))
+	m.int64(7, int64(st.RootDelay))
+	m.int64(8, int64(st.RootDispersion))
+	if !st.LastUpdate.IsZero() {
+		m.int64(9, st.LastUpdate.UnixNano())
+	}
+	m.uint(10, uint64(st.Reachable))
+	m.uint(11, uint64(st.Sources))
+	m.int64(12, int64(st.RootDistance))
+	return m
+}
+
+func encodeSource(src *Source) pb {
+	var m pb
+	m.string(1, src.Server)
+	m.string(2, src.Addr)
+	m.bool(3, src.Selected)
+	m.uint(4, uint64(src.Reach))
+	m.uint(5, uint64(src.Last.Stratum))
+	m.int64(6, int64(src.Last.Offset))
+	m.int64(7, int64(src.Last.Delay))
+	if !src.Last.Time.IsZero() {
+		m.int64(8, src.Last.Time.UnixNano())
+	}
+	if src.LastErr != nil {
+		m.string(9, src.LastErr.Error())
+	}
+	return m
+}
+
+func encodeMeasurement(ms *Measurement) pb {
+	var m pb
+	m.string(1, ms.Server)
+	if ms.Err != nil {
+		m.string(7, ms.Err.Error())
+		m.string(8, string(ntp.CodeOf(ms.Err)))
+		return m
+	}
+	m.string(2, ms.Addr)
+	m.int64(3, ms.Time.UnixNano())
+	m.int64(4, int64(ms.Offset))
+	m.int64(5, int64(ms.Delay))
+	m.uint(6, uint64(ms.Stratum))
+	return m
+}
+
+// decodeMeasurementsRequest returns the server field of a
+// MeasurementsRequest, skipping fields it does not know.
+func decodeMeasurementsRequest(b []byte) (string, error) {
+	var server string
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return "", errBadMessage
+		}
+		b = b[n:]
+		field, wire := key>>3, key&7
+		switch wire {
+		case 0:
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return "", errBadMessage
+			}
+		case 1:
+			n = 8
+		case 2:
+			l, m := binary.Uvarint(b)
+			if m <= 0 || l > uint64(len(b)-m) {
+				return "", errBadMessage
+			}
+			if field == 1 {
+				server = string(b[m : m+int(l)])
+			}
+			n = m + int(l)
+		case 5:
+			n = 4
+		default:
+			return "", errBadMessage
+		}
+		if n > len(b) {
+			return "", errBadMessage
+		}
+		b = b[n:]
+	}
+	return server, nil
+}
+
+var errBadMessage = errors.New("malformed request message")
+
+// pb accumulates a protobuf message. Fields holding their zero value
+// are left out, as proto3 does.
+type pb []byte
+
+func (m *pb) tag(field, wire int) {
+	*m = binary.AppendUvarint(*m, uint64(field<<3|wire))
+}
+
+func (m *pb) uint(field int, v uint64) {
+	if v != 0 {
+		m.tag(field, 0)
+		*m = binary.AppendUvarint(*m, v)
+	}
+}
+
+func (m *pb) int64(field int, v int64) { m.uint(field, uint64(v)) }
+
+func (m *pb) bool(field int, v bool) {
+	if v {
+		m.uint(field, 1)
+	}
+}
+
+func (m *pb) string(field int, v string) {
+	if v != "" {
+		m.tag(field, 2)
+		*m = binary.AppendUvarint(*m, uint64(len(v)))
+		*m = append(*m, v...)
+	}
+}
+
+func (m *pb) message(field int, v pb) {
+	m.tag(field, 2)
+	*m = binary.AppendUvarint(*m, uint64(len(v)))
+	*m = append(*m, v...)
+}
@@ -0,0 +1,98 @@
+package timeservice
+
+import (
+	"net/http"
+	"time"
+)
+
+// HealthHandler serves the service's health for probes such as those
+// of Kubernetes:
+//
+//	GET /livez   200 until the service is closed
+//	GET /readyz  200 while synchronized to within maxOffset
+//
+// Both answer 503 Service Unavailable otherwise, with the same JSON
+// body: whether the service is synchronized, the offset and the
+// system peer's stratum, leap indicator and root distance, the time
+// of the latest successful poll of any source, and, on failure, why.
+// Readiness takes a selection of agreeing sources, which leaves out
+// those in alarm or with a root distance of ntp.MAXDIST or more; a
+// maxOffset of zero puts no bound on the offset. Point liveness
+// probes at /livez rather than /readyz, so that losing the servers
+// takes the pod out of service instead of restarting it.
+func (s *Service) HealthHandler(maxOffset time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /livez", func(w http.ResponseWriter, r *http.Request) {
+		h := s.health()
+		h.Error = ""
+		code := http.StatusOK
+		if s.ctx.Err() != nil {
+			h.Error = "service closed"
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, h)
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		h := s.health()
+		if h.Error == "" && maxOffset > 0 && absDuration(time.Duration(h.OffsetNanos)) > maxOffset {
+			h.Error = "offset " + time.Duration(h.OffsetNanos).String() + " exceeds " + maxOffset.String()
+		}
+		code := http.StatusOK
+		if h.Error != "" {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, h)
+	})
+	return mux
+}
+
+type healthJSON struct {
+	Synchronized      bool   `json:"synchronized"`
+	Source            string `json:"source,omitempty"`
+	Stratum           int    `json:"stratum"`
+	Leap              int    `json:"leap"`
+	OffsetNanos       int64  `json:"offset_nanos"`
+	RootDistanceNanos int64  `json:"root_distance_nanos"`
+	LastSuccess       string `json:"last_success,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+// health reports the selected source, with Error set if there is none.
+func (s *Service) health() healthJSON {
+	st := s.Status()
+	h := healthJSON{
+		Synchronized:      st.Synchronized,
+		Source:            st.Source,
+		Stratum:           st.Stratum,
+		Leap:              st.Leap,
+		OffsetNanos:       int64(st.Offset),
+		RootDistanceNanos: int64(st.RootDistance),
+	}
+	var last time.Time
+	for _, src := range s.Sources() {
+		if src.Last.Time.After(last) {
+			last = src.Last.Time
+		}
+	}
+	h.LastSuccess = formatTime(last)
+	switch {
+	case s.ctx.Err() != nil:
+		h.Error = "service closed"
+	case !st.Synchronized && last.IsZero():
+		h.Error = "no source has answered yet"
+	case !st.Synchronized && st.Reachable == 0:
+		h.Error = "no source answered its latest poll"
+	case !st.Synchronized:
+		// Sources in alarm or too far from a reference never take
+		// part in the selection, so this covers them too.
+		h.Error = "no majority of synchronized sources agrees"
+	}
+	return h
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,174 @@
+package timeservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+)
+
+// JSONHandler serves the service as JSON over plain HTTP:
+//
+//	GET /v1/time                      corrected time and its error bound
+//	GET /v1/status                    synchronization summary
+//	GET /v1/sources                   every source's latest state
+//	GET /v1/sources/{server}/history  a source's recent polls, oldest first
+//
+// Field names and units follow timeservice.proto. The handler is meant
+// for local clients; serve it on a loopback address unless the network
+// in between is trusted.
+func (s *Service) JSONHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/time", func(w http.ResponseWriter, r *http.Request) {
+		now, maxErr, st := s.Now()
+		writeJSON(w, http.StatusOK, timeJSON{
+			UnixNanos:     now.UnixNano(),
+			Time:          now.UTC().Format(time.RFC3339Nano),
+			OffsetNanos:   int64(st.Offset),
+			MaxErrorNanos: int64(maxErr),
+			Synchronized:  st.Synchronized,
+			Source:        st.Source,
+		})
+	})
+	mux.HandleFunc("GET /v1/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, statusToJSON(s.Status()))
+	})
+	mux.HandleFunc("GET /v1/sources", func(w http.ResponseWriter, r *http.Request) {
+		srcs := s.Sources()
+		out := make([]sourceJSON, len(srcs))
+		for i := range srcs {
+			out[i] = sourceToJSON(&srcs[i])
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"sources": out})
+	})
+	mux.HandleFunc("GET /v1/sources/{server}/history", func(w http.ResponseWriter, r *http.Request) {
+		h := s.History(r.PathValue("server"))
+		if h == nil {
+			writeJSON(w, http.StatusNotFound, errorJSON{Error: "unknown source " + r.PathValue("server")})
+			return
+		}
+		out := make([]measurementJSON, len(h))
+		for i := range h {
+			out[i] = measurementToJSON(&h[i])
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"measurements": out})
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// The JSON forms mirror the messages in timeservice.proto, except that
+// times are also given as RFC 3339 strings for readability.
+
+type timeJSON struct {
+	UnixNanos     int64  `json:"unix_nanos"`
+	Time          string `json:"time"`
+	OffsetNanos   int64  `json:"offset_nanos"`
+	MaxErrorNanos int64  `json:"max_error_nanos"`
+	Synchronized  bool   `json:"synchronized"`
+	Source        string `json:"source,omitempty"`
+}
+
+type statusJSON struct {
+	Synchronized        bool   `json:"synchronized"`
+	Source              string `json:"source,omitempty"`
+	Stratum             int    `json:"stratum"`
+	Leap                int    `json:"leap"`
+	OffsetNanos         int64  `json:"offset_nanos"`
+	DelayNanos          int64  `json:"delay_nanos"`
+	RootDelayNanos      int64  `json:"root_delay_nanos"`
+	RootDispersionNanos int64  `json:"root_dispersion_nanos"`
+	RootDistanceNanos   int64  `json:"root_distance_nanos"`
+	LastUpdate          string `json:"last_update,omitempty"`
+	ReachableSources    int    `json:"reachable_sources"`
+	Sources             int    `json:"sources"`
+}
+
+type sourceJSON struct {
+	Server      string `json:"server"`
+	Address     string `json:"address,omitempty"`
+	Selected    bool   `json:"selected"`
+	Reach       uint8  `json:"reach"`
+	Stratum     int    `json:"stratum"`
+	OffsetNanos int64  `json:"offset_nanos"`
+	DelayNanos  int64  `json:"delay_nanos"`
+	LastSample  string `json:"last_sample,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+}
+
+type measurementJSON struct {
+	Server      string `json:"server"`
+	Address     string `json:"address,omitempty"`
+	Time        string `json:"time,omitempty"`
+	OffsetNanos int64  `json:"offset_nanos,omitempty"`
+	DelayNanos  int64  `json:"delay_nanos,omitempty"`
+	Stratum     int    `json:"stratum,omitempty"`
+	Error       string `json:"error,omitempty"`
+	ErrorCode   string `json:"error_code,omitempty"`
+}
+
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+func statusToJSON(st Status) statusJSON {
+	return statusJSON{
+		Synchronized:        st.Synchronized,
+		Source:              st.Source,
+		Stratum:             st.Stratum,
+		Leap:                st.Leap,
+		OffsetNanos:         int64(st.Offset),
+		DelayNanos:          int64(st.Delay),
+		RootDelayNanos:      int64(st.RootDelay),
+		RootDispersionNanos: int64(st.RootDispersion),
+		RootDistanceNanos:   int64(st.RootDistance),
+		LastUpdate:          formatTime(st.LastUpdate),
+		ReachableSources:    st.Reachable,
+		Sources:             st.Sources,
+	}
+}
+
+func sourceToJSON(src *Source) sourceJSON {
+	j := sourceJSON{
+		Server:      src.Server,
+		Address:     src.Addr,
+		Selected:    src.Selected,
+		Reach:       src.Reach,
+		Stratum:     src.Last.Stratum,
+		OffsetNanos: int64(src.Last.Offset),
+		DelayNanos:  int64(src.Last.Delay),
+		LastSample:  formatTime(src.Last.Time),
+	}
+	if src.LastErr != nil {
+		j.LastError = src.LastErr.Error()
+	}
+	return j
+}
+
+func measurementToJSON(m *Measurement) measurementJSON {
+	if m.Err != nil {
+		return measurementJSON{Server: m.Server, Error: m.Err.Error(), ErrorCode: string(ntp.CodeOf(m.Err))}
+	}
+	return measurementJSON{
+		Server:      m.Server,
+		Address:     m.Addr,
+		Time:        formatTime(m.Time),
+		OffsetNanos: int64(m.Offset),
+		DelayNanos:  int64(m.Delay),
+		Stratum:     m.Stratum,
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,331 @@
+// Package timeservice polls a set of NTP servers and serves what it
+// learns over gRPC: the corrected time, a synchronization summary, the
+// state of each source, and a stream of measurements. The service is
+// defined in timeservice.proto; generate stubs from it for clients in
+// any language. JSONHandler serves the same information, plus each
+// source's recent history, as plain JSON for clients without gRPC, and
+// HealthHandler answers liveness and readiness probes.
+//
+// The module has no dependencies beyond the standard library, so the
+// server side of the protocol is implemented directly on net/http's
+// HTTP/2 support rather than on google.golang.org/grpc. Serve Handler
+// with TLS (ListenAndServeTLS negotiates HTTP/2), or on an http.Server
+// with unencrypted HTTP/2 enabled for plaintext clients.
+package timeservice
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+)
+
+// Config controls a Service. Zero fields take the defaults noted.
+type Config struct {
+	Servers []string
+	// Interval is the time between polls of each server (default
+	// 64s).
+	Interval time.Duration
+	// Timeout bounds the wait for each reply (default 2s).
+	Timeout time.Duration
+	// Version is the NTP version sent (default 4).
+	Version byte
+	// History is the number of polls kept per source (default 64).
+	History int
+}
+
+// Measurement is the outcome of one poll.
+type Measurement struct {
+	Server  string
+	Addr    string
+	Time    time.Time // when the reply arrived
+	Offset  time.Duration
+	Delay   time.Duration
+	Stratum int
+	// Err is set instead of the fields above when the poll failed.
+	Err error
+}
+
+// Source is the state of one server.
+type Source struct {
+	Server   string
+	Addr     string
+	Selected bool
+	// Reach is the reachability register: bit 0 is the latest poll.
+	Reach uint8
+	// Last is the latest successful measurement, and LastErr the error
+	// of the latest poll if it failed.
+	Last    Measurement
+	LastErr error
+	last    *ntp.Response
+	// history holds the latest polls, oldest first.
+	history []Measurement
+}
+
+// Status summarizes synchronization. The service is synchronized
+// while a majority of its reachable sources agree within their root
+// distances, as ntp.Intersect judges; Source, Stratum, Leap, Delay,
+// RootDelay and RootDispersion are then those of the system peer
+// chosen by ntp.Cluster, and Offset is the survivors' combined offset.
+// RootDistance is the system peer's, as given by
+// ntp.Response.RootDistance.
+type Status struct {
+	Synchronized   bool
+	Source         string
+	Stratum        int
+	Leap           int
+	Offset         time.Duration
+	Delay          time.Duration
+	RootDelay      time.Duration
+	RootDispersion time.Duration
+	RootDistance   time.Duration
+	LastUpdate     time.Time
+	Reachable      int
+	Sources        int
+}
+
+// Service polls the configured servers until Close.
+type Service struct {
+	cfg     Config
+	client  ntp.Client
+	mu      sync.Mutex
+	sources []*Source
+	est     *ntp.Estimate // nil without a majority; Peer indexes sources
+	subs    map[*subscriber]struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+type subscriber struct {
+	server string
+	ch     chan Measurement
+}
+
+// New starts polling cfg.Servers.
+func New(cfg Config) *Service {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 64 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.Version == 0 {
+		cfg.Version = 4
+	}
+	if cfg.History <= 0 {
+		cfg.History = 64
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{cfg: cfg, subs: map[*subscriber]struct{}{}, ctx: ctx, cancel: cancel}
+	s.client.ReuseConn = true
+	for _, server := range cfg.Servers {
+		src := &Source{Server: server}
+		s.sources = append(s.sources, src)
+		s.wg.Add(1)
+		go s.poll(src)
+	}
+	return s
+}
+
+// Close stops polling and ends every Measurements stream.
+func (s *Service) Close() {
+	s.cancel()
+	s.wg.Wait()
+	s.client.Close()
+}
+
+func (s *Service) poll(src *Source) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		// The client rejects replies that are unsynchronized, from
+		// servers too far from their reference clocks, or otherwise
+		// unusable, so such polls count as failures.
+		r, err := s.client.QueryContext(s.ctx, src.Server,
+			ntp.WithTimeout(s.cfg.Timeout), ntp.WithVersion(int(s.cfg.Version)))
+		m := Measurement{Server: src.Server, Err: err}
+		if err == nil {
+			m.Addr, m.Time, m.Offset, m.Delay, m.Stratum = r.Addr, r.Received, r.ClockOffset, r.RTT, int(r.Stratum)
+		} else if s.ctx.Err() != nil {
+			return
+		}
+		s.record(src, m, r)
+		select {
+		case <-ticker.C:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// record stores m as the latest poll of src and hands it to the
+// subscribers. A subscriber that is not keeping up misses
+// measurements rather than holding up polling.
+func (s *Service) record(src *Source, m Measurement, r *ntp.Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src.Reach <<= 1
+	src.LastErr = m.Err
+	if m.Err == nil {
+		src.Reach |= 1
+		src.Addr = m.Addr
+		src.Last = m
+		src.last = r
+	}
+	if len(src.history) == s.cfg.History {
+		src.history = append(src.history[:0], src.history[1:]...)
+	}
+	src.history = append(src.history, m)
+	s.selectSource()
+	for sub := range s.subs {
+		if sub.server != "" && sub.server != m.Server {
+			continue
+		}
+		select {
+		case sub.ch <- m:
+		default:
+		}
+	}
+}
+
+// selectSource runs ntp's selection, clustering and combining over
+// the sources whose latest poll succeeded, marks the system peer as
+// selected and keeps the estimate. Sources that are unsynchronized or
+// whose root distance reaches MAXDIST take no part. The caller holds
+// s.mu.
+func (s *Service) selectSource() {
+	var rs []*ntp.Response
+	var jitter []time.Duration
+	var idx []int
+	for i, src := range s.sources {
+		src.Selected = false
+		r := src.last
+		if src.Reach&1 == 0 || r == nil || r.Leap == ntp.LeapAlarm || r.RootDistance() >= ntp.MAXDIST {
+			continue
+		}
+		rs = append(rs, r)
+		jitter = append(jitter, max(src.jitter(), r.Precision))
+		idx = append(idx, i)
+	}
+	s.est = nil
+	x, err := ntp.Intersect(rs)
+	if err != nil {
+		return
+	}
+	e := ntp.Combine(rs, x, ntp.Cluster(rs, x, jitter), jitter)
+	if e == nil {
+		return
+	}
+	e.Peer = idx[e.Peer]
+	for k, i := range e.Survivors {
+		e.Survivors[k] = idx[i]
+	}
+	s.sources[e.Peer].Selected = true
+	s.est = e
+}
+
+// jitterDepth is how many of a source's latest measurements its
+// jitter is taken over.
+const jitterDepth = 8
+
+// jitter is the spread of src's latest successful offsets.
+func (src *Source) jitter() time.Duration {
+	var ss []ntp.Sample
+	for i := len(src.history) - 1; i >= 0 && len(ss) < jitterDepth; i-- {
+		if m := src.history[i]; m.Err == nil {
+			ss = append(ss, ntp.Sample{Offset: m.Offset, Delay: m.Delay, Time: m.Time})
+		}
+	}
+	return ntp.StatsOf(ss).Jitter
+}
+
+// Sources returns a snapshot of every source.
+func (s *Service) Sources() []Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Source, len(s.sources))
+	for i, src := range s.sources {
+		out[i] = *src
+		out[i].history = nil
+	}
+	return out
+}
+
+// History returns the polls of server kept so far, oldest first, or nil
+// if server is not one of the configured servers.
+func (s *Service) History(server string) []Measurement {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, src := range s.sources {
+		if src.Server == server {
+			h := make([]Measurement, len(src.history))
+			copy(h, src.history)
+			return h
+		}
+	}
+	return nil
+}
+
+// Status summarizes the latest selection.
+func (s *Service) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := Status{Sources: len(s.sources)}
+	for _, src := range s.sources {
+		if src.Reach&1 != 0 {
+			st.Reachable++
+		}
+		if !src.Selected {
+			continue
+		}
+		st.Synchronized = true
+		st.Source = src.Server
+		st.Stratum = src.Last.Stratum
+		st.Leap = int(src.last.Leap)
+		st.Offset = s.est.Offset
+		st.Delay = src.Last.Delay
+		st.RootDelay = src.last.RootDelay
+		st.RootDispersion = src.last.RootDispersion
+		st.RootDistance = src.last.RootDistance()
+		st.LastUpdate = src.Last.Time
+	}
+	return st
+}
+
+// Now returns the local time corrected by the combined offset, the
+// bound on its error given the system peer's root distance, and the
+// status it was derived from. Without a selection it returns the
+// local time.
+func (s *Service) Now() (time.Time, time.Duration, Status) {
+	st := s.Status()
+	now := time.Now()
+	if !st.Synchronized {
+		return now, 0, st
+	}
+	return now.Add(st.Offset), st.RootDistance, st
+}
+
+// Subscribe returns a channel of measurements for server, or for every
+// server if it is empty, until ctx is done or the Service is closed.
+func (s *Service) Subscribe(ctx context.Context, server string) <-chan Measurement {
+	sub := &subscriber{server: server, ch: make(chan Measurement, 16)}
+	s.mu.Lock()
+	s.subs[sub] = struct{}{}
+	s.mu.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.ctx.Done():
+		}
+		// record sends with s.mu held, so nothing can be sending once
+		// the subscriber is removed under it.
+		s.mu.Lock()
+		delete(s.subs, sub)
+		close(sub.ch)
+		s.mu.Unlock()
+	}()
+	return sub.ch
+}
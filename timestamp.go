@@ -0,0 +1,190 @@
+package ntp
+
+import (
+	"strconv"
+	"time"
+)
+
+// ToNTPTime converts t to a 64-bit NTP timestamp: seconds since 1900 in
+// the high 32 bits and the fraction of a second in the low 32. The
+// seconds wrap every 136 years, so times from 2036 on land in era 1
+// with small second counts. The zero Time converts to 0, the
+// timestamp NTP uses for "unset".
+func ToNTPTime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	secs := uint64(t.Unix()) + NTP_EPOCH_OFFSET
+	frac := (uint64(t.Nanosecond())<<32 + uint64(time.Second)/2) / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+// EncodeTime is ToNTPTime, named to pair with EncodePacket for code
+// that writes timestamps into packets by hand. The fraction comes
+// from t.Nanosecond() scaled by 2^32/1e9 and rounded.
+func EncodeTime(t time.Time) uint64 {
+	return ToNTPTime(t)
+}
+
+// FromNTPTime converts a 64-bit NTP timestamp to a time. A timestamp
+// carries no era, so the one within 68 years of the local clock is
+// chosen, which keeps working after the seconds wrap in February 2036.
+// The fraction is rounded to the nearest nanosecond, so converting a
+// time to NTP and back is exact. 0 converts to the zero Time. Use
+// FromNTPTimeNear where the local clock may be decades off, e.g. on a
+// device that boots at 1970.
+func FromNTPTime(ts uint64) time.Time {
+	return FromNTPTimeNear(ts, time.Now())
+}
+
+// FromNTPTimeNear is FromNTPTime with the era chosen so that the result
+// is within 68 years of pivot, usually the local clock: each era is
+// 136 years long, so any time known to be roughly right places a
+// timestamp in the right one, whatever the century.
+func FromNTPTimeNear(ts uint64, pivot time.Time) time.Time {
+	if ts == 0 {
+		return time.Time{}
+	}
+	p := pivot.Unix() + int64(NTP_EPOCH_OFFSET)
+	secs := p + int64(int32(uint32(ts>>32)-uint32(p)))
+	nsec := (ts&0xffffffff*uint64(time.Second) + 1<<31) >> 32
+	return time.Unix(secs-int64(NTP_EPOCH_OFFSET), int64(nsec))
+}
+
+// ToNTPShort converts d to the 32-bit NTP short format, 16.16 fixed
+// point seconds, as used for root delay and dispersion. Negative
+// durations convert to 0 and ones too long for 16 bits of seconds to
+// the largest value.
+func ToNTPShort(d time.Duration) uint32 {
+	if d <= 0 {
+		return 0
+	}
+	if d >= 1<<16*time.Second {
+		return 0xffffffff
+	}
+	return uint32(min((uint64(d)<<16+uint64(time.Second)/2)/uint64(time.Second), 0xffffffff))
+}
+
+// FromNTPShort converts a 32-bit NTP short format value to a duration.
+func FromNTPShort(v uint32) time.Duration {
+	return time.Duration((uint64(v)*uint64(time.Second) + 1<<15) >> 16)
+}
+
+// NTPTime is a 64-bit NTP timestamp, 32.32 fixed point seconds since
+// the start of the current era.
+type NTPTime uint64
+
+// Time64 is NTPTime under the name RFC 5905 uses for the 64-bit
+// timestamp format. Convert with NewNTPTime and Time, or TimeNear.
+type Time64 = NTPTime
+
+// NewNTPTime returns the NTP timestamp of t; see ToNTPTime.
+func NewNTPTime(t time.Time) NTPTime {
+	return NTPTime(ToNTPTime(t))
+}
+
+// Time returns the time t stands for; see FromNTPTime.
+func (t NTPTime) Time() time.Time {
+	return FromNTPTime(uint64(t))
+}
+
+// TimeNear returns the time t stands for in the era nearest pivot; see
+// FromNTPTimeNear.
+func (t NTPTime) TimeNear(pivot time.Time) time.Time {
+	return FromNTPTimeNear(uint64(t), pivot)
+}
+
+// Seconds returns the whole seconds part of t.
+func (t NTPTime) Seconds() uint32 {
+	return uint32(t >> 32)
+}
+
+// Fraction returns the fractional part of t in units of 2^-32 seconds.
+func (t NTPTime) Fraction() uint32 {
+	return uint32(t)
+}
+
+// Add returns t+d, wrapping into the next or previous era as needed.
+func (t NTPTime) Add(d time.Duration) NTPTime {
+	return t + NTPTime(fixed32(d))
+}
+
+// Sub returns t-u. The timestamps must be less than 68 years apart,
+// which makes the difference correct across an era boundary too.
+func (t NTPTime) Sub(u NTPTime) time.Duration {
+	d := int64(t - u)
+	secs := d >> 32
+	frac := d & 0xffffffff
+	return time.Duration(secs)*time.Second + time.Duration((frac*int64(time.Second)+1<<31)>>32)
+}
+
+// String formats t the way ntpq does, as hexadecimal seconds and
+// fraction, followed by the UTC time it stands for.
+func (t NTPTime) String() string {
+	s := hex8(t.Seconds()) + "." + hex8(t.Fraction())
+	if t == 0 {
+		return s
+	}
+	return s + " " + t.Time().UTC().Format(time.RFC3339Nano)
+}
+
+// NTPShort is a value in the 32-bit NTP short format, 16.16 fixed point
+// seconds, as used for root delay and dispersion.
+type NTPShort uint32
+
+// Time32 is NTPShort under the name used for the 32-bit short format.
+// Convert with NewNTPShort and Duration.
+type Time32 = NTPShort
+
+// NewNTPShort returns d in short format; see ToNTPShort.
+func NewNTPShort(d time.Duration) NTPShort {
+	return NTPShort(ToNTPShort(d))
+}
+
+// Duration returns s as a duration.
+func (s NTPShort) Duration() time.Duration {
+	return FromNTPShort(uint32(s))
+}
+
+// Add returns s+d, clamped to the range of the format.
+func (s NTPShort) Add(d time.Duration) NTPShort {
+	return NewNTPShort(s.Duration() + d)
+}
+
+// Sub returns s-u.
+func (s NTPShort) Sub(u NTPShort) time.Duration {
+	return s.Duration() - u.Duration()
+}
+
+func (s NTPShort) String() string {
+	return s.Duration().String()
+}
+
+// fixed32 converts d to 32.32 fixed point seconds, in two's complement
+// when negative.
+func fixed32(d time.Duration) uint64 {
+	secs, ns := int64(d/time.Second), int64(d%time.Second)
+	if ns < 0 {
+		secs--
+		ns += int64(time.Second)
+	}
+	frac := (uint64(ns)<<32 + uint64(time.Second)/2) / uint64(time.Second)
+	return uint64(secs)<<32 + frac
+}
+
+// hex8 formats v as eight hexadecimal digits without going through fmt.
+func hex8(v uint32) string {
+	s := strconv.FormatUint(uint64(v), 16)
+	return "00000000"[len(s):] + s
+}
+
+// OnWire computes the clock offset and round-trip delay of RFC 5905
+// section 8 from the four timestamps of an exchange: t1 when the
+// client sent the request, t2 when the server received it, t3 when the
+// server sent the reply and t4 when the client received it. The offset
+// is ((t2-t1)+(t3-t4))/2, positive when the server's clock is ahead,
+// and the delay (t4-t1)-(t3-t2), the round trip less the server's
+// processing time.
+func OnWire(t1, t2, t3, t4 time.Time) (offset, delay time.Duration) {
+	return (t2.Sub(t1) + t3.Sub(t4)) / 2, t4.Sub(t1) - t3.Sub(t2)
+}
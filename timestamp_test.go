@@ -0,0 +1,84 @@
+package ntp_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+	"github.com/chaitanyav/ntp/ntptest"
+)
+
+// wrap is when the seconds of NTP era 0 run out.
+var wrap = time.Date(2036, 2, 7, 6, 28, 16, 0, time.UTC)
+
+func TestNTPTimeRoundTrip(t *testing.T) {
+	for _, tm := range []time.Time{
+		time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2000, 2, 29, 12, 0, 0, 123456789, time.UTC),
+		// The wrap itself encodes as 0, which means "unset".
+		wrap.Add(-time.Nanosecond),
+		wrap.Add(time.Nanosecond),
+		time.Date(2100, 1, 1, 0, 0, 0, 999999999, time.UTC),
+	} {
+		ts := ntp.ToNTPTime(tm)
+		if got := ntp.FromNTPTimeNear(ts, tm); !got.Equal(tm) {
+			t.Errorf("FromNTPTimeNear(ToNTPTime(%v)) = %v", tm, got)
+		}
+		if got := ntp.NTPTime(ts).TimeNear(tm.AddDate(-30, 0, 0)); !got.Equal(tm) {
+			t.Errorf("%v decoded 30 years early = %v", tm, got)
+		}
+		if got := ntp.NTPTime(ts).TimeNear(tm.AddDate(30, 0, 0)); !got.Equal(tm) {
+			t.Errorf("%v decoded 30 years late = %v", tm, got)
+		}
+	}
+}
+
+func TestNTPTimeEra(t *testing.T) {
+	after := wrap.Add(time.Hour)
+	ts := ntp.ToNTPTime(after)
+	if secs := ts >> 32; secs != 3600 {
+		t.Fatalf("%v has %d seconds, want 3600 in era 1", after, secs)
+	}
+	// The same timestamp read near 1900 is an hour into era 0.
+	era0 := time.Date(1900, 1, 1, 1, 0, 0, 0, time.UTC)
+	if got := ntp.FromNTPTimeNear(ts, era0.AddDate(10, 0, 0)); !got.Equal(era0) {
+		t.Errorf("read in era 0: %v, want %v", got, era0)
+	}
+	if got := ntp.FromNTPTimeNear(ts, wrap.AddDate(-1, 0, 0)); !got.Equal(after) {
+		t.Errorf("read a year before the wrap: %v, want %v", got, after)
+	}
+	if got := ntp.FromNTPTimeNear(0, wrap); !got.IsZero() {
+		t.Errorf("FromNTPTimeNear(0) = %v, want the zero Time", got)
+	}
+}
+
+func TestNTPTimeSubAcrossWrap(t *testing.T) {
+	before, after := ntp.NewNTPTime(wrap.Add(-time.Second)), ntp.NewNTPTime(wrap.Add(time.Second))
+	if d := after.Sub(before); d != 2*time.Second {
+		t.Errorf("Sub across the wrap = %v, want 2s", d)
+	}
+	if d := before.Sub(after); d != -2*time.Second {
+		t.Errorf("Sub across the wrap = %v, want -2s", d)
+	}
+}
+
+// TestQueryAcrossWrap runs an exchange in which the request leaves in
+// era 0 and the reply is stamped in era 1.
+func TestQueryAcrossWrap(t *testing.T) {
+	local := ntptest.NewClock(wrap.Add(-time.Second))
+	server := ntptest.NewClock(wrap.Add(time.Second))
+	srv := ntptest.NewServer(ntptest.Config{Clock: server})
+	defer srv.Close()
+	c := &ntp.Client{Clock: local}
+	r, err := c.QueryContext(context.Background(), srv.Addr, ntp.WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ClockOffset != 2*time.Second {
+		t.Errorf("ClockOffset = %v, want 2s", r.ClockOffset)
+	}
+	if !r.Time.Equal(wrap.Add(time.Second)) {
+		t.Errorf("Time = %v, want %v", r.Time, wrap.Add(time.Second))
+	}
+}
@@ -0,0 +1,111 @@
+package ntp
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer puts queries into distributed traces. Each query is a span
+// named "ntp.query", started in the query's context and carrying the
+// attributes ntp.server and, once a reply is in, ntp.stratum,
+// ntp.offset and ntp.rtt (in seconds), or ntp.error.code if it failed.
+// Its children "ntp.dial", "ntp.send", "ntp.receive" and "ntp.decode"
+// time the stages of the exchange; they are started after the fact,
+// with the times the stages began and ended, so that nothing on the
+// measurement path waits for the tracer.
+//
+// The interface is small enough to sit over any tracing library
+// without the package depending on it. For OpenTelemetry:
+//
+//	type otelTracer struct{ t trace.Tracer }
+//
+//	func (o otelTracer) Start(ctx context.Context, name string, start time.Time) (context.Context, ntp.Span) {
+//		ctx, s := o.t.Start(ctx, name, trace.WithTimestamp(start))
+//		return ctx, otelSpan{s}
+//	}
+//
+//	type otelSpan struct{ s trace.Span }
+//
+//	func (o otelSpan) SetAttribute(key string, v any) {
+//		switch v := v.(type) {
+//		case string:
+//			o.s.SetAttributes(attribute.String(key, v))
+//		case int64:
+//			o.s.SetAttributes(attribute.Int64(key, v))
+//		case float64:
+//			o.s.SetAttributes(attribute.Float64(key, v))
+//		}
+//	}
+//
+//	func (o otelSpan) End(end time.Time, err error) {
+//		if err != nil {
+//			o.s.RecordError(err)
+//			o.s.SetStatus(codes.Error, err.Error())
+//		}
+//		o.s.End(trace.WithTimestamp(end))
+//	}
+type Tracer interface {
+	// Start begins a span named name at start, as a child of any span
+	// in ctx, and returns a context holding it.
+	Start(ctx context.Context, name string, start time.Time) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetAttribute records an attribute whose value is a string, an
+	// int64 or a float64.
+	SetAttribute(key string, value any)
+	// End ends the span at end, failed if err is not nil.
+	End(end time.Time, err error)
+}
+
+// queryTrace collects the stage times of one traced exchange; a nil
+// *queryTrace traces nothing.
+type queryTrace struct {
+	tracer  Tracer
+	ctx     context.Context
+	written time.Time // when Write returned
+}
+
+// stage adds a finished child span to the query's span.
+func (t *queryTrace) stage(name string, start, end time.Time, err error) {
+	if t == nil {
+		return
+	}
+	_, s := t.tracer.Start(t.ctx, name, start)
+	s.End(end, err)
+}
+
+// roundTrip adds the send and receive spans of a round trip that began
+// at start and has just ended, with err if it failed.
+func (t *queryTrace) roundTrip(start time.Time, err error) {
+	now := time.Now()
+	if t.written.IsZero() {
+		// The request never went out.
+		t.stage("ntp.send", start, now, err)
+		return
+	}
+	t.stage("ntp.send", start, t.written, nil)
+	t.stage("ntp.receive", t.written, now, err)
+}
+
+// startQuery starts the "ntp.query" span of an exchange with server, if
+// c has a Tracer. The returned function ends it with the exchange's
+// outcome.
+func (c *Client) startQuery(ctx context.Context, server string) (*queryTrace, func(*Response, error)) {
+	if c.Tracer == nil {
+		return nil, nil
+	}
+	ctx, span := c.Tracer.Start(ctx, "ntp.query", time.Now())
+	span.SetAttribute("ntp.server", server)
+	return &queryTrace{tracer: c.Tracer, ctx: ctx}, func(r *Response, err error) {
+		if err != nil {
+			span.SetAttribute("ntp.error.code", string(CodeOf(err)))
+		} else {
+			span.SetAttribute("ntp.stratum", int64(r.Stratum))
+			span.SetAttribute("ntp.offset", r.ClockOffset.Seconds())
+			span.SetAttribute("ntp.rtt", r.RTT.Seconds())
+		}
+		span.End(time.Now(), err)
+	}
+}
@@ -0,0 +1,75 @@
+package ntp
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// Transport carries one NTP exchange for hosts without UDP sockets,
+// such as GOOS=js in a browser or a wasip1 plugin: it sends the request
+// datagram req to server by whatever means the host has (a WebSocket
+// relay, a host function call) and reads the reply into resp,
+// returning its length. req and resp may share memory, so req must be
+// sent or copied before resp is written. Timeouts are up to the host.
+type Transport func(server string, req, resp []byte) (int, error)
+
+var (
+	errNoRequest = newError(NTP_ERR_CONFIG, "ntp: transport read before a request was written")
+	errOrigin    = newError(NTP_ERR_ORIGIN, "ntp: reply does not answer the request")
+)
+
+// QueryTransport is QueryFast over t instead of a socket.
+func QueryTransport(t Transport, server string, buf []byte) (offset, delay time.Duration, err error) {
+	defer func() { err = withCode(err) }()
+	if len(buf) < PACKET_SIZE {
+		return 0, 0, errShortBuffer
+	}
+	t1, xmit := fastRequest(buf)
+	n, err := t(server, buf[:PACKET_SIZE], buf)
+	if err != nil {
+		return 0, 0, err
+	}
+	t4 := time.Now()
+	if n < PACKET_SIZE || NTPTime(binary.BigEndian.Uint64(buf[24:])) != xmit {
+		return 0, 0, errOrigin
+	}
+	return fastResult(server, buf, t1, t4)
+}
+
+// transportConn presents a Transport as the connected datagram socket
+// that Client expects: Write holds the request and the next Read
+// performs the exchange.
+type transportConn struct {
+	t      Transport
+	server string
+	req    [PACKET_SIZE]byte
+	n      int
+}
+
+func (c *transportConn) Write(b []byte) (int, error) {
+	c.n = copy(c.req[:], b)
+	return len(b), nil
+}
+
+func (c *transportConn) Read(b []byte) (int, error) {
+	if c.n == 0 {
+		return 0, errNoRequest
+	}
+	n := c.n
+	c.n = 0
+	return c.t(c.server, c.req[:n], b)
+}
+
+func (c *transportConn) Close() error                       { return nil }
+func (c *transportConn) LocalAddr() net.Addr                { return transportAddr("local") }
+func (c *transportConn) RemoteAddr() net.Addr               { return transportAddr(c.server) }
+func (c *transportConn) SetDeadline(t time.Time) error      { return nil }
+func (c *transportConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *transportConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// transportAddr is the address of either end of a transportConn.
+type transportAddr string
+
+func (a transportAddr) Network() string { return "transport" }
+func (a transportAddr) String() string  { return string(a) }
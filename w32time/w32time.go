@@ -0,0 +1,234 @@
+// Package w32time reports on the Windows Time service (W32Time) and
+// formats synchronization status the way "w32tm /query /status" does.
+//
+// On Windows, Service tells whether W32Time is running and configured to
+// discipline the clock; when it is, tools in this module should only
+// monitor and leave the clock alone. Status can be read from w32tm or
+// built from an NTP reply, so Windows and other hosts in a fleet report
+// in the same shape.
+package w32time
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaitanyav/ntp"
+)
+
+// ErrUnsupported is returned by Query and QueryService on platforms
+// other than Windows.
+var ErrUnsupported = errors.New("w32time: only available on Windows")
+
+// Service is the state and configuration of the Windows Time service.
+type Service struct {
+	Running bool
+	// Type is the synchronization type from the service parameters:
+	// "NTP", "NT5DS" (domain hierarchy), "AllSync" or "NoSync".
+	Type string
+	// NtpServer is the configured server list, as in the registry.
+	NtpServer string
+	// ClientEnabled reports whether the NtpClient time provider is
+	// enabled.
+	ClientEnabled bool
+}
+
+// OwnsClock reports whether the service is actively disciplining the
+// system clock, in which case another synchronizer should not adjust it.
+func (s *Service) OwnsClock() bool {
+	return s.Running && s.ClientEnabled && !strings.EqualFold(s.Type, "NoSync")
+}
+
+// Status is the synchronization status reported by w32tm /query
+// /status.
+type Status struct {
+	Leap           byte
+	Stratum        byte
+	Precision      int8
+	RootDelay      time.Duration
+	RootDispersion time.Duration
+	ReferenceID    uint32
+	LastSync       time.Time
+	Source         string
+	// Poll is the poll interval as log2 seconds.
+	Poll int8
+
+	// Raw holds every line of w32tm output by key, including those
+	// without a field above.
+	Raw map[string]string
+}
+
+// FromPacket builds a Status from a server reply received at lastSync,
+// as if this host were synchronized to source.
+func FromPacket(p *ntp.DataPacket, source string, lastSync time.Time) *Status {
+	return &Status{
+		Leap:           byte(p.Leap()),
+		Stratum:        p.Stratum,
+		Precision:      p.Precision,
+		RootDelay:      p.RootDelay.Duration(),
+		RootDispersion: p.RootDispersion.Duration(),
+		ReferenceID:    p.ReferenceIdentifier,
+		LastSync:       lastSync,
+		Source:         source,
+		Poll:           p.Poll,
+	}
+}
+
+// syncTimeLayouts are the forms of "Last Successful Sync Time" seen
+// with common regional settings.
+var syncTimeLayouts = []string{
+	"1/2/2006 3:04:05 PM",
+	"2/1/2006 15:04:05",
+	"2006-01-02 15:04:05",
+	"02.01.2006 15:04:05",
+}
+
+// ParseStatus reads the output of w32tm /query /status. Fields that
+// are missing or in an unexpected form are left zero; their text is
+// still in Raw.
+func ParseStatus(r io.Reader) (*Status, error) {
+	s := &Status{Raw: map[string]string{}}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		key, val, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		s.Raw[key] = val
+		switch key {
+		case "Leap Indicator":
+			s.Leap = byte(leadingInt(val))
+		case "Stratum":
+			s.Stratum = byte(leadingInt(val))
+		case "Precision":
+			s.Precision = int8(leadingInt(val))
+		case "Root Delay":
+			s.RootDelay, _ = time.ParseDuration(val)
+		case "Root Dispersion":
+			s.RootDispersion, _ = time.ParseDuration(val)
+		case "ReferenceId":
+			f, _, _ := strings.Cut(val, " ")
+			id, _ := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(f), "0x"), 16, 32)
+			s.ReferenceID = uint32(id)
+		case "Last Successful Sync Time":
+			for _, layout := range syncTimeLayouts {
+				if t, err := time.ParseInLocation(layout, val, time.Local); err == nil {
+					s.LastSync = t
+					break
+				}
+			}
+		case "Source":
+			s.Source = val
+		case "Poll Interval":
+			s.Poll = int8(leadingInt(val))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.Raw) == 0 {
+		return nil, errors.New("w32time: no status in w32tm output")
+	}
+	return s, nil
+}
+
+// leadingInt parses the integer at the start of s, as in "3 (secondary
+// reference - syncd by (S)NTP)".
+func leadingInt(s string) int {
+	end := 0
+	for end < len(s) && (s[end] == '-' && end == 0 || s[end] >= '0' && s[end] <= '9') {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
+
+// Format writes s in the layout of w32tm /query /status.
+func (s *Status) Format(w io.Writer) error {
+	last := "unspecified"
+	if !s.LastSync.IsZero() {
+		last = s.LastSync.Local().Format("1/2/2006 3:04:05 PM")
+	}
+	_, err := fmt.Fprintf(w, "Leap Indicator: %d(%s)\n"+
+		"Stratum: %d (%s)\n"+
+		"Precision: %d (%s per tick)\n"+
+		"Root Delay: %.7fs\n"+
+		"Root Dispersion: %.7fs\n"+
+		"ReferenceId: 0x%08X (%s)\n"+
+		"Last Successful Sync Time: %s\n"+
+		"Source: %s\n"+
+		"Poll Interval: %d (%ds)\n",
+		s.Leap, leapText(s.Leap),
+		s.Stratum, stratumText(s.Stratum),
+		s.Precision, precision(s.Precision),
+		s.RootDelay.Seconds(),
+		s.RootDispersion.Seconds(),
+		s.ReferenceID, s.refIDText(),
+		last,
+		s.Source,
+		s.Poll, pollSeconds(s.Poll))
+	return err
+}
+
+func leapText(li byte) string {
+	switch li {
+	case 0:
+		return "no warning"
+	case 1:
+		return "last minute has 61 seconds"
+	case 2:
+		return "last minute has 59 seconds"
+	}
+	return "not synchronized"
+}
+
+func stratumText(st byte) string {
+	switch {
+	case st == 0:
+		return "unspecified"
+	case st == 1:
+		return "primary reference - syncd by radio clock"
+	case st < 16:
+		return "secondary reference - syncd by (S)NTP"
+	}
+	return "unsynchronized"
+}
+
+// precision formats a tick of 2^p seconds as w32tm does, with three
+// decimals in the largest unit that keeps it at least 1.
+func precision(p int8) string {
+	sec := math.Ldexp(1, int(p))
+	switch {
+	case sec < 1e-6:
+		return fmt.Sprintf("%.3fns", sec*1e9)
+	case sec < 1e-3:
+		return fmt.Sprintf("%.3fus", sec*1e6)
+	case sec < 1:
+		return fmt.Sprintf("%.3fms", sec*1e3)
+	}
+	return fmt.Sprintf("%.3fs", sec)
+}
+
+func pollSeconds(p int8) int64 {
+	if p < 0 || p > 62 {
+		return 0
+	}
+	return 1 << p
+}
+
+// refIDText describes the reference ID as w32tm does: the source's IPv4
+// address for secondary servers, the reference code for primary ones.
+func (s *Status) refIDText() string {
+	id := s.ReferenceID
+	if s.Stratum <= 1 {
+		b := []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+		return "source name:  \"" + strings.TrimRight(string(b), "\x00") + "\""
+	}
+	return fmt.Sprintf("source IP:  %d.%d.%d.%d", byte(id>>24), byte(id>>16), byte(id>>8), byte(id))
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package w32time
+
+// Query reads the status of the Windows Time service.
+func Query() (*Status, error) {
+	return nil, ErrUnsupported
+}
+
+// QueryService reads the state and configuration of the Windows Time
+// service.
+func QueryService() (*Service, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,146 @@
+//go:build windows
+
+package w32time
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32               = syscall.NewLazyDLL("advapi32.dll")
+	procOpenSCManagerW     = advapi32.NewProc("OpenSCManagerW")
+	procOpenServiceW       = advapi32.NewProc("OpenServiceW")
+	procQueryServiceStatus = advapi32.NewProc("QueryServiceStatus")
+	procCloseServiceHandle = advapi32.NewProc("CloseServiceHandle")
+)
+
+const (
+	scManagerConnect    = 0x0001
+	serviceQueryStatus  = 0x0004
+	serviceRunningState = 4
+
+	errorServiceDoesNotExist syscall.Errno = 1060
+
+	parametersKey = `SYSTEM\CurrentControlSet\Services\W32Time\Parameters`
+	ntpClientKey  = `SYSTEM\CurrentControlSet\Services\W32Time\TimeProviders\NtpClient`
+)
+
+// serviceStatus is SERVICE_STATUS.
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+// Query runs w32tm /query /status and parses its output.
+func Query() (*Status, error) {
+	out, err := exec.Command("w32tm", "/query", "/status").Output()
+	if err != nil {
+		return nil, err
+	}
+	return ParseStatus(bytes.NewReader(out))
+}
+
+// QueryService reads the state of the W32Time service from the service
+// control manager and its configuration from the registry. A system
+// without the service reports it as not running.
+func QueryService() (*Service, error) {
+	s := &Service{}
+	running, err := serviceRunning("W32Time")
+	if err != nil {
+		if errors.Is(err, errorServiceDoesNotExist) {
+			return s, nil
+		}
+		return nil, err
+	}
+	s.Running = running
+	s.Type, _ = regString(parametersKey, "Type")
+	s.NtpServer, _ = regString(parametersKey, "NtpServer")
+	enabled, err := regDWORD(ntpClientKey, "Enabled")
+	// The provider is enabled by default when the value is absent.
+	s.ClientEnabled = err != nil || enabled != 0
+	return s, nil
+}
+
+func serviceRunning(name string) (bool, error) {
+	scm, _, err := procOpenSCManagerW.Call(0, 0, scManagerConnect)
+	if scm == 0 {
+		return false, err
+	}
+	defer procCloseServiceHandle.Call(scm)
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return false, err
+	}
+	svc, _, err := procOpenServiceW.Call(scm, uintptr(unsafe.Pointer(p)), serviceQueryStatus)
+	if svc == 0 {
+		return false, err
+	}
+	defer procCloseServiceHandle.Call(svc)
+	var st serviceStatus
+	if r, _, err := procQueryServiceStatus.Call(svc, uintptr(unsafe.Pointer(&st))); r == 0 {
+		return false, err
+	}
+	return st.CurrentState == serviceRunningState, nil
+}
+
+func openKey(path string) (syscall.Handle, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var k syscall.Handle
+	err = syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE, p, 0, syscall.KEY_READ, &k)
+	return k, err
+}
+
+func regString(path, name string) (string, error) {
+	k, err := openKey(path)
+	if err != nil {
+		return "", err
+	}
+	defer syscall.RegCloseKey(k)
+	n, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return "", err
+	}
+	var typ uint32
+	buf := make([]uint16, 256)
+	size := uint32(len(buf) * 2)
+	if err := syscall.RegQueryValueEx(k, n, nil, &typ, (*byte)(unsafe.Pointer(&buf[0])), &size); err != nil {
+		return "", err
+	}
+	if typ != syscall.REG_SZ && typ != syscall.REG_EXPAND_SZ {
+		return "", errors.New("w32time: " + name + " is not a string")
+	}
+	return syscall.UTF16ToString(buf[:size/2]), nil
+}
+
+func regDWORD(path, name string) (uint32, error) {
+	k, err := openKey(path)
+	if err != nil {
+		return 0, err
+	}
+	defer syscall.RegCloseKey(k)
+	n, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	var typ, v uint32
+	size := uint32(4)
+	if err := syscall.RegQueryValueEx(k, n, nil, &typ, (*byte)(unsafe.Pointer(&v)), &size); err != nil {
+		return 0, err
+	}
+	if typ != syscall.REG_DWORD {
+		return 0, errors.New("w32time: " + name + " is not a DWORD")
+	}
+	return v, nil
+}